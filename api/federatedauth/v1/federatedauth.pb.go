@@ -0,0 +1,761 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: federatedauth/v1/federatedauth.proto
+
+package federatedauthv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ReviewRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	Audiences     []string               `protobuf:"bytes,2,rep,name=audiences,proto3" json:"audiences,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReviewRequest) Reset() {
+	*x = ReviewRequest{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReviewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReviewRequest) ProtoMessage() {}
+
+func (x *ReviewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReviewRequest.ProtoReflect.Descriptor instead.
+func (*ReviewRequest) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ReviewRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *ReviewRequest) GetAudiences() []string {
+	if x != nil {
+		return x.Audiences
+	}
+	return nil
+}
+
+type ReviewResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        *ReviewStatus          `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReviewResponse) Reset() {
+	*x = ReviewResponse{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReviewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReviewResponse) ProtoMessage() {}
+
+func (x *ReviewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReviewResponse.ProtoReflect.Descriptor instead.
+func (*ReviewResponse) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReviewResponse) GetStatus() *ReviewStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+// ReviewStatus is the status of a TokenReview
+type ReviewStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Authenticated bool                   `protobuf:"varint,1,opt,name=authenticated,proto3" json:"authenticated,omitempty"`
+	User          *UserInfo              `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	Audiences     []string               `protobuf:"bytes,3,rep,name=audiences,proto3" json:"audiences,omitempty"`
+	// Error is why the token couldn't be reviewed, with its error code, e.g.
+	// "detection_failed"
+	Error string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Code  string `protobuf:"bytes,5,opt,name=code,proto3" json:"code,omitempty"`
+	// Cluster is the source cluster of an authenticated token
+	Cluster       string `protobuf:"bytes,6,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReviewStatus) Reset() {
+	*x = ReviewStatus{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReviewStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReviewStatus) ProtoMessage() {}
+
+func (x *ReviewStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReviewStatus.ProtoReflect.Descriptor instead.
+func (*ReviewStatus) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReviewStatus) GetAuthenticated() bool {
+	if x != nil {
+		return x.Authenticated
+	}
+	return false
+}
+
+func (x *ReviewStatus) GetUser() *UserInfo {
+	if x != nil {
+		return x.User
+	}
+	return nil
+}
+
+func (x *ReviewStatus) GetAudiences() []string {
+	if x != nil {
+		return x.Audiences
+	}
+	return nil
+}
+
+func (x *ReviewStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ReviewStatus) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *ReviewStatus) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+type UserInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Uid           string                 `protobuf:"bytes,2,opt,name=uid,proto3" json:"uid,omitempty"`
+	Groups        []string               `protobuf:"bytes,3,rep,name=groups,proto3" json:"groups,omitempty"`
+	Extra         map[string]*ExtraValue `protobuf:"bytes,4,rep,name=extra,proto3" json:"extra,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserInfo) Reset() {
+	*x = UserInfo{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserInfo) ProtoMessage() {}
+
+func (x *UserInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserInfo.ProtoReflect.Descriptor instead.
+func (*UserInfo) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UserInfo) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UserInfo) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+func (x *UserInfo) GetGroups() []string {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *UserInfo) GetExtra() map[string]*ExtraValue {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+type ExtraValue struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []string               `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExtraValue) Reset() {
+	*x = ExtraValue{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExtraValue) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtraValue) ProtoMessage() {}
+
+func (x *ExtraValue) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtraValue.ProtoReflect.Descriptor instead.
+func (*ExtraValue) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ExtraValue) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type BatchReviewRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reviews       []*ReviewRequest       `protobuf:"bytes,1,rep,name=reviews,proto3" json:"reviews,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchReviewRequest) Reset() {
+	*x = BatchReviewRequest{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchReviewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchReviewRequest) ProtoMessage() {}
+
+func (x *BatchReviewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchReviewRequest.ProtoReflect.Descriptor instead.
+func (*BatchReviewRequest) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchReviewRequest) GetReviews() []*ReviewRequest {
+	if x != nil {
+		return x.Reviews
+	}
+	return nil
+}
+
+// BatchReviewResponse has the status of each review of the request, in order
+type BatchReviewResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Statuses      []*ReviewStatus        `protobuf:"bytes,1,rep,name=statuses,proto3" json:"statuses,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchReviewResponse) Reset() {
+	*x = BatchReviewResponse{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchReviewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchReviewResponse) ProtoMessage() {}
+
+func (x *BatchReviewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchReviewResponse.ProtoReflect.Descriptor instead.
+func (*BatchReviewResponse) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BatchReviewResponse) GetStatuses() []*ReviewStatus {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+type ListClustersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClustersRequest) Reset() {
+	*x = ListClustersRequest{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClustersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClustersRequest) ProtoMessage() {}
+
+func (x *ListClustersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClustersRequest.ProtoReflect.Descriptor instead.
+func (*ListClustersRequest) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{7}
+}
+
+type ListClustersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Clusters      []*ClusterInfo         `protobuf:"bytes,1,rep,name=clusters,proto3" json:"clusters,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClustersResponse) Reset() {
+	*x = ListClustersResponse{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClustersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClustersResponse) ProtoMessage() {}
+
+func (x *ListClustersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClustersResponse.ProtoReflect.Descriptor instead.
+func (*ListClustersResponse) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListClustersResponse) GetClusters() []*ClusterInfo {
+	if x != nil {
+		return x.Clusters
+	}
+	return nil
+}
+
+type ClusterInfo struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Name                  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Issuer                string                 `protobuf:"bytes,2,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	ApiServer             string                 `protobuf:"bytes,3,opt,name=api_server,json=apiServer,proto3" json:"api_server,omitempty"`
+	InsecureSkipTlsVerify bool                   `protobuf:"varint,4,opt,name=insecure_skip_tls_verify,json=insecureSkipTlsVerify,proto3" json:"insecure_skip_tls_verify,omitempty"`
+	TokenStatus           *TokenStatus           `protobuf:"bytes,5,opt,name=token_status,json=tokenStatus,proto3" json:"token_status,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *ClusterInfo) Reset() {
+	*x = ClusterInfo{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterInfo) ProtoMessage() {}
+
+func (x *ClusterInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterInfo.ProtoReflect.Descriptor instead.
+func (*ClusterInfo) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ClusterInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ClusterInfo) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *ClusterInfo) GetApiServer() string {
+	if x != nil {
+		return x.ApiServer
+	}
+	return ""
+}
+
+func (x *ClusterInfo) GetInsecureSkipTlsVerify() bool {
+	if x != nil {
+		return x.InsecureSkipTlsVerify
+	}
+	return false
+}
+
+func (x *ClusterInfo) GetTokenStatus() *TokenStatus {
+	if x != nil {
+		return x.TokenStatus
+	}
+	return nil
+}
+
+type TokenStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Type is "token" or "client_certificate"
+	Type      string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	ExpiresAt string `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	ExpiresIn string `protobuf:"bytes,3,opt,name=expires_in,json=expiresIn,proto3" json:"expires_in,omitempty"`
+	// Status is "valid", "expiring_soon", "expired" or "unknown"
+	Status        string `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenStatus) Reset() {
+	*x = TokenStatus{}
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenStatus) ProtoMessage() {}
+
+func (x *TokenStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_federatedauth_v1_federatedauth_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenStatus.ProtoReflect.Descriptor instead.
+func (*TokenStatus) Descriptor() ([]byte, []int) {
+	return file_federatedauth_v1_federatedauth_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *TokenStatus) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TokenStatus) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *TokenStatus) GetExpiresIn() string {
+	if x != nil {
+		return x.ExpiresIn
+	}
+	return ""
+}
+
+func (x *TokenStatus) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+var File_federatedauth_v1_federatedauth_proto protoreflect.FileDescriptor
+
+const file_federatedauth_v1_federatedauth_proto_rawDesc = "" +
+	"\n" +
+	"$federatedauth/v1/federatedauth.proto\x12\x10federatedauth.v1\"C\n" +
+	"\rReviewRequest\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\x12\x1c\n" +
+	"\taudiences\x18\x02 \x03(\tR\taudiences\"H\n" +
+	"\x0eReviewResponse\x126\n" +
+	"\x06status\x18\x01 \x01(\v2\x1e.federatedauth.v1.ReviewStatusR\x06status\"\xc6\x01\n" +
+	"\fReviewStatus\x12$\n" +
+	"\rauthenticated\x18\x01 \x01(\bR\rauthenticated\x12.\n" +
+	"\x04user\x18\x02 \x01(\v2\x1a.federatedauth.v1.UserInfoR\x04user\x12\x1c\n" +
+	"\taudiences\x18\x03 \x03(\tR\taudiences\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x12\n" +
+	"\x04code\x18\x05 \x01(\tR\x04code\x12\x18\n" +
+	"\acluster\x18\x06 \x01(\tR\acluster\"\xe5\x01\n" +
+	"\bUserInfo\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x10\n" +
+	"\x03uid\x18\x02 \x01(\tR\x03uid\x12\x16\n" +
+	"\x06groups\x18\x03 \x03(\tR\x06groups\x12;\n" +
+	"\x05extra\x18\x04 \x03(\v2%.federatedauth.v1.UserInfo.ExtraEntryR\x05extra\x1aV\n" +
+	"\n" +
+	"ExtraEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x122\n" +
+	"\x05value\x18\x02 \x01(\v2\x1c.federatedauth.v1.ExtraValueR\x05value:\x028\x01\"$\n" +
+	"\n" +
+	"ExtraValue\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\"O\n" +
+	"\x12BatchReviewRequest\x129\n" +
+	"\areviews\x18\x01 \x03(\v2\x1f.federatedauth.v1.ReviewRequestR\areviews\"Q\n" +
+	"\x13BatchReviewResponse\x12:\n" +
+	"\bstatuses\x18\x01 \x03(\v2\x1e.federatedauth.v1.ReviewStatusR\bstatuses\"\x15\n" +
+	"\x13ListClustersRequest\"Q\n" +
+	"\x14ListClustersResponse\x129\n" +
+	"\bclusters\x18\x01 \x03(\v2\x1d.federatedauth.v1.ClusterInfoR\bclusters\"\xd3\x01\n" +
+	"\vClusterInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06issuer\x18\x02 \x01(\tR\x06issuer\x12\x1d\n" +
+	"\n" +
+	"api_server\x18\x03 \x01(\tR\tapiServer\x127\n" +
+	"\x18insecure_skip_tls_verify\x18\x04 \x01(\bR\x15insecureSkipTlsVerify\x12@\n" +
+	"\ftoken_status\x18\x05 \x01(\v2\x1d.federatedauth.v1.TokenStatusR\vtokenStatus\"w\n" +
+	"\vTokenStatus\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x02 \x01(\tR\texpiresAt\x12\x1d\n" +
+	"\n" +
+	"expires_in\x18\x03 \x01(\tR\texpiresIn\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status2\x9c\x02\n" +
+	"\x12TokenReviewService\x12K\n" +
+	"\x06Review\x12\x1f.federatedauth.v1.ReviewRequest\x1a .federatedauth.v1.ReviewResponse\x12Z\n" +
+	"\vBatchReview\x12$.federatedauth.v1.BatchReviewRequest\x1a%.federatedauth.v1.BatchReviewResponse\x12]\n" +
+	"\fListClusters\x12%.federatedauth.v1.ListClustersRequest\x1a&.federatedauth.v1.ListClustersResponseBKZIgithub.com/rophy/kube-federated-auth/api/federatedauth/v1;federatedauthv1b\x06proto3"
+
+var (
+	file_federatedauth_v1_federatedauth_proto_rawDescOnce sync.Once
+	file_federatedauth_v1_federatedauth_proto_rawDescData []byte
+)
+
+func file_federatedauth_v1_federatedauth_proto_rawDescGZIP() []byte {
+	file_federatedauth_v1_federatedauth_proto_rawDescOnce.Do(func() {
+		file_federatedauth_v1_federatedauth_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_federatedauth_v1_federatedauth_proto_rawDesc), len(file_federatedauth_v1_federatedauth_proto_rawDesc)))
+	})
+	return file_federatedauth_v1_federatedauth_proto_rawDescData
+}
+
+var file_federatedauth_v1_federatedauth_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_federatedauth_v1_federatedauth_proto_goTypes = []any{
+	(*ReviewRequest)(nil),        // 0: federatedauth.v1.ReviewRequest
+	(*ReviewResponse)(nil),       // 1: federatedauth.v1.ReviewResponse
+	(*ReviewStatus)(nil),         // 2: federatedauth.v1.ReviewStatus
+	(*UserInfo)(nil),             // 3: federatedauth.v1.UserInfo
+	(*ExtraValue)(nil),           // 4: federatedauth.v1.ExtraValue
+	(*BatchReviewRequest)(nil),   // 5: federatedauth.v1.BatchReviewRequest
+	(*BatchReviewResponse)(nil),  // 6: federatedauth.v1.BatchReviewResponse
+	(*ListClustersRequest)(nil),  // 7: federatedauth.v1.ListClustersRequest
+	(*ListClustersResponse)(nil), // 8: federatedauth.v1.ListClustersResponse
+	(*ClusterInfo)(nil),          // 9: federatedauth.v1.ClusterInfo
+	(*TokenStatus)(nil),          // 10: federatedauth.v1.TokenStatus
+	nil,                          // 11: federatedauth.v1.UserInfo.ExtraEntry
+}
+var file_federatedauth_v1_federatedauth_proto_depIdxs = []int32{
+	2,  // 0: federatedauth.v1.ReviewResponse.status:type_name -> federatedauth.v1.ReviewStatus
+	3,  // 1: federatedauth.v1.ReviewStatus.user:type_name -> federatedauth.v1.UserInfo
+	11, // 2: federatedauth.v1.UserInfo.extra:type_name -> federatedauth.v1.UserInfo.ExtraEntry
+	0,  // 3: federatedauth.v1.BatchReviewRequest.reviews:type_name -> federatedauth.v1.ReviewRequest
+	2,  // 4: federatedauth.v1.BatchReviewResponse.statuses:type_name -> federatedauth.v1.ReviewStatus
+	9,  // 5: federatedauth.v1.ListClustersResponse.clusters:type_name -> federatedauth.v1.ClusterInfo
+	10, // 6: federatedauth.v1.ClusterInfo.token_status:type_name -> federatedauth.v1.TokenStatus
+	4,  // 7: federatedauth.v1.UserInfo.ExtraEntry.value:type_name -> federatedauth.v1.ExtraValue
+	0,  // 8: federatedauth.v1.TokenReviewService.Review:input_type -> federatedauth.v1.ReviewRequest
+	5,  // 9: federatedauth.v1.TokenReviewService.BatchReview:input_type -> federatedauth.v1.BatchReviewRequest
+	7,  // 10: federatedauth.v1.TokenReviewService.ListClusters:input_type -> federatedauth.v1.ListClustersRequest
+	1,  // 11: federatedauth.v1.TokenReviewService.Review:output_type -> federatedauth.v1.ReviewResponse
+	6,  // 12: federatedauth.v1.TokenReviewService.BatchReview:output_type -> federatedauth.v1.BatchReviewResponse
+	8,  // 13: federatedauth.v1.TokenReviewService.ListClusters:output_type -> federatedauth.v1.ListClustersResponse
+	11, // [11:14] is the sub-list for method output_type
+	8,  // [8:11] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_federatedauth_v1_federatedauth_proto_init() }
+func file_federatedauth_v1_federatedauth_proto_init() {
+	if File_federatedauth_v1_federatedauth_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_federatedauth_v1_federatedauth_proto_rawDesc), len(file_federatedauth_v1_federatedauth_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_federatedauth_v1_federatedauth_proto_goTypes,
+		DependencyIndexes: file_federatedauth_v1_federatedauth_proto_depIdxs,
+		MessageInfos:      file_federatedauth_v1_federatedauth_proto_msgTypes,
+	}.Build()
+	File_federatedauth_v1_federatedauth_proto = out.File
+	file_federatedauth_v1_federatedauth_proto_goTypes = nil
+	file_federatedauth_v1_federatedauth_proto_depIdxs = nil
+}
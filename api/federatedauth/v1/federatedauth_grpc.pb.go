@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: federatedauth/v1/federatedauth.proto
+
+package federatedauthv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TokenReviewService_Review_FullMethodName       = "/federatedauth.v1.TokenReviewService/Review"
+	TokenReviewService_BatchReview_FullMethodName  = "/federatedauth.v1.TokenReviewService/BatchReview"
+	TokenReviewService_ListClusters_FullMethodName = "/federatedauth.v1.TokenReviewService/ListClusters"
+)
+
+// TokenReviewServiceClient is the client API for TokenReviewService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TokenReviewService is the gRPC API of kube-federated-auth. It answers like the HTTP
+// API: callers authenticate with their own ServiceAccount token, sent as
+// "authorization: Bearer <token>" metadata, if authorized_clients is set.
+type TokenReviewServiceClient interface {
+	// Review validates a token against its source cluster, like POST
+	// /apis/authentication.k8s.io/v1/tokenreviews
+	Review(ctx context.Context, in *ReviewRequest, opts ...grpc.CallOption) (*ReviewResponse, error)
+	// BatchReview validates many tokens, like POST /tokenreviews/batch
+	BatchReview(ctx context.Context, in *BatchReviewRequest, opts ...grpc.CallOption) (*BatchReviewResponse, error)
+	// ListClusters lists the configured clusters, like GET /clusters
+	ListClusters(ctx context.Context, in *ListClustersRequest, opts ...grpc.CallOption) (*ListClustersResponse, error)
+}
+
+type tokenReviewServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenReviewServiceClient(cc grpc.ClientConnInterface) TokenReviewServiceClient {
+	return &tokenReviewServiceClient{cc}
+}
+
+func (c *tokenReviewServiceClient) Review(ctx context.Context, in *ReviewRequest, opts ...grpc.CallOption) (*ReviewResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReviewResponse)
+	err := c.cc.Invoke(ctx, TokenReviewService_Review_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenReviewServiceClient) BatchReview(ctx context.Context, in *BatchReviewRequest, opts ...grpc.CallOption) (*BatchReviewResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchReviewResponse)
+	err := c.cc.Invoke(ctx, TokenReviewService_BatchReview_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenReviewServiceClient) ListClusters(ctx context.Context, in *ListClustersRequest, opts ...grpc.CallOption) (*ListClustersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListClustersResponse)
+	err := c.cc.Invoke(ctx, TokenReviewService_ListClusters_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenReviewServiceServer is the server API for TokenReviewService service.
+// All implementations must embed UnimplementedTokenReviewServiceServer
+// for forward compatibility.
+//
+// TokenReviewService is the gRPC API of kube-federated-auth. It answers like the HTTP
+// API: callers authenticate with their own ServiceAccount token, sent as
+// "authorization: Bearer <token>" metadata, if authorized_clients is set.
+type TokenReviewServiceServer interface {
+	// Review validates a token against its source cluster, like POST
+	// /apis/authentication.k8s.io/v1/tokenreviews
+	Review(context.Context, *ReviewRequest) (*ReviewResponse, error)
+	// BatchReview validates many tokens, like POST /tokenreviews/batch
+	BatchReview(context.Context, *BatchReviewRequest) (*BatchReviewResponse, error)
+	// ListClusters lists the configured clusters, like GET /clusters
+	ListClusters(context.Context, *ListClustersRequest) (*ListClustersResponse, error)
+	mustEmbedUnimplementedTokenReviewServiceServer()
+}
+
+// UnimplementedTokenReviewServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTokenReviewServiceServer struct{}
+
+func (UnimplementedTokenReviewServiceServer) Review(context.Context, *ReviewRequest) (*ReviewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Review not implemented")
+}
+func (UnimplementedTokenReviewServiceServer) BatchReview(context.Context, *BatchReviewRequest) (*BatchReviewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchReview not implemented")
+}
+func (UnimplementedTokenReviewServiceServer) ListClusters(context.Context, *ListClustersRequest) (*ListClustersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListClusters not implemented")
+}
+func (UnimplementedTokenReviewServiceServer) mustEmbedUnimplementedTokenReviewServiceServer() {}
+func (UnimplementedTokenReviewServiceServer) testEmbeddedByValue()                            {}
+
+// UnsafeTokenReviewServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenReviewServiceServer will
+// result in compilation errors.
+type UnsafeTokenReviewServiceServer interface {
+	mustEmbedUnimplementedTokenReviewServiceServer()
+}
+
+func RegisterTokenReviewServiceServer(s grpc.ServiceRegistrar, srv TokenReviewServiceServer) {
+	// If the following call pancis, it indicates UnimplementedTokenReviewServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TokenReviewService_ServiceDesc, srv)
+}
+
+func _TokenReviewService_Review_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReviewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenReviewServiceServer).Review(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenReviewService_Review_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenReviewServiceServer).Review(ctx, req.(*ReviewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenReviewService_BatchReview_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchReviewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenReviewServiceServer).BatchReview(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenReviewService_BatchReview_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenReviewServiceServer).BatchReview(ctx, req.(*BatchReviewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenReviewService_ListClusters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListClustersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenReviewServiceServer).ListClusters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenReviewService_ListClusters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenReviewServiceServer).ListClusters(ctx, req.(*ListClustersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TokenReviewService_ServiceDesc is the grpc.ServiceDesc for TokenReviewService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenReviewService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "federatedauth.v1.TokenReviewService",
+	HandlerType: (*TokenReviewServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Review",
+			Handler:    _TokenReviewService_Review_Handler,
+		},
+		{
+			MethodName: "BatchReview",
+			Handler:    _TokenReviewService_BatchReview_Handler,
+		},
+		{
+			MethodName: "ListClusters",
+			Handler:    _TokenReviewService_ListClusters_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "federatedauth/v1/federatedauth.proto",
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rophy/kube-federated-auth/internal/cluster"
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/oidc"
+)
+
+// runInspectToken implements "kube-federated-auth inspect-token": it decodes a token
+// and explains which configured clusters accept or reject it
+func runInspectToken(args []string) {
+	fs := flag.NewFlagSet("inspect-token", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: kube-federated-auth inspect-token [flags] [TOKEN | -]\n\n")
+		fmt.Fprintf(fs.Output(), "Decodes a ServiceAccount token and verifies it against the JWKS of each configured\n")
+		fmt.Fprintf(fs.Output(), "cluster. The token is read from stdin if not given, and is never sent anywhere.\n\n")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", getEnv("CONFIG_PATH", "config/clusters.yaml"), "path to cluster config file")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for fetching discovery and JWKS of all clusters")
+	_ = fs.Parse(args)
+
+	var token string
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		token = fs.Arg(0)
+	} else {
+		data, err := io.ReadAll(io.LimitReader(os.Stdin, 1<<20))
+		if err != nil {
+			log.Fatalf("Failed to read token: %v", err)
+		}
+		token = string(data)
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(token), "Bearer "))
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	// Only credentials from the config file are used, not renewed ones from the
+	// credential Secret
+	verifier := oidc.NewVerifierManager(cfg, cluster.NewManager(cfg, nil))
+	info, checks, err := verifier.Inspect(ctx, token)
+	if err != nil {
+		log.Fatalf("Failed to decode token: %v", err)
+	}
+
+	printJSON("Header", info.Header)
+	printJSON("Claims", info.Claims)
+	fmt.Println()
+	fmt.Printf("Issuer:    %s\n", info.Issuer)
+	fmt.Printf("Subject:   %s\n", info.Subject)
+	fmt.Printf("Audience:  %s\n", strings.Join(info.Audience, ", "))
+	fmt.Printf("Expiry:    %s\n", describeExpiry(info.Expiry, time.Now()))
+	fmt.Println()
+
+	verified := false
+	fmt.Println("Clusters:")
+	for _, check := range checks {
+		fmt.Printf("  %s:\n", check.Cluster)
+		if check.IssuerMatch {
+			fmt.Printf("    issuer:   matches\n")
+		} else {
+			fmt.Printf("    issuer:   no match (cluster issuer %s)\n", check.Issuer)
+		}
+		switch {
+		case check.JWKSError != nil:
+			fmt.Printf("    kid:      unknown, JWKS unavailable: %v\n", check.JWKSError)
+		case check.KeyIDMatch:
+			fmt.Printf("    kid:      published\n")
+		default:
+			fmt.Printf("    kid:      not published (keys: %s)\n", strings.Join(check.KeyIDs, ", "))
+		}
+		if check.VerifyError != nil {
+			fmt.Printf("    verified: no: %v\n", check.VerifyError)
+		} else {
+			fmt.Printf("    verified: yes\n")
+			verified = true
+		}
+	}
+
+	if !verified {
+		fmt.Println()
+		fmt.Println("The token is not valid for any configured cluster")
+		os.Exit(1)
+	}
+}
+
+func printJSON(title string, v any) {
+	data, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode %s: %v", strings.ToLower(title), err)
+	}
+	fmt.Printf("%s:\n  %s\n", title, data)
+}
+
+// describeExpiry formats an expiry relative to now
+func describeExpiry(expiry, now time.Time) string {
+	if expiry.IsZero() {
+		return "none"
+	}
+	remaining := expiry.Sub(now).Round(time.Second)
+	if remaining < 0 {
+		return fmt.Sprintf("%s (expired %s ago)", expiry.UTC().Format(time.RFC3339), -remaining)
+	}
+	return fmt.Sprintf("%s (expires in %s)", expiry.UTC().Format(time.RFC3339), remaining)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/join"
+)
+
+// runJoin implements "kube-federated-auth join": it prepares a remote cluster and
+// prints the config stanza and bootstrap Secret manifest for the server
+func runJoin(args []string) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: kube-federated-auth join --name NAME [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Creates a ServiceAccount with TokenReview and TokenRequest permissions in the cluster of\n")
+		fmt.Fprintf(fs.Output(), "the kubeconfig context, requests a bootstrap token, and prints the clusters entry for the\n")
+		fmt.Fprintf(fs.Output(), "config file followed by the bootstrap Secret to create in the server's namespace.\n\n")
+		fs.PrintDefaults()
+	}
+	name := fs.String("name", "", "cluster name in the config (required)")
+	kubeconfig := fs.String("kubeconfig", "", "path to the kubeconfig (default: KUBECONFIG or ~/.kube/config)")
+	kubeContext := fs.String("context", "", "kubeconfig context of the remote cluster (default: current context)")
+	namespace := fs.String("namespace", join.DefaultNamespace, "namespace of the ServiceAccount in the remote cluster")
+	serviceAccount := fs.String("service-account", join.DefaultServiceAccount, "ServiceAccount the server authenticates as in the remote cluster")
+	tokenDuration := fs.Duration("token-duration", config.DefaultRenewalTokenDuration, "lifetime of the bootstrap token")
+	serverNamespace := fs.String("server-namespace", "kube-federated-auth", "namespace of the server, where the bootstrap Secret is created")
+	mountPath := fs.String("mount-path", "", "where the bootstrap Secret is mounted in the server (default: "+join.DefaultMountPath+"/NAME)")
+	secretFile := fs.String("secret-file", "", "write the Secret manifest to this file instead of stdout")
+	_ = fs.Parse(args)
+
+	if *name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *mountPath == "" {
+		*mountPath = join.DefaultMountPath + "/" + *name
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: *kubeContext}).ClientConfig()
+	if err != nil {
+		log.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	result, err := join.Join(ctx, restConfig, join.Options{
+		Namespace:      *namespace,
+		ServiceAccount: *serviceAccount,
+		TokenDuration:  *tokenDuration,
+	})
+	if err != nil {
+		log.Fatalf("Failed to join cluster %s: %v", *name, err)
+	}
+	for _, applied := range result.Applied {
+		fmt.Fprintln(os.Stderr, applied)
+	}
+
+	secretName := *name + "-bootstrap"
+	fmt.Printf("# Add to the config file, and mount Secret %s/%s at %s\n", *serverNamespace, secretName, *mountPath)
+	if err := join.WriteClusterConfig(os.Stdout, *name, result.ClusterConfig(*mountPath)); err != nil {
+		log.Fatalf("Failed to write cluster config: %v", err)
+	}
+
+	var secretOut io.Writer = os.Stdout
+	if *secretFile != "" {
+		f, err := os.OpenFile(*secretFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *secretFile, err)
+		}
+		defer f.Close()
+		secretOut = f
+	} else {
+		fmt.Println("---")
+	}
+	if err := join.WriteSecret(secretOut, result.Secret(secretName, *serverNamespace)); err != nil {
+		log.Fatalf("Failed to write Secret manifest: %v", err)
+	}
+}
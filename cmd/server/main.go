@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
 	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/controller"
 	"github.com/rophy/kube-federated-auth/internal/credentials"
 	"github.com/rophy/kube-federated-auth/internal/server"
 )
@@ -18,10 +25,33 @@ import (
 var Version = "dev"
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "join":
+			runJoin(os.Args[2:])
+			return
+		case "validate":
+			runValidate(os.Args[2:])
+			return
+		case "inspect-token":
+			runInspectToken(os.Args[2:])
+			return
+		}
+	}
+	runServer()
+}
+
+// runServer runs the token review server, configured by flags and environment
+func runServer() {
 	configPath := flag.String("config", getEnv("CONFIG_PATH", "config/clusters.yaml"), "path to cluster config file")
 	port := flag.String("port", getEnv("PORT", "8080"), "server port")
+	grpcPort := flag.String("grpc-port", getEnv("GRPC_PORT", "9090"), "gRPC server port")
+	metricsPort := flag.String("metrics-port", getEnv("METRICS_PORT", "8081"), "Prometheus metrics port, empty to disable")
+	extAuthzPort := flag.String("ext-authz-port", getEnv("EXT_AUTHZ_PORT", "9191"), "Envoy ext_authz gRPC port, empty to disable")
+	extAuthzHTTPPort := flag.String("ext-authz-http-port", getEnv("EXT_AUTHZ_HTTP_PORT", "8082"), "Envoy ext_authz HTTP port, empty to disable")
 	namespace := flag.String("namespace", getEnv("NAMESPACE", "kube-federated-auth"), "namespace for credential secret")
 	secretName := flag.String("secret-name", getEnv("SECRET_NAME", "kube-federated-auth"), "name of credential secret")
+	watchCRDs := flag.Bool("watch-crds", getEnv("WATCH_CRDS", "false") == "true", "reconcile FederatedCluster and FederatedAccessPolicy resources in the namespace")
 	flag.Parse()
 
 	cfg, err := config.Load(*configPath)
@@ -31,10 +61,20 @@ func main() {
 
 	log.Printf("Loaded %d cluster(s): %v", len(cfg.Clusters), cfg.ClusterNames())
 
-	// Only create credential store if there are remote clusters
+	for _, name := range cfg.ClusterNames() {
+		if cfg.Clusters[name].InsecureSkipTLSVerify {
+			log.Printf("WARNING: cluster %s has insecure_skip_tls_verify enabled; API server certificates are NOT verified. Do not use this in production.", name)
+		}
+	}
+
+	discovery := cfg.Discovery != nil && cfg.Discovery.KubeconfigSecrets != nil
+	dynamicConfig := *watchCRDs || discovery
+
+	// Only create credential store if there are remote clusters, or clusters may be
+	// added at runtime
 	var credStore *credentials.Store
 	remoteClusters := cfg.GetRemoteClusters()
-	if len(remoteClusters) > 0 {
+	if len(remoteClusters) > 0 || dynamicConfig {
 		var err error
 		credStore, err = credentials.NewStore(*namespace, *secretName)
 		if err != nil {
@@ -52,18 +92,38 @@ func main() {
 	}
 
 	log.Printf("kube-federated-auth version %s", Version)
-	srv := server.New(cfg, credStore, Version)
+	srv, err := server.New(cfg, credStore, Version, server.Options{DynamicConfig: dynamicConfig})
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Start credential renewal for remote clusters
-	if len(remoteClusters) > 0 {
+	if srv.Renewer != nil {
 		log.Printf("Starting credential renewal for remote clusters: %v", remoteClusters)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		srv.Renewer.Start(ctx)
+	}
 
-		renewer := credentials.NewRenewer(cfg, credStore, srv.Verifier)
-		renewer.Start(ctx)
+	if *watchCRDs {
+		ctrl, err := newController(cfg, credStore, srv, *namespace)
+		if err != nil {
+			log.Fatalf("Failed to create controller: %v", err)
+		}
+		go ctrl.Run(ctx)
+	}
 
-		// Handle shutdown gracefully
+	if discovery {
+		source, err := newKubeconfigSource(cfg, credStore, srv)
+		if err != nil {
+			log.Fatalf("Failed to create kubeconfig Secret discovery: %v", err)
+		}
+		go source.Run(ctx)
+	}
+
+	// Handle shutdown gracefully
+	if srv.Renewer != nil || dynamicConfig {
 		go func() {
 			sigCh := make(chan os.Signal, 1)
 			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -73,6 +133,53 @@ func main() {
 		}()
 	}
 
+	grpcAddr := ":" + *grpcPort
+	listener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", grpcAddr, err)
+	}
+	log.Printf("Starting gRPC server on %s", grpcAddr)
+	go func() {
+		if err := srv.GRPC.Serve(listener); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+
+	if srv.ExtAuthz != nil && *extAuthzPort != "" {
+		extAuthzAddr := ":" + *extAuthzPort
+		listener, err := net.Listen("tcp", extAuthzAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", extAuthzAddr, err)
+		}
+		log.Printf("Starting ext_authz gRPC server on %s", extAuthzAddr)
+		go func() {
+			if err := srv.ExtAuthz.Serve(listener); err != nil {
+				log.Fatalf("ext_authz gRPC server failed: %v", err)
+			}
+		}()
+	}
+	if srv.ExtAuthzHTTP != nil && *extAuthzHTTPPort != "" {
+		extAuthzHTTPAddr := ":" + *extAuthzHTTPPort
+		log.Printf("Starting ext_authz HTTP server on %s", extAuthzHTTPAddr)
+		go func() {
+			if err := http.ListenAndServe(extAuthzHTTPAddr, srv.ExtAuthzHTTP); err != nil {
+				log.Fatalf("ext_authz HTTP server failed: %v", err)
+			}
+		}()
+	}
+
+	if *metricsPort != "" {
+		metricsAddr := ":" + *metricsPort
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.Metrics)
+		log.Printf("Starting metrics server on %s", metricsAddr)
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
 	addr := ":" + *port
 	log.Printf("Starting server on %s", addr)
 	if err := http.ListenAndServe(addr, srv.Handler); err != nil {
@@ -80,6 +187,47 @@ func main() {
 	}
 }
 
+// newController creates the FederatedCluster/FederatedAccessPolicy controller using
+// the in-cluster service account
+func newController(cfg *config.Config, credStore *credentials.Store, srv *server.Server, namespace string) (*controller.Controller, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("watching CRDs requires running in cluster: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl := controller.New(cfg, credStore, dyn, kube, namespace, srv.Verifier)
+	ctrl.OnClusterChange(srv.ClusterChanged)
+	return ctrl, nil
+}
+
+// newKubeconfigSource creates the kubeconfig Secret discovery source using the
+// in-cluster service account
+func newKubeconfigSource(cfg *config.Config, credStore *credentials.Store, srv *server.Server) (*controller.KubeconfigSource, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("discovery requires running in cluster: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := controller.NewKubeconfigSource(cfg, credStore, kube, cfg.Discovery.KubeconfigSecrets)
+	if err != nil {
+		return nil, err
+	}
+	source.OnClusterChange(srv.ClusterChanged)
+	return source, nil
+}
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rophy/kube-federated-auth/internal/cluster"
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/oidc"
+)
+
+// runValidate implements "kube-federated-auth validate": it loads the config file,
+// reports every invalid setting and optionally checks each cluster's OIDC endpoints
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: kube-federated-auth validate [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Checks the config file and reports every invalid setting with its line.\n\n")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", getEnv("CONFIG_PATH", "config/clusters.yaml"), "path to cluster config file")
+	checkConnectivity := fs.Bool("check-connectivity", false, "fetch OIDC discovery and JWKS of each cluster")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout per cluster for --check-connectivity")
+	_ = fs.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		// config.ValidationErrors lists every invalid setting with its line
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: OK (%d clusters)\n", *configPath, len(cfg.Clusters))
+
+	if !*checkConnectivity {
+		return
+	}
+
+	// Only credentials from the config file are used, not renewed ones from the
+	// credential Secret
+	verifier := oidc.NewVerifierManager(cfg, cluster.NewManager(cfg, nil))
+	failed := false
+	for _, name := range cfg.ClusterNames() {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		keys, err := verifier.CheckJWKS(ctx, name)
+		cancel()
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: OK (%d keys)\n", name, keys)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
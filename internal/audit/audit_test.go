@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLog_KeepsMostRecent(t *testing.T) {
+	l := NewLog(3)
+	for _, target := range []string{"a", "b", "c", "d"} {
+		l.Record(Event{Action: ActionTokenMint, Target: target, Allowed: true})
+	}
+
+	got := l.List(0)
+	if len(got) != 3 || got[0].Target != "d" || got[1].Target != "c" || got[2].Target != "b" {
+		t.Errorf("events = %+v, want [d c b]", got)
+	}
+	if got[0].Time.IsZero() {
+		t.Error("expected Record to set the event time")
+	}
+
+	if got := l.List(1); len(got) != 1 || got[0].Target != "d" {
+		t.Errorf("List(1) = %+v, want [d]", got)
+	}
+}
+
+func TestLog_PreservesTime(t *testing.T) {
+	l := NewLog(1)
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.Record(Event{Time: at, Action: ActionTokenMint})
+
+	if got := l.List(0)[0].Time; !got.Equal(at) {
+		t.Errorf("time = %v, want %v", got, at)
+	}
+}
+
+func TestLog_NilIsNoop(t *testing.T) {
+	var l *Log
+	l.Record(Event{Action: ActionTokenMint})
+}
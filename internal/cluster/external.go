@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+// ExternalRESTConfig builds a REST config for a cluster whose API server credentials
+// come from a kubeconfig file or an exec credential plugin (see ClusterConfig.UsesExternalAuth).
+// Such credentials are refreshed by client-go and are never renewed via TokenRequest.
+func ExternalRESTConfig(cfg config.ClusterConfig) (*rest.Config, error) {
+	switch {
+	case cfg.Kubeconfig != "":
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: cfg.Kubeconfig}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.Context}
+		if cfg.APIServer != "" {
+			overrides.ClusterInfo.Server = cfg.APIServer
+		}
+
+		restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("loading kubeconfig %s: %w", cfg.Kubeconfig, err)
+		}
+		if err := ApplyConnectionSettings(restConfig, cfg); err != nil {
+			return nil, err
+		}
+		return restConfig, nil
+
+	case cfg.Exec != nil:
+		apiVersion := cfg.Exec.APIVersion
+		if apiVersion == "" {
+			apiVersion = config.DefaultExecAPIVersion
+		}
+
+		// Sort env names so the exec config (and client-go's authenticator cache key) is stable
+		names := make([]string, 0, len(cfg.Exec.Env))
+		for name := range cfg.Exec.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		env := make([]clientcmdapi.ExecEnvVar, 0, len(names))
+		for _, name := range names {
+			env = append(env, clientcmdapi.ExecEnvVar{Name: name, Value: cfg.Exec.Env[name]})
+		}
+
+		restConfig := &rest.Config{
+			Host: cfg.APIServer,
+			ExecProvider: &clientcmdapi.ExecConfig{
+				Command:         cfg.Exec.Command,
+				Args:            cfg.Exec.Args,
+				Env:             env,
+				APIVersion:      apiVersion,
+				InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+			},
+			TLSClientConfig: rest.TLSClientConfig{
+				CAFile: cfg.CACert,
+			},
+		}
+		if err := ApplyConnectionSettings(restConfig, cfg); err != nil {
+			return nil, err
+		}
+		return restConfig, nil
+	}
+
+	return nil, fmt.Errorf("cluster has no kubeconfig or exec configured")
+}
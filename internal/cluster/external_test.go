@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: default
+clusters:
+- name: default
+  cluster:
+    server: https://default.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: default
+  context:
+    cluster: default
+    user: default
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+users:
+- name: default
+  user:
+    token: default-token
+- name: prod
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: gke-gcloud-auth-plugin
+`
+
+func writeKubeconfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExternalRESTConfig_KubeconfigContext(t *testing.T) {
+	cfg := config.ClusterConfig{
+		Issuer:     "https://container.googleapis.com/v1/projects/p/locations/l/clusters/c",
+		Kubeconfig: writeKubeconfig(t),
+		Context:    "prod",
+	}
+
+	restConfig, err := ExternalRESTConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restConfig.Host != "https://prod.example.com" {
+		t.Errorf("host = %q, want %q", restConfig.Host, "https://prod.example.com")
+	}
+	if restConfig.ExecProvider == nil || restConfig.ExecProvider.Command != "gke-gcloud-auth-plugin" {
+		t.Errorf("exec provider = %+v, want gke-gcloud-auth-plugin", restConfig.ExecProvider)
+	}
+}
+
+func TestExternalRESTConfig_KubeconfigAPIServerOverride(t *testing.T) {
+	cfg := config.ClusterConfig{
+		Issuer:     "https://issuer.example.com",
+		APIServer:  "https://override.example.com",
+		Kubeconfig: writeKubeconfig(t),
+	}
+
+	restConfig, err := ExternalRESTConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restConfig.Host != "https://override.example.com" {
+		t.Errorf("host = %q, want %q", restConfig.Host, "https://override.example.com")
+	}
+	if restConfig.BearerToken != "default-token" {
+		t.Errorf("bearer token = %q, want current-context token", restConfig.BearerToken)
+	}
+}
+
+func TestExternalRESTConfig_UnknownContext(t *testing.T) {
+	cfg := config.ClusterConfig{
+		Kubeconfig: writeKubeconfig(t),
+		Context:    "missing",
+	}
+
+	if _, err := ExternalRESTConfig(cfg); err == nil {
+		t.Error("expected error for unknown context")
+	}
+}
+
+func TestExternalRESTConfig_Exec(t *testing.T) {
+	cfg := config.ClusterConfig{
+		Issuer:    "https://oidc.eks.us-west-2.amazonaws.com/id/EXAMPLE",
+		APIServer: "https://EXAMPLE.gr7.us-west-2.eks.amazonaws.com",
+		CACert:    "/path/to/ca.crt",
+		Exec: &config.ExecConfig{
+			Command: "aws",
+			Args:    []string{"eks", "get-token", "--cluster-name", "prod"},
+			Env:     map[string]string{"AWS_REGION": "us-west-2", "AWS_PROFILE": "prod"},
+		},
+	}
+
+	restConfig, err := ExternalRESTConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restConfig.Host != cfg.APIServer {
+		t.Errorf("host = %q, want %q", restConfig.Host, cfg.APIServer)
+	}
+	if restConfig.TLSClientConfig.CAFile != "/path/to/ca.crt" {
+		t.Errorf("CA file = %q, want %q", restConfig.TLSClientConfig.CAFile, "/path/to/ca.crt")
+	}
+
+	exec := restConfig.ExecProvider
+	if exec == nil {
+		t.Fatal("expected exec provider")
+	}
+	if exec.APIVersion != config.DefaultExecAPIVersion {
+		t.Errorf("api version = %q, want %q", exec.APIVersion, config.DefaultExecAPIVersion)
+	}
+	if len(exec.Env) != 2 || exec.Env[0].Name != "AWS_PROFILE" || exec.Env[1].Name != "AWS_REGION" {
+		t.Errorf("env = %+v, want AWS_PROFILE, AWS_REGION in order", exec.Env)
+	}
+}
@@ -0,0 +1,238 @@
+// Package cluster builds connections to configured clusters. It is the single place
+// that decides which credentials, CA and connection settings are used to reach a
+// cluster, both for OIDC discovery/JWKS and for Kubernetes API calls.
+//
+// Credential precedence is the same for every caller:
+//
+//   - kubeconfig/exec clusters use client-go's credentials; OIDC discovery for such a
+//     cluster without api_server goes to the public issuer unauthenticated
+//   - otherwise the token from the credential store wins over token_path, which is
+//     re-read by client-go as it changes
+//   - the CA from the credential store wins over the ca_cert file
+//   - client_cert/client_key are always presented when set
+//   - local clusters (no api_server) call the API with the in-cluster config, while
+//     OIDC discovery goes to the issuer using ca_cert and token_path
+//
+// proxy_url and the TLS settings apply in every case.
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+)
+
+// Manager provides REST configs, HTTP clients and Kubernetes clients for configured
+// clusters. Connections are cached per cluster so TokenReview forwarding, credential
+// renewal and JWKS fetches share connection pools; a cluster's connections are dropped
+// when its stored credentials change.
+type Manager struct {
+	config    *config.Config
+	credStore *credentials.Store
+
+	mu          sync.Mutex
+	connections map[connectionKey]*connection
+}
+
+type connectionKey struct {
+	cluster   string
+	discovery bool
+}
+
+type connection struct {
+	httpClient *http.Client
+	clientset  kubernetes.Interface // nil for discovery-only connections
+}
+
+func NewManager(cfg *config.Config, store *credentials.Store) *Manager {
+	m := &Manager{
+		config:      cfg,
+		credStore:   store,
+		connections: make(map[connectionKey]*connection),
+	}
+	if store != nil {
+		store.OnChange(m.Invalidate)
+	}
+	return m
+}
+
+// Invalidate drops cached connections for a cluster so the next call rebuilds them
+// with current credentials
+func (m *Manager) Invalidate(clusterName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.connections, connectionKey{cluster: clusterName})
+	delete(m.connections, connectionKey{cluster: clusterName, discovery: true})
+}
+
+// RESTConfig returns the REST config for calling a cluster's API server
+func (m *Manager) RESTConfig(clusterName string) (*rest.Config, error) {
+	cfg, err := m.cluster(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return apiRESTConfig(cfg, m.storedCredentials(clusterName))
+}
+
+// Clientset returns a Kubernetes client for a cluster's API server
+func (m *Manager) Clientset(clusterName string) (kubernetes.Interface, error) {
+	conn, err := m.connection(clusterName, false)
+	if err != nil {
+		return nil, err
+	}
+	return conn.clientset, nil
+}
+
+// DiscoveryClient returns the HTTP client for a cluster's OIDC discovery and JWKS requests
+func (m *Manager) DiscoveryClient(clusterName string) (*http.Client, error) {
+	conn, err := m.connection(clusterName, true)
+	if err != nil {
+		return nil, err
+	}
+	return conn.httpClient, nil
+}
+
+// ClientFor creates an uncached Kubernetes client for a remote cluster using explicit
+// credentials, e.g. bootstrap credentials during renewal
+func (m *Manager) ClientFor(cfg config.ClusterConfig, creds *credentials.Credentials) (kubernetes.Interface, error) {
+	restConfig, err := apiRESTConfig(cfg, creds)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+func (m *Manager) cluster(clusterName string) (config.ClusterConfig, error) {
+	cfg, ok := m.config.Cluster(clusterName)
+	if !ok {
+		return config.ClusterConfig{}, fmt.Errorf("cluster not found: %s", clusterName)
+	}
+	return cfg, nil
+}
+
+func (m *Manager) storedCredentials(clusterName string) *credentials.Credentials {
+	if m.credStore == nil {
+		return nil
+	}
+	creds, _ := m.credStore.Get(clusterName)
+	return creds
+}
+
+func (m *Manager) connection(clusterName string, discovery bool) (*connection, error) {
+	cfg, err := m.cluster(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clusters with api_server serve discovery from the API server with the same
+	// credentials, so both share one connection
+	if cfg.APIServer != "" {
+		discovery = false
+	}
+	key := connectionKey{cluster: clusterName, discovery: discovery}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if conn, ok := m.connections[key]; ok {
+		return conn, nil
+	}
+
+	creds := m.storedCredentials(clusterName)
+	var restConfig *rest.Config
+	if discovery {
+		restConfig, err = discoveryRESTConfig(cfg, creds)
+	} else {
+		restConfig, err = apiRESTConfig(cfg, creds)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("building REST config for cluster %s: %w", clusterName, err)
+	}
+
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP client for cluster %s: %w", clusterName, err)
+	}
+
+	conn := &connection{httpClient: httpClient}
+	if !discovery {
+		conn.clientset, err = kubernetes.NewForConfigAndClient(restConfig, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("creating kubernetes client for cluster %s: %w", clusterName, err)
+		}
+	}
+
+	m.connections[key] = conn
+	return conn, nil
+}
+
+// apiRESTConfig builds the REST config for a cluster's API server
+func apiRESTConfig(cfg config.ClusterConfig, creds *credentials.Credentials) (*rest.Config, error) {
+	switch {
+	case cfg.UsesExternalAuth():
+		return ExternalRESTConfig(cfg)
+	case cfg.APIServer != "":
+		return staticRESTConfig(cfg.APIServer, cfg, creds)
+	}
+
+	// For local clusters, try in-cluster config first
+	if inClusterConfig, err := rest.InClusterConfig(); err == nil {
+		return inClusterConfig, nil
+	}
+
+	// Fallback: use issuer as host (for testing)
+	return &rest.Config{Host: cfg.Issuer}, nil
+}
+
+// discoveryRESTConfig builds the REST config for a cluster's OIDC discovery and JWKS requests
+func discoveryRESTConfig(cfg config.ClusterConfig, creds *credentials.Credentials) (*rest.Config, error) {
+	if cfg.UsesExternalAuth() {
+		if cfg.APIServer != "" {
+			return ExternalRESTConfig(cfg)
+		}
+		// Kubeconfig/exec credentials are only sent to an explicit api_server; without one,
+		// discovery goes to the issuer, which is expected to be public (e.g. EKS, GKE)
+		restConfig := &rest.Config{Host: cfg.Issuer}
+		if err := ApplyConnectionSettings(restConfig, cfg); err != nil {
+			return nil, err
+		}
+		return restConfig, nil
+	}
+
+	return staticRESTConfig(cfg.DiscoveryURL(), cfg, creds)
+}
+
+// staticRESTConfig builds a REST config from stored credentials and the configured files.
+// client-go reloads token_path and client cert files when they change.
+func staticRESTConfig(host string, cfg config.ClusterConfig, creds *credentials.Credentials) (*rest.Config, error) {
+	restConfig := &rest.Config{
+		Host: host,
+		TLSClientConfig: rest.TLSClientConfig{
+			CertFile: cfg.ClientCert,
+			KeyFile:  cfg.ClientKey,
+		},
+	}
+
+	if creds != nil && creds.Token != "" {
+		restConfig.BearerToken = creds.Token
+	} else {
+		restConfig.BearerTokenFile = cfg.TokenPath
+	}
+
+	if creds != nil && len(creds.CACert) > 0 {
+		restConfig.TLSClientConfig.CAData = creds.CACert
+	} else {
+		restConfig.TLSClientConfig.CAFile = cfg.CACert
+	}
+
+	if err := ApplyConnectionSettings(restConfig, cfg); err != nil {
+		return nil, err
+	}
+	return restConfig, nil
+}
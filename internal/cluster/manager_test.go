@@ -0,0 +1,298 @@
+package cluster
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+)
+
+func newTestStore(t *testing.T) *credentials.Store {
+	t.Helper()
+	store, err := credentials.NewStore("kube-federated-auth", "kube-federated-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRESTConfigPrecedence(t *testing.T) {
+	stored := &credentials.Credentials{Token: "stored-token", CACert: []byte("stored-ca")}
+	remote := config.ClusterConfig{
+		Issuer:    "https://kubernetes.default.svc.cluster.local",
+		APIServer: "https://10.0.0.1:6443",
+		CACert:    "/certs/ca.crt",
+		TokenPath: "/certs/token",
+	}
+	withClientCert := remote
+	withClientCert.ClientCert = "/certs/client.crt"
+	withClientCert.ClientKey = "/certs/client.key"
+	withProxy := remote
+	withProxy.ProxyURL = "http://proxy.internal:3128"
+	exec := config.ClusterConfig{
+		Issuer:    "https://oidc.eks.us-west-2.amazonaws.com/id/EXAMPLE",
+		APIServer: "https://EXAMPLE.gr7.us-west-2.eks.amazonaws.com",
+		Exec:      &config.ExecConfig{Command: "aws", Args: []string{"eks", "get-token"}},
+	}
+	execNoAPIServer := exec
+	execNoAPIServer.APIServer = ""
+	execNoAPIServer.Kubeconfig = writeKubeconfig(t)
+	execNoAPIServer.Exec = nil
+	local := config.ClusterConfig{
+		Issuer:    "https://kubernetes.internal.corp",
+		CACert:    "/certs/local-ca.crt",
+		TokenPath: "/certs/local-token",
+	}
+
+	tests := []struct {
+		name      string
+		cfg       config.ClusterConfig
+		creds     *credentials.Credentials
+		discovery bool
+
+		wantHost      string
+		wantToken     string
+		wantTokenFile string
+		wantCAData    string
+		wantCAFile    string
+		wantCertFile  string
+		wantExec      bool
+		wantProxy     bool
+	}{
+		{
+			name:       "stored credentials win over files",
+			cfg:        remote,
+			creds:      stored,
+			wantHost:   remote.APIServer,
+			wantToken:  "stored-token",
+			wantCAData: "stored-ca",
+		},
+		{
+			name:          "files without stored credentials",
+			cfg:           remote,
+			wantHost:      remote.APIServer,
+			wantTokenFile: "/certs/token",
+			wantCAFile:    "/certs/ca.crt",
+		},
+		{
+			name:       "discovery uses the same credentials as the API",
+			cfg:        remote,
+			creds:      stored,
+			discovery:  true,
+			wantHost:   remote.APIServer,
+			wantToken:  "stored-token",
+			wantCAData: "stored-ca",
+		},
+		{
+			name:         "client certificate",
+			cfg:          withClientCert,
+			creds:        stored,
+			wantHost:     remote.APIServer,
+			wantToken:    "stored-token",
+			wantCAData:   "stored-ca",
+			wantCertFile: "/certs/client.crt",
+		},
+		{
+			name:       "proxy",
+			cfg:        withProxy,
+			creds:      stored,
+			wantHost:   remote.APIServer,
+			wantToken:  "stored-token",
+			wantCAData: "stored-ca",
+			wantProxy:  true,
+		},
+		{
+			name:     "exec plugin ignores stored credentials",
+			cfg:      exec,
+			creds:    stored,
+			wantHost: exec.APIServer,
+			wantExec: true,
+		},
+		{
+			name:      "exec plugin discovery",
+			cfg:       exec,
+			discovery: true,
+			wantHost:  exec.APIServer,
+			wantExec:  true,
+		},
+		{
+			name:      "kubeconfig discovery without api_server is unauthenticated",
+			cfg:       execNoAPIServer,
+			discovery: true,
+			wantHost:  execNoAPIServer.Issuer,
+		},
+		{
+			name:          "local cluster discovery uses files",
+			cfg:           local,
+			discovery:     true,
+			wantHost:      local.Issuer,
+			wantTokenFile: "/certs/local-token",
+			wantCAFile:    "/certs/local-ca.crt",
+		},
+		{
+			name:     "local cluster API outside a cluster falls back to issuer",
+			cfg:      local,
+			wantHost: local.Issuer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build := apiRESTConfig
+			if tt.discovery {
+				build = discoveryRESTConfig
+			}
+			restConfig, err := build(tt.cfg, tt.creds)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if restConfig.Host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", restConfig.Host, tt.wantHost)
+			}
+			if restConfig.BearerToken != tt.wantToken {
+				t.Errorf("BearerToken = %q, want %q", restConfig.BearerToken, tt.wantToken)
+			}
+			if restConfig.BearerTokenFile != tt.wantTokenFile {
+				t.Errorf("BearerTokenFile = %q, want %q", restConfig.BearerTokenFile, tt.wantTokenFile)
+			}
+			if string(restConfig.TLSClientConfig.CAData) != tt.wantCAData {
+				t.Errorf("CAData = %q, want %q", restConfig.TLSClientConfig.CAData, tt.wantCAData)
+			}
+			if restConfig.TLSClientConfig.CAFile != tt.wantCAFile {
+				t.Errorf("CAFile = %q, want %q", restConfig.TLSClientConfig.CAFile, tt.wantCAFile)
+			}
+			if restConfig.TLSClientConfig.CertFile != tt.wantCertFile {
+				t.Errorf("CertFile = %q, want %q", restConfig.TLSClientConfig.CertFile, tt.wantCertFile)
+			}
+			if (restConfig.ExecProvider != nil) != tt.wantExec {
+				t.Errorf("ExecProvider = %+v, want exec: %v", restConfig.ExecProvider, tt.wantExec)
+			}
+			if (restConfig.Proxy != nil) != tt.wantProxy {
+				t.Errorf("Proxy set = %v, want %v", restConfig.Proxy != nil, tt.wantProxy)
+			}
+		})
+	}
+}
+
+func TestManager_UnknownCluster(t *testing.T) {
+	m := NewManager(&config.Config{}, nil)
+
+	if _, err := m.Clientset("missing"); err == nil {
+		t.Error("expected error for unknown cluster")
+	}
+	if _, err := m.DiscoveryClient("missing"); err == nil {
+		t.Error("expected error for unknown cluster")
+	}
+}
+
+func TestManager_DiscoveryClientUsesStoredCredentials(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "token")
+	caPath := filepath.Join(dir, "ca.crt")
+	os.WriteFile(tokenPath, []byte("bootstrap-token"), 0600)
+	os.WriteFile(caPath, caPEM, 0600)
+
+	cfg := &config.Config{
+		Clusters: map[string]config.ClusterConfig{
+			"remote": {
+				Issuer:    "https://kubernetes.default.svc.cluster.local",
+				APIServer: srv.URL,
+				CACert:    caPath,
+				TokenPath: tokenPath,
+			},
+		},
+	}
+	store := newTestStore(t)
+	m := NewManager(cfg, store)
+
+	get := func(client *http.Client) {
+		t.Helper()
+		resp, err := client.Get(srv.URL + "/.well-known/openid-configuration")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// Without stored credentials the bootstrap files are used
+	client, err := m.DiscoveryClient("remote")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	get(client)
+	if gotAuth != "Bearer bootstrap-token" {
+		t.Errorf("Authorization = %q, want bootstrap token", gotAuth)
+	}
+
+	again, _ := m.DiscoveryClient("remote")
+	if again != client {
+		t.Error("expected cached client for unchanged credentials")
+	}
+
+	// Renewed credentials replace the cached connection
+	store.Set(context.Background(), "remote", &credentials.Credentials{Token: "renewed-token", CACert: caPEM})
+
+	client, err = m.DiscoveryClient("remote")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client == again {
+		t.Error("expected new client after credentials changed")
+	}
+	get(client)
+	if gotAuth != "Bearer renewed-token" {
+		t.Errorf("Authorization = %q, want renewed token", gotAuth)
+	}
+}
+
+func TestManager_ClientsetForwardsToAPIServer(t *testing.T) {
+	var gotPath, gotAuth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"default"}}`))
+	}))
+	defer srv.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	cfg := &config.Config{
+		Clusters: map[string]config.ClusterConfig{
+			"remote": {Issuer: "https://kubernetes.default.svc.cluster.local", APIServer: srv.URL},
+		},
+	}
+	store := newTestStore(t)
+	store.Set(context.Background(), "remote", &credentials.Credentials{Token: "stored-token", CACert: caPEM})
+	m := NewManager(cfg, store)
+
+	client, err := m.Clientset("remote")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "default", metav1.GetOptions{}); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if gotPath != "/api/v1/namespaces/default" {
+		t.Errorf("path = %q, want /api/v1/namespaces/default", gotPath)
+	}
+	if gotAuth != "Bearer stored-token" {
+		t.Errorf("Authorization = %q, want stored token", gotAuth)
+	}
+}
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+// ApplyConnectionSettings applies a cluster's proxy_url, tls_server_name,
+// tls_min_version and insecure_skip_tls_verify settings to a REST config.
+func ApplyConnectionSettings(restConfig *rest.Config, cfg config.ClusterConfig) error {
+	proxyURL, err := cfg.ParseProxyURL()
+	if err != nil {
+		return err
+	}
+	minVersion, err := cfg.ParseTLSMinVersion()
+	if err != nil {
+		return err
+	}
+
+	if proxyURL != nil {
+		restConfig.Proxy = http.ProxyURL(proxyURL)
+	}
+	if cfg.TLSServerName != "" {
+		restConfig.TLSClientConfig.ServerName = cfg.TLSServerName
+	}
+	if cfg.InsecureSkipTLSVerify {
+		// client-go rejects root CAs combined with the insecure flag
+		restConfig.TLSClientConfig.Insecure = true
+		restConfig.TLSClientConfig.CAData = nil
+		restConfig.TLSClientConfig.CAFile = ""
+	}
+	if minVersion != 0 {
+		restConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return withTLSMinVersion(rt, minVersion)
+		})
+	}
+
+	return nil
+}
+
+// withTLSMinVersion returns a copy of the client-go base transport that requires
+// at least the given TLS version. The shared base transport is never modified.
+func withTLSMinVersion(rt http.RoundTripper, minVersion uint16) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return errorRoundTripper{fmt.Errorf("cannot enforce tls_min_version on transport %T", rt)}
+	}
+
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.MinVersion = minVersion
+	return t
+}
+
+type errorRoundTripper struct {
+	err error
+}
+
+func (e errorRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, e.err
+}
@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+func TestApplyConnectionSettings(t *testing.T) {
+	cfg := config.ClusterConfig{
+		APIServer:     "https://10.0.0.1:6443",
+		ProxyURL:      "http://proxy.internal:3128",
+		TLSServerName: "kubernetes.default.svc",
+		TLSMinVersion: "1.3",
+	}
+	restConfig := &rest.Config{
+		Host:            cfg.APIServer,
+		TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")},
+	}
+
+	if err := ApplyConnectionSettings(restConfig, cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, cfg.APIServer, nil)
+	proxyURL, err := restConfig.Proxy(req)
+	if err != nil || proxyURL.String() != "http://proxy.internal:3128" {
+		t.Errorf("proxy = %v (%v), want http://proxy.internal:3128", proxyURL, err)
+	}
+	if restConfig.TLSClientConfig.ServerName != "kubernetes.default.svc" {
+		t.Errorf("ServerName = %q, want kubernetes.default.svc", restConfig.TLSClientConfig.ServerName)
+	}
+	if string(restConfig.TLSClientConfig.CAData) != "ca" {
+		t.Error("CA data should be kept when TLS verification is enabled")
+	}
+
+	base := &http.Transport{TLSClientConfig: &tls.Config{}}
+	rt := restConfig.WrapTransport(base)
+	wrapped, ok := rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("wrapped transport = %T, want *http.Transport", rt)
+	}
+	if wrapped.TLSClientConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x, want %x", wrapped.TLSClientConfig.MinVersion, tls.VersionTLS13)
+	}
+	if base.TLSClientConfig.MinVersion != 0 {
+		t.Error("base transport should not be modified")
+	}
+}
+
+func TestApplyConnectionSettings_Insecure(t *testing.T) {
+	cfg := config.ClusterConfig{
+		APIServer:             "https://10.0.0.1:6443",
+		InsecureSkipTLSVerify: true,
+	}
+	restConfig := &rest.Config{
+		Host:            cfg.APIServer,
+		TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca"), CAFile: "/path/to/ca.crt"},
+	}
+
+	if err := ApplyConnectionSettings(restConfig, cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !restConfig.TLSClientConfig.Insecure {
+		t.Error("expected Insecure to be set")
+	}
+	if restConfig.TLSClientConfig.CAData != nil || restConfig.TLSClientConfig.CAFile != "" {
+		t.Error("expected CA to be cleared when TLS verification is disabled")
+	}
+	if restConfig.WrapTransport != nil {
+		t.Error("expected no transport wrapper without tls_min_version")
+	}
+
+	// client-go must accept the resulting config
+	if _, err := rest.HTTPClientFor(restConfig); err != nil {
+		t.Errorf("HTTPClientFor: %v", err)
+	}
+}
@@ -1,9 +1,18 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,6 +22,20 @@ const (
 	DefaultRenewalInterval      = 1 * time.Hour
 	DefaultRenewalTokenDuration = 168 * time.Hour // 7 days
 	DefaultRenewalRenewBefore   = 48 * time.Hour  // 2 days
+	DefaultRenewalRetryBackoff  = 10 * time.Second
+	DefaultRenewalMaxBackoff    = 15 * time.Minute
+	DefaultRenewalStartupJitter = 30 * time.Second
+
+	DefaultIssuerTokenDuration = 1 * time.Hour
+
+	DefaultExchangeMaxExpiration = 1 * time.Hour
+	MinExchangeExpiration        = 10 * time.Minute // TokenRequest minimum
+
+	DefaultMaxBodyBytes     = 64 << 10
+	DefaultMaxTokenBytes    = 16 << 10
+	MaxTokenBytes           = 64 << 10 // upper bound of limits.max_token_bytes
+	DefaultMaxBatchSize     = 100
+	DefaultBatchParallelism = 10
 )
 
 // RenewalSettings contains global settings for token renewal
@@ -20,6 +43,9 @@ type RenewalSettings struct {
 	Interval      time.Duration `yaml:"interval"`
 	TokenDuration time.Duration `yaml:"token_duration"`
 	RenewBefore   time.Duration `yaml:"renew_before"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff"`
+	MaxBackoff    time.Duration `yaml:"max_backoff"`
+	StartupJitter time.Duration `yaml:"startup_jitter"`
 }
 
 // UnmarshalYAML handles duration parsing from string
@@ -28,6 +54,9 @@ func (r *RenewalSettings) UnmarshalYAML(unmarshal func(interface{}) error) error
 		Interval      string `yaml:"interval"`
 		TokenDuration string `yaml:"token_duration"`
 		RenewBefore   string `yaml:"renew_before"`
+		RetryBackoff  string `yaml:"retry_backoff"`
+		MaxBackoff    string `yaml:"max_backoff"`
+		StartupJitter string `yaml:"startup_jitter"`
 	}
 	var raw rawRenewalSettings
 	if err := unmarshal(&raw); err != nil {
@@ -58,14 +87,447 @@ func (r *RenewalSettings) UnmarshalYAML(unmarshal func(interface{}) error) error
 		r.RenewBefore = d
 	}
 
+	if raw.RetryBackoff != "" {
+		d, err := time.ParseDuration(raw.RetryBackoff)
+		if err != nil {
+			return fmt.Errorf("parsing retry_backoff: %w", err)
+		}
+		r.RetryBackoff = d
+	}
+
+	if raw.MaxBackoff != "" {
+		d, err := time.ParseDuration(raw.MaxBackoff)
+		if err != nil {
+			return fmt.Errorf("parsing max_backoff: %w", err)
+		}
+		r.MaxBackoff = d
+	}
+
+	if raw.StartupJitter != "" {
+		d, err := time.ParseDuration(raw.StartupJitter)
+		if err != nil {
+			return fmt.Errorf("parsing startup_jitter: %w", err)
+		}
+		r.StartupJitter = d
+	}
+
+	return nil
+}
+
+// IssuerSettings configures kube-federated-auth as an OIDC issuer: verified ServiceAccount
+// tokens from any configured cluster are exchanged for tokens signed by signing_key.
+type IssuerSettings struct {
+	URL           string        `yaml:"url"`
+	SigningKey    string        `yaml:"signing_key"`
+	Audiences     []string      `yaml:"audiences"`
+	TokenDuration time.Duration `yaml:"token_duration"`
+	// SubjectAudiences are the audiences subject tokens are reviewed for. Tokens must be
+	// bound to one of them, so a workload's default token can't be replayed by a service
+	// it was sent to.
+	SubjectAudiences []string `yaml:"subject_audiences"`
+	// AllowedSubjects restricts which identities may exchange tokens, in the same
+	// format as authorized_clients. Empty allows every verified ServiceAccount.
+	AllowedSubjects []string `yaml:"allowed_subjects,omitempty"`
+}
+
+// UnmarshalYAML handles duration parsing from string
+func (s *IssuerSettings) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawIssuerSettings struct {
+		URL              string   `yaml:"url"`
+		SigningKey       string   `yaml:"signing_key"`
+		Audiences        []string `yaml:"audiences"`
+		TokenDuration    string   `yaml:"token_duration"`
+		SubjectAudiences []string `yaml:"subject_audiences"`
+		AllowedSubjects  []string `yaml:"allowed_subjects"`
+	}
+	var raw rawIssuerSettings
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	s.URL = raw.URL
+	s.SigningKey = raw.SigningKey
+	s.Audiences = raw.Audiences
+	s.SubjectAudiences = raw.SubjectAudiences
+	s.AllowedSubjects = raw.AllowedSubjects
+
+	if raw.TokenDuration != "" {
+		d, err := time.ParseDuration(raw.TokenDuration)
+		if err != nil {
+			return fmt.Errorf("parsing token_duration: %w", err)
+		}
+		s.TokenDuration = d
+	}
+
+	return nil
+}
+
+// GetTokenDuration returns the configured lifetime of issued tokens or default
+func (s *IssuerSettings) GetTokenDuration() time.Duration {
+	if s.TokenDuration > 0 {
+		return s.TokenDuration
+	}
+	return DefaultIssuerTokenDuration
+}
+
+// IsAllowedAudience checks if an audience is in the configured audiences list
+func (s *IssuerSettings) IsAllowedAudience(audience string) bool {
+	for _, a := range s.Audiences {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowedSubject checks if an identity may exchange tokens. Returns true if
+// allowed_subjects is empty.
+func (s *IssuerSettings) IsAllowedSubject(cluster, namespace, serviceAccount string) bool {
+	if len(s.AllowedSubjects) == 0 {
+		return true
+	}
+	return matchClient(s.AllowedSubjects, cluster, namespace, serviceAccount)
+}
+
+func (s *IssuerSettings) validate(v *validator) {
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		v.add("issuer.url", "url must be an https URL, got %q", s.URL)
+	} else if u.Path != "" && u.Path != "/" {
+		// Discovery and JWKS are served from the server root
+		v.add("issuer.url", "url must not have a path, got %q", s.URL)
+	}
+	if s.SigningKey == "" {
+		v.add("issuer.signing_key", "signing_key is required")
+	}
+	if len(s.Audiences) == 0 {
+		v.add("issuer.audiences", "at least one audience is required")
+	}
+	if len(s.SubjectAudiences) == 0 {
+		v.add("issuer.subject_audiences", "at least one subject audience is required")
+	}
+	v.clientEntries("issuer.allowed_subjects", s.AllowedSubjects)
+}
+
+// ExchangeSettings is the token exchange policy: which identities may obtain tokens
+// for which ServiceAccounts in other clusters.
+type ExchangeSettings struct {
+	// SubjectAudiences are the audiences the tokens of callers are reviewed for, as
+	// issuer.subject_audiences
+	SubjectAudiences []string          `yaml:"subject_audiences"`
+	Mappings         []ExchangeMapping `yaml:"mappings"`
+}
+
+// ExchangeMapping allows identities matching From to obtain tokens minted via
+// TokenRequest for the ServiceAccount To.
+type ExchangeMapping struct {
+	From          string        `yaml:"from"` // cluster/namespace/serviceaccount, "*" wildcards allowed
+	To            string        `yaml:"to"`   // cluster/namespace/serviceaccount
+	Audiences     []string      `yaml:"audiences,omitempty"`
+	MaxExpiration time.Duration `yaml:"max_expiration"`
+}
+
+// UnmarshalYAML handles duration parsing from string, and the compact form
+// "cluster-a/ci/deployer -> cluster-b/apps/deployer" with default settings
+func (m *ExchangeMapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var compact string
+	if err := unmarshal(&compact); err == nil {
+		from, to, ok := strings.Cut(compact, "->")
+		if !ok {
+			return fmt.Errorf("mapping %q must have the form \"from -> to\"", compact)
+		}
+		*m = ExchangeMapping{From: strings.TrimSpace(from), To: strings.TrimSpace(to)}
+		return nil
+	}
+
+	type rawExchangeMapping struct {
+		From          string   `yaml:"from"`
+		To            string   `yaml:"to"`
+		Audiences     []string `yaml:"audiences"`
+		MaxExpiration string   `yaml:"max_expiration"`
+	}
+	var raw rawExchangeMapping
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	m.From = raw.From
+	m.To = raw.To
+	m.Audiences = raw.Audiences
+
+	if raw.MaxExpiration != "" {
+		d, err := time.ParseDuration(raw.MaxExpiration)
+		if err != nil {
+			return fmt.Errorf("parsing max_expiration: %w", err)
+		}
+		m.MaxExpiration = d
+	}
+
 	return nil
 }
 
+// Target returns the cluster, namespace and name of the mapped ServiceAccount
+func (m *ExchangeMapping) Target() (cluster, namespace, serviceAccount string) {
+	parts := strings.SplitN(m.To, "/", 3)
+	if len(parts) != 3 {
+		return "", "", ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// GetMaxExpiration returns the configured maximum token lifetime or default
+func (m *ExchangeMapping) GetMaxExpiration() time.Duration {
+	if m.MaxExpiration > 0 {
+		return m.MaxExpiration
+	}
+	return DefaultExchangeMaxExpiration
+}
+
+// BoundExpiration returns the token lifetime to request for a requested expiration:
+// max_expiration if none is requested, capped at max_expiration otherwise
+func (m *ExchangeMapping) BoundExpiration(requested time.Duration) (time.Duration, error) {
+	if requested == 0 {
+		return m.GetMaxExpiration(), nil
+	}
+	if requested < MinExchangeExpiration {
+		return 0, fmt.Errorf("expiration must be at least %s", MinExchangeExpiration)
+	}
+	return min(requested, m.GetMaxExpiration()), nil
+}
+
+// IsAllowedAudience checks if an audience may be requested. Only the API server's
+// default audiences (no audience requested) are allowed if audiences is empty.
+func (m *ExchangeMapping) IsAllowedAudience(audience string) bool {
+	for _, a := range m.Audiences {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// MappingsFor returns the mappings whose From matches the given identity
+func (e *ExchangeSettings) MappingsFor(cluster, namespace, serviceAccount string) []ExchangeMapping {
+	var mappings []ExchangeMapping
+	for _, m := range e.Mappings {
+		if matchClient([]string{m.From}, cluster, namespace, serviceAccount) {
+			mappings = append(mappings, m)
+		}
+	}
+	return mappings
+}
+
+func (e *ExchangeSettings) validate(v *validator, clusters map[string]ClusterConfig) {
+	if len(e.SubjectAudiences) == 0 {
+		v.add("exchange.subject_audiences", "at least one subject audience is required")
+	}
+	for i, m := range e.Mappings {
+		path := fmt.Sprintf("exchange.mappings.%d", i)
+		if err := ValidateClientEntry(m.From); err != nil {
+			v.add(path+".from", "from %v", err)
+		}
+		cluster, namespace, serviceAccount := m.Target()
+		if cluster == "" || namespace == "" || serviceAccount == "" || strings.Contains(serviceAccount, "/") {
+			v.add(path+".to", "to must be cluster/namespace/serviceaccount, got %q", m.To)
+		} else if strings.Contains(m.To, "*") {
+			v.add(path+".to", "to must not contain wildcards")
+		} else if _, ok := clusters[cluster]; !ok {
+			v.add(path+".to", "unknown cluster %q", cluster)
+		}
+		if m.MaxExpiration != 0 && m.MaxExpiration < MinExchangeExpiration {
+			v.add(path+".max_expiration", "max_expiration must be at least %s", MinExchangeExpiration)
+		}
+	}
+}
+
+// LimitSettings bounds the size of API requests
+type LimitSettings struct {
+	// MaxBodyBytes is the largest request body accepted
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// MaxTokenBytes is the largest token accepted, whether reviewed or a caller's
+	// bearer token
+	MaxTokenBytes int `yaml:"max_token_bytes"`
+	// MaxBatchSize is the largest number of tokens in a batch TokenReview
+	MaxBatchSize int `yaml:"max_batch_size"`
+	// BatchParallelism is the number of tokens of a batch reviewed concurrently
+	BatchParallelism int `yaml:"batch_parallelism"`
+}
+
+func (l *LimitSettings) validate(v *validator) {
+	if l.MaxBodyBytes < 0 {
+		v.add("limits.max_body_bytes", "must not be negative")
+	}
+	if l.MaxTokenBytes < 0 || l.MaxTokenBytes > MaxTokenBytes {
+		v.add("limits.max_token_bytes", "must be between 0 and %d", MaxTokenBytes)
+	}
+	if l.MaxBatchSize < 0 {
+		v.add("limits.max_batch_size", "must not be negative")
+	}
+	if l.BatchParallelism < 0 {
+		v.add("limits.batch_parallelism", "must not be negative")
+	}
+}
+
+// RateLimit is a token bucket: the sustained rate of requests and the burst allowed
+// above it
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Burst defaults to RequestsPerSecond, rounded up
+	Burst int `yaml:"burst"`
+}
+
+// GetBurst returns the configured burst or default
+func (l *RateLimit) GetBurst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return max(1, int(math.Ceil(l.RequestsPerSecond)))
+}
+
+func (l *RateLimit) validate(v *validator, path string) {
+	if l.RequestsPerSecond <= 0 {
+		v.add(path+".requests_per_second", "must be positive")
+	}
+	if l.Burst < 0 {
+		v.add(path+".burst", "must not be negative")
+	}
+}
+
+// RateLimitSettings protects the server and remote API servers from floods of
+// requests. Unset limits are not enforced.
+type RateLimitSettings struct {
+	// Callers limits each caller identity (cluster/namespace/serviceaccount), or each
+	// client address if callers aren't authenticated
+	Callers *RateLimit `yaml:"callers,omitempty"`
+	// Clusters limits the reviews of tokens from each source cluster
+	Clusters *RateLimit `yaml:"clusters,omitempty"`
+	// MaxConcurrentReviews caps the TokenReview calls in flight to each cluster
+	MaxConcurrentReviews int `yaml:"max_concurrent_reviews"`
+}
+
+func (r *RateLimitSettings) validate(v *validator) {
+	if r.Callers != nil {
+		r.Callers.validate(v, "rate_limits.callers")
+	}
+	if r.Clusters != nil {
+		r.Clusters.validate(v, "rate_limits.clusters")
+	}
+	if r.MaxConcurrentReviews < 0 {
+		v.add("rate_limits.max_concurrent_reviews", "must not be negative")
+	}
+}
+
+// ExtAuthzSettings enables the Envoy ext_authz check API, which allows requests whose
+// bearer token is a ServiceAccount token from a configured cluster.
+type ExtAuthzSettings struct {
+	// Audiences the tokens are reviewed for (required), so that only tokens projected
+	// for the proxied services are accepted, not any workload's default token
+	Audiences []string `yaml:"audiences"`
+	// AllowedSubjects restricts which identities are allowed, in the same format as
+	// authorized_clients. Empty allows every authenticated ServiceAccount.
+	AllowedSubjects []string `yaml:"allowed_subjects,omitempty"`
+}
+
+// IsAllowedSubject checks if an identity's requests are allowed. Returns true if
+// allowed_subjects is empty.
+func (s *ExtAuthzSettings) IsAllowedSubject(cluster, namespace, serviceAccount string) bool {
+	if len(s.AllowedSubjects) == 0 {
+		return true
+	}
+	return matchClient(s.AllowedSubjects, cluster, namespace, serviceAccount)
+}
+
+func (s *ExtAuthzSettings) validate(v *validator) {
+	if len(s.Audiences) == 0 {
+		v.add("ext_authz.audiences", "audiences is required")
+	}
+	v.clientEntries("ext_authz.allowed_subjects", s.AllowedSubjects)
+}
+
+// DefaultDiscoveryServiceAccount is the ServiceAccount ("namespace/name") in discovered
+// clusters that bootstrap tokens are requested for
+const DefaultDiscoveryServiceAccount = "kube-federated-auth/kube-federated-auth"
+
+// DiscoverySettings configures sources that register clusters at runtime
+type DiscoverySettings struct {
+	KubeconfigSecrets *KubeconfigSecretsDiscovery `yaml:"kubeconfig_secrets,omitempty"`
+}
+
+// KubeconfigSecretsDiscovery registers a cluster for each Secret holding a kubeconfig:
+// Cluster API "<cluster>-kubeconfig" Secrets and/or Secrets matching LabelSelector.
+type KubeconfigSecretsDiscovery struct {
+	// Namespace to watch (required). Anyone who can create a selected Secret there can
+	// register a cluster, so it should be restricted to cluster administrators.
+	Namespace string `yaml:"namespace"`
+	// ClusterAPI selects Secrets written by Cluster API
+	ClusterAPI bool `yaml:"cluster_api,omitempty"`
+	// LabelSelector selects additional Secrets, e.g. "kube-federated-auth.io/federate=true"
+	LabelSelector string `yaml:"label_selector,omitempty"`
+	// ServiceAccount ("namespace/name") in each discovered cluster that bootstrap tokens
+	// are requested for with the kubeconfig's credentials
+	ServiceAccount string `yaml:"service_account,omitempty"`
+}
+
+// GetServiceAccount returns the namespace and name of the ServiceAccount that bootstrap
+// tokens are requested for
+func (d *KubeconfigSecretsDiscovery) GetServiceAccount() (namespace, name string) {
+	sa := d.ServiceAccount
+	if sa == "" {
+		sa = DefaultDiscoveryServiceAccount
+	}
+	namespace, name, _ = strings.Cut(sa, "/")
+	return namespace, name
+}
+
+func (d *KubeconfigSecretsDiscovery) validate(v *validator) {
+	const path = "discovery.kubeconfig_secrets"
+	if d.Namespace == "" {
+		v.add(path+".namespace", "namespace is required")
+	}
+	if !d.ClusterAPI && d.LabelSelector == "" {
+		v.add(path, "cluster_api or label_selector is required")
+	}
+	if d.ServiceAccount != "" {
+		namespace, name, ok := strings.Cut(d.ServiceAccount, "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			v.add(path+".service_account", "service_account must be namespace/name, got %q", d.ServiceAccount)
+		}
+	}
+}
+
+// DefaultExecAPIVersion is the client.authentication.k8s.io version used for exec
+// plugins when api_version is not set
+const DefaultExecAPIVersion = "client.authentication.k8s.io/v1beta1"
+
+// ExecConfig configures a client-go exec credential plugin (e.g. "aws eks get-token")
+type ExecConfig struct {
+	Command    string            `yaml:"command"`
+	Args       []string          `yaml:"args,omitempty"`
+	Env        map[string]string `yaml:"env,omitempty"`
+	APIVersion string            `yaml:"api_version,omitempty"`
+}
+
 type ClusterConfig struct {
 	Issuer    string `yaml:"issuer"`
 	APIServer string `yaml:"api_server,omitempty"` // Override URL for OIDC discovery
 	CACert    string `yaml:"ca_cert,omitempty"`
 	TokenPath string `yaml:"token_path,omitempty"`
+
+	// ClientCert and ClientKey authenticate to api_server with an x509 client certificate
+	ClientCert string `yaml:"client_cert,omitempty"`
+	ClientKey  string `yaml:"client_key,omitempty"`
+
+	// Connection settings applied to every connection to the cluster's API server
+	ProxyURL              string `yaml:"proxy_url,omitempty"`
+	TLSServerName         string `yaml:"tls_server_name,omitempty"`
+	TLSMinVersion         string `yaml:"tls_min_version,omitempty"` // "1.2" or "1.3"
+	InsecureSkipTLSVerify bool   `yaml:"insecure_skip_tls_verify,omitempty"`
+
+	// Kubeconfig and Context authenticate to the API server using a kubeconfig file
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+	Context    string `yaml:"context,omitempty"`
+	// Exec authenticates to api_server using an exec credential plugin
+	Exec *ExecConfig `yaml:"exec,omitempty"`
 }
 
 // DiscoveryURL returns the URL to use for OIDC discovery.
@@ -77,22 +539,102 @@ func (c *ClusterConfig) DiscoveryURL() string {
 	return c.Issuer
 }
 
-// IsRemote returns true if this cluster requires remote access (has api_server set)
+// IsRemote returns true if this cluster requires remote access (has api_server set,
+// or authenticates via kubeconfig or exec plugin)
 func (c *ClusterConfig) IsRemote() bool {
-	return c.APIServer != ""
+	return c.APIServer != "" || c.UsesExternalAuth()
+}
+
+// ParseProxyURL returns the parsed proxy_url, or nil if unset
+func (c *ClusterConfig) ParseProxyURL() (*url.URL, error) {
+	if c.ProxyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy_url scheme must be http, https or socks5, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy_url %q has no host", c.ProxyURL)
+	}
+	return u, nil
+}
+
+// ParseTLSMinVersion returns the tls.Version* constant for tls_min_version, or 0 if unset
+func (c *ClusterConfig) ParseTLSMinVersion() (uint16, error) {
+	switch c.TLSMinVersion {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("tls_min_version must be \"1.2\" or \"1.3\", got %q", c.TLSMinVersion)
+}
+
+// UsesClientCert returns true if this cluster authenticates with an x509 client certificate
+func (c *ClusterConfig) UsesClientCert() bool {
+	return c.ClientCert != ""
+}
+
+// RenewsToken returns true if this cluster authenticates with a ServiceAccount token
+// that is renewed via TokenRequest. Clusters using kubeconfig/exec credentials, or a
+// client certificate without a bootstrap token, have nothing to renew.
+func (c *ClusterConfig) RenewsToken() bool {
+	if !c.IsRemote() || c.UsesExternalAuth() {
+		return false
+	}
+	return !c.UsesClientCert() || c.TokenPath != ""
+}
+
+// UsesExternalAuth returns true if API server credentials come from a kubeconfig file
+// or exec plugin rather than a renewed ServiceAccount token
+func (c *ClusterConfig) UsesExternalAuth() bool {
+	return c.Kubeconfig != "" || c.Exec != nil
 }
 
 type Config struct {
-	AuthorizedClients []string                  `yaml:"authorized_clients,omitempty"`
-	Renewal           *RenewalSettings          `yaml:"renewal,omitempty"`
+	AuthorizedClients []string                 `yaml:"authorized_clients,omitempty"`
+	AdminClients      []string                 `yaml:"admin_clients,omitempty"`
+	Renewal           *RenewalSettings         `yaml:"renewal,omitempty"`
+	Issuer            *IssuerSettings          `yaml:"issuer,omitempty"`
+	Exchange          *ExchangeSettings        `yaml:"exchange,omitempty"`
+	Discovery         *DiscoverySettings       `yaml:"discovery,omitempty"`
+	Limits            *LimitSettings           `yaml:"limits,omitempty"`
+	RateLimits        *RateLimitSettings       `yaml:"rate_limits,omitempty"`
+	ExtAuthz          *ExtAuthzSettings        `yaml:"ext_authz,omitempty"`
 	Clusters          map[string]ClusterConfig `yaml:"clusters"`
+
+	// mu guards Clusters, AuthorizedClients and AdminClients, which may be updated
+	// at runtime (see SetCluster and SetAccessPolicy)
+	mu sync.RWMutex
 }
 
 // IsAuthorizedClient checks if a caller identity matches the authorized_clients whitelist.
 // Each entry is in format "cluster/namespace/serviceaccount" with optional "*" wildcards.
 // Returns false if the whitelist is empty (deny all by default).
 func (c *Config) IsAuthorizedClient(cluster, namespace, serviceAccount string) bool {
-	for _, entry := range c.AuthorizedClients {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return matchClient(c.AuthorizedClients, cluster, namespace, serviceAccount)
+}
+
+// IsAdminClient checks if a caller identity matches the admin_clients whitelist,
+// using the same format as authorized_clients. Returns false if the list is empty.
+func (c *Config) IsAdminClient(cluster, namespace, serviceAccount string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return matchClient(c.AdminClients, cluster, namespace, serviceAccount)
+}
+
+func matchClient(entries []string, cluster, namespace, serviceAccount string) bool {
+	for _, entry := range entries {
 		parts := strings.SplitN(entry, "/", 3)
 		if len(parts) != 3 {
 			continue
@@ -132,40 +674,264 @@ func (c *Config) GetRenewalRenewBefore() time.Duration {
 	return DefaultRenewalRenewBefore
 }
 
+// GetRenewalRetryBackoff returns the initial delay before retrying a failed renewal or default
+func (c *Config) GetRenewalRetryBackoff() time.Duration {
+	if c.Renewal != nil && c.Renewal.RetryBackoff > 0 {
+		return c.Renewal.RetryBackoff
+	}
+	return DefaultRenewalRetryBackoff
+}
+
+// GetRenewalMaxBackoff returns the upper bound for retry backoff or default
+func (c *Config) GetRenewalMaxBackoff() time.Duration {
+	if c.Renewal != nil && c.Renewal.MaxBackoff > 0 {
+		return c.Renewal.MaxBackoff
+	}
+	return DefaultRenewalMaxBackoff
+}
+
+// GetRenewalStartupJitter returns the maximum random delay before the first renewal or default
+func (c *Config) GetRenewalStartupJitter() time.Duration {
+	if c.Renewal != nil && c.Renewal.StartupJitter > 0 {
+		return c.Renewal.StartupJitter
+	}
+	return DefaultRenewalStartupJitter
+}
+
+// GetMaxBodyBytes returns the configured maximum request body size or default. It may
+// be called on a nil Config.
+func (c *Config) GetMaxBodyBytes() int64 {
+	if c != nil && c.Limits != nil && c.Limits.MaxBodyBytes > 0 {
+		return c.Limits.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// GetMaxTokenBytes returns the configured maximum token size or default. It may be
+// called on a nil Config.
+func (c *Config) GetMaxTokenBytes() int {
+	if c != nil && c.Limits != nil && c.Limits.MaxTokenBytes > 0 {
+		return c.Limits.MaxTokenBytes
+	}
+	return DefaultMaxTokenBytes
+}
+
+// GetMaxBatchSize returns the configured maximum batch size or default. It may be
+// called on a nil Config.
+func (c *Config) GetMaxBatchSize() int {
+	if c != nil && c.Limits != nil && c.Limits.MaxBatchSize > 0 {
+		return c.Limits.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
+
+// GetBatchParallelism returns the configured batch parallelism or default. It may be
+// called on a nil Config.
+func (c *Config) GetBatchParallelism() int {
+	if c != nil && c.Limits != nil && c.Limits.BatchParallelism > 0 {
+		return c.Limits.BatchParallelism
+	}
+	return DefaultBatchParallelism
+}
+
+// Load reads and validates a config file. Invalid settings are reported together as
+// ValidationErrors.
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	// The node tree locates settings for error messages
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
 		return nil, fmt.Errorf("parsing config file: %w", err)
 	}
 
-	if len(cfg.Clusters) == 0 {
-		return nil, fmt.Errorf("no clusters configured")
+	v := &validator{}
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		// Type errors and unknown keys don't stop decoding of the remaining settings
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+		v.addTypeErrors(typeErr)
+	}
+
+	cfg.validate(v)
+	if err := v.result(&root); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate(v *validator) {
+	if len(c.Clusters) == 0 {
+		v.add("clusters", "no clusters configured")
+	}
+	v.clientEntries("authorized_clients", c.AuthorizedClients)
+	v.clientEntries("admin_clients", c.AdminClients)
+
+	// A shorter token_duration with the default renew_before is handled by renewing at
+	// 80% of the lifetime, but an explicit renew_before must leave room for renewal
+	if c.Renewal != nil && c.Renewal.RenewBefore > 0 && c.Renewal.RenewBefore >= c.GetRenewalTokenDuration() {
+		v.add("renewal.renew_before", "renew_before (%s) must be less than token_duration (%s)", c.Renewal.RenewBefore, c.GetRenewalTokenDuration())
+	}
+
+	if c.Issuer != nil {
+		c.Issuer.validate(v)
 	}
 
-	for name, cluster := range cfg.Clusters {
-		if cluster.Issuer == "" {
-			return nil, fmt.Errorf("cluster %q: issuer is required", name)
+	names := slices.Sorted(maps.Keys(c.Clusters))
+	for _, name := range names {
+		cluster := c.Clusters[name]
+		c.validateCluster(v, name, cluster)
+		// Clusters added at runtime get their CA from a Secret instead
+		if cluster.APIServer != "" && cluster.CACert == "" && cluster.Kubeconfig == "" && !cluster.InsecureSkipTLSVerify {
+			v.add(clusterPath(name, "api_server"), "ca_cert is required with api_server")
 		}
 	}
 
-	return &cfg, nil
+	if c.Exchange != nil {
+		c.Exchange.validate(v, c.Clusters)
+	}
+
+	if c.Discovery != nil && c.Discovery.KubeconfigSecrets != nil {
+		c.Discovery.KubeconfigSecrets.validate(v)
+	}
+
+	if c.Limits != nil {
+		c.Limits.validate(v)
+	}
+	if c.RateLimits != nil {
+		c.RateLimits.validate(v)
+	}
+	if c.ExtAuthz != nil {
+		c.ExtAuthz.validate(v)
+	}
 }
 
-func (c *Config) ClusterNames() []string {
-	names := make([]string, 0, len(c.Clusters))
-	for name := range c.Clusters {
-		names = append(names, name)
+// validateCluster checks a single cluster entry, from the config file or added at runtime
+func (c *Config) validateCluster(v *validator, name string, cluster ClusterConfig) {
+	if cluster.Issuer == "" {
+		v.add(clusterPath(name, "issuer"), "issuer is required")
 	}
-	return names
+	// Issued tokens use "cluster:namespace:serviceaccount" subjects
+	if c.Issuer != nil && strings.Contains(name, ":") {
+		v.add(clusterPath(name), "name must not contain ':' when issuer is configured")
+	}
+	if cluster.Kubeconfig != "" && cluster.Exec != nil {
+		v.add(clusterPath(name, "exec"), "kubeconfig and exec are mutually exclusive")
+	}
+	if cluster.Context != "" && cluster.Kubeconfig == "" {
+		v.add(clusterPath(name, "context"), "context requires kubeconfig")
+	}
+	if _, err := cluster.ParseProxyURL(); err != nil {
+		v.add(clusterPath(name, "proxy_url"), "%v", err)
+	}
+	if _, err := cluster.ParseTLSMinVersion(); err != nil {
+		v.add(clusterPath(name, "tls_min_version"), "%v", err)
+	}
+	if (cluster.ClientCert == "") != (cluster.ClientKey == "") {
+		v.add(clusterPath(name, "client_cert"), "client_cert and client_key must be set together")
+	}
+	if cluster.UsesClientCert() {
+		if cluster.APIServer == "" {
+			v.add(clusterPath(name, "client_cert"), "api_server is required with client_cert")
+		}
+		if cluster.UsesExternalAuth() {
+			v.add(clusterPath(name, "client_cert"), "client_cert cannot be combined with kubeconfig or exec")
+		}
+	}
+	if cluster.Exec != nil {
+		if cluster.Exec.Command == "" {
+			v.add(clusterPath(name, "exec", "command"), "exec.command is required")
+		}
+		if cluster.APIServer == "" {
+			v.add(clusterPath(name, "exec"), "api_server is required with exec")
+		}
+	}
+}
+
+// Cluster returns the configuration of a cluster
+func (c *Config) Cluster(name string) (ClusterConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cluster, ok := c.Clusters[name]
+	return cluster, ok
+}
+
+// ClusterConfigs returns a copy of all cluster configurations
+func (c *Config) ClusterConfigs() map[string]ClusterConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	clusters := make(map[string]ClusterConfig, len(c.Clusters))
+	for name, cluster := range c.Clusters {
+		clusters[name] = cluster
+	}
+	return clusters
+}
+
+// SetCluster validates and adds or replaces a cluster at runtime
+func (c *Config) SetCluster(name string, cluster ClusterConfig) error {
+	v := &validator{}
+	c.validateCluster(v, name, cluster)
+	if err := v.result(nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Clusters == nil {
+		c.Clusters = make(map[string]ClusterConfig)
+	}
+	c.Clusters[name] = cluster
+	return nil
+}
+
+// DeleteCluster removes a cluster at runtime
+func (c *Config) DeleteCluster(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.Clusters, name)
+}
+
+// AccessPolicy returns copies of authorized_clients and admin_clients
+func (c *Config) AccessPolicy() (authorizedClients, adminClients []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.AuthorizedClients), slices.Clone(c.AdminClients)
+}
+
+// SetAccessPolicy replaces authorized_clients and admin_clients at runtime
+func (c *Config) SetAccessPolicy(authorizedClients, adminClients []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.AuthorizedClients = authorizedClients
+	c.AdminClients = adminClients
+}
+
+// HasAuthorizedClients returns true if callers must be authorized to use the
+// TokenReview endpoint
+func (c *Config) HasAuthorizedClients() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.AuthorizedClients) > 0
+}
+
+// ClusterNames returns the configured cluster names, sorted
+func (c *Config) ClusterNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Sorted(maps.Keys(c.Clusters))
 }
 
 // GetRemoteClusters returns cluster names that are remote (have api_server set)
 func (c *Config) GetRemoteClusters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	var names []string
 	for name, cfg := range c.Clusters {
 		if cfg.IsRemote() {
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"crypto/tls"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad_ValidConfig(t *testing.T) {
@@ -162,6 +167,7 @@ clusters:
   cluster-b:
     issuer: "https://oidc.other.com"
     api_server: "https://192.168.1.100:6443"
+    ca_cert: "/path/to/ca.crt"
   cluster-c:
     issuer: "https://oidc.third.com"
 `
@@ -176,6 +182,143 @@ clusters:
 	}
 }
 
+func TestLoad_ExternalAuth(t *testing.T) {
+	content := `
+clusters:
+  eks:
+    issuer: "https://oidc.eks.us-west-2.amazonaws.com/id/EXAMPLE"
+    api_server: "https://EXAMPLE.gr7.us-west-2.eks.amazonaws.com"
+    ca_cert: "/path/to/eks-ca.crt"
+    exec:
+      command: aws
+      args: ["eks", "get-token", "--cluster-name", "prod"]
+      env:
+        AWS_PROFILE: prod
+  gke:
+    issuer: "https://container.googleapis.com/v1/projects/p/locations/l/clusters/c"
+    kubeconfig: "/path/to/kubeconfig"
+    context: "gke_p_l_c"
+`
+	cfg := loadFromString(t, content)
+
+	eks := cfg.Clusters["eks"]
+	if eks.Exec == nil || eks.Exec.Command != "aws" || len(eks.Exec.Args) != 4 || eks.Exec.Env["AWS_PROFILE"] != "prod" {
+		t.Errorf("eks exec = %+v, want aws eks get-token with AWS_PROFILE", eks.Exec)
+	}
+	if !eks.UsesExternalAuth() || !eks.IsRemote() {
+		t.Error("eks should use external auth and be remote")
+	}
+
+	gke := cfg.Clusters["gke"]
+	if gke.Kubeconfig != "/path/to/kubeconfig" || gke.Context != "gke_p_l_c" {
+		t.Errorf("gke kubeconfig = %q context = %q", gke.Kubeconfig, gke.Context)
+	}
+	if !gke.UsesExternalAuth() || !gke.IsRemote() {
+		t.Error("gke should use external auth and be remote even without api_server")
+	}
+}
+
+func TestLoad_ExternalAuthValidation(t *testing.T) {
+	tests := map[string]string{
+		"kubeconfig and exec": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://c.example.com"
+    kubeconfig: "/path/to/kubeconfig"
+    exec:
+      command: aws
+`,
+		"exec without api_server": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    exec:
+      command: aws
+`,
+		"exec without command": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://c.example.com"
+    exec:
+      args: ["eks"]
+`,
+		"context without kubeconfig": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    context: "prod"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_ClientCert(t *testing.T) {
+	content := `
+clusters:
+  on-prem:
+    issuer: "https://kubernetes.internal.corp"
+    api_server: "https://10.0.0.1:6443"
+    ca_cert: "/path/to/ca.crt"
+    client_cert: "/path/to/client.crt"
+    client_key: "/path/to/client.key"
+`
+	cfg := loadFromString(t, content)
+
+	c := cfg.Clusters["on-prem"]
+	if !c.UsesClientCert() {
+		t.Error("expected cluster to use client cert")
+	}
+	if c.RenewsToken() {
+		t.Error("client-cert cluster without token_path should not renew a token")
+	}
+
+	c.TokenPath = "/path/to/token"
+	if !c.RenewsToken() {
+		t.Error("client-cert cluster with token_path should renew its token")
+	}
+}
+
+func TestLoad_ClientCertValidation(t *testing.T) {
+	tests := map[string]string{
+		"cert without key": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://10.0.0.1:6443"
+    client_cert: "/path/to/client.crt"
+`,
+		"cert without api_server": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    client_cert: "/path/to/client.crt"
+    client_key: "/path/to/client.key"
+`,
+		"cert with kubeconfig": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://10.0.0.1:6443"
+    kubeconfig: "/path/to/kubeconfig"
+    client_cert: "/path/to/client.crt"
+    client_key: "/path/to/client.key"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
 func TestRenewalDefaults(t *testing.T) {
 	content := `
 clusters:
@@ -193,6 +336,43 @@ clusters:
 	}
 }
 
+func TestLoad_RenewalRetrySettings(t *testing.T) {
+	content := `
+renewal:
+  retry_backoff: "5s"
+  max_backoff: "5m"
+  startup_jitter: "1m"
+clusters:
+  cluster-a:
+    issuer: "https://oidc.example.com"
+`
+	cfg := loadFromString(t, content)
+
+	if cfg.GetRenewalRetryBackoff() != 5*time.Second {
+		t.Errorf("retry_backoff = %v, want 5s", cfg.GetRenewalRetryBackoff())
+	}
+	if cfg.GetRenewalMaxBackoff() != 5*time.Minute {
+		t.Errorf("max_backoff = %v, want 5m", cfg.GetRenewalMaxBackoff())
+	}
+	if cfg.GetRenewalStartupJitter() != time.Minute {
+		t.Errorf("startup_jitter = %v, want 1m", cfg.GetRenewalStartupJitter())
+	}
+}
+
+func TestRenewalRetryDefaults(t *testing.T) {
+	cfg := &Config{}
+
+	if cfg.GetRenewalRetryBackoff() != DefaultRenewalRetryBackoff {
+		t.Errorf("retry_backoff = %v, want %v", cfg.GetRenewalRetryBackoff(), DefaultRenewalRetryBackoff)
+	}
+	if cfg.GetRenewalMaxBackoff() != DefaultRenewalMaxBackoff {
+		t.Errorf("max_backoff = %v, want %v", cfg.GetRenewalMaxBackoff(), DefaultRenewalMaxBackoff)
+	}
+	if cfg.GetRenewalStartupJitter() != DefaultRenewalStartupJitter {
+		t.Errorf("startup_jitter = %v, want %v", cfg.GetRenewalStartupJitter(), DefaultRenewalStartupJitter)
+	}
+}
+
 func TestIsAuthorizedClient_ExactMatch(t *testing.T) {
 	cfg := &Config{
 		AuthorizedClients: []string{"cluster-a/default/my-app"},
@@ -278,6 +458,26 @@ func TestIsAuthorizedClient_MalformedEntry(t *testing.T) {
 	}
 }
 
+func TestIsAdminClient(t *testing.T) {
+	cfg := &Config{
+		AuthorizedClients: []string{"*/*/*"},
+		AdminClients:      []string{"cluster-a/kube-federated-auth/*"},
+	}
+	if !cfg.IsAdminClient("cluster-a", "kube-federated-auth", "operator") {
+		t.Error("expected admin entry to match")
+	}
+	if cfg.IsAdminClient("cluster-a", "default", "my-app") {
+		t.Error("expected authorized_clients not to grant admin access")
+	}
+}
+
+func TestIsAdminClient_EmptyList(t *testing.T) {
+	cfg := &Config{AuthorizedClients: []string{"*/*/*"}}
+	if cfg.IsAdminClient("cluster-a", "default", "my-app") {
+		t.Error("expected empty admin list to deny all")
+	}
+}
+
 // Helper functions
 
 func loadFromString(t *testing.T, content string) *Config {
@@ -303,3 +503,670 @@ func loadFromStringErr(content string) (*Config, error) {
 
 	return Load(path)
 }
+
+func TestLoad_ConnectionSettings(t *testing.T) {
+	content := `
+clusters:
+  remote:
+    issuer: "https://remote.example.com"
+    api_server: "https://10.0.0.1:6443"
+    proxy_url: "http://proxy.internal:3128"
+    tls_server_name: "kubernetes.default.svc"
+    tls_min_version: "1.3"
+    insecure_skip_tls_verify: true
+`
+	cfg := loadFromString(t, content)
+
+	remote := cfg.Clusters["remote"]
+	proxyURL, err := remote.ParseProxyURL()
+	if err != nil {
+		t.Fatalf("ParseProxyURL: %v", err)
+	}
+	if proxyURL.String() != "http://proxy.internal:3128" {
+		t.Errorf("proxy_url = %q, want %q", proxyURL, "http://proxy.internal:3128")
+	}
+	if remote.TLSServerName != "kubernetes.default.svc" {
+		t.Errorf("tls_server_name = %q, want %q", remote.TLSServerName, "kubernetes.default.svc")
+	}
+	if v, _ := remote.ParseTLSMinVersion(); v != tls.VersionTLS13 {
+		t.Errorf("tls_min_version = %x, want %x", v, tls.VersionTLS13)
+	}
+	if !remote.InsecureSkipTLSVerify {
+		t.Error("expected insecure_skip_tls_verify to be true")
+	}
+}
+
+func TestLoad_ConnectionSettingsValidation(t *testing.T) {
+	tests := map[string]string{
+		"unsupported proxy scheme": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://10.0.0.1:6443"
+    proxy_url: "ftp://proxy.internal"
+`,
+		"proxy without host": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://10.0.0.1:6443"
+    proxy_url: "http://"
+`,
+		"unsupported tls version": `
+clusters:
+  c:
+    issuer: "https://c.example.com"
+    api_server: "https://10.0.0.1:6443"
+    tls_min_version: "1.1"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_Issuer(t *testing.T) {
+	content := `
+issuer:
+  url: "https://federated.example.com"
+  signing_key: "/etc/kube-federated-auth/signing/key.pem"
+  audiences: ["sts.amazonaws.com", "vault"]
+  subject_audiences: ["kube-federated-auth"]
+  token_duration: "15m"
+  allowed_subjects:
+    - "cluster-b/apps/*"
+clusters:
+  cluster-b:
+    issuer: "https://b.example.com"
+`
+	cfg := loadFromString(t, content)
+
+	if cfg.Issuer == nil {
+		t.Fatal("expected issuer settings")
+	}
+	if len(cfg.Issuer.SubjectAudiences) != 1 || cfg.Issuer.SubjectAudiences[0] != "kube-federated-auth" {
+		t.Errorf("subject_audiences = %v, want [kube-federated-auth]", cfg.Issuer.SubjectAudiences)
+	}
+	if cfg.Issuer.GetTokenDuration() != 15*time.Minute {
+		t.Errorf("token_duration = %v, want 15m", cfg.Issuer.GetTokenDuration())
+	}
+	if !cfg.Issuer.IsAllowedAudience("vault") || cfg.Issuer.IsAllowedAudience("other") {
+		t.Error("unexpected audience check result")
+	}
+	if !cfg.Issuer.IsAllowedSubject("cluster-b", "apps", "deployer") {
+		t.Error("expected cluster-b/apps/deployer to be allowed")
+	}
+	if cfg.Issuer.IsAllowedSubject("cluster-b", "default", "deployer") {
+		t.Error("expected cluster-b/default/deployer to be denied")
+	}
+}
+
+func TestIssuerDefaults(t *testing.T) {
+	settings := &IssuerSettings{}
+	if settings.GetTokenDuration() != DefaultIssuerTokenDuration {
+		t.Errorf("token_duration = %v, want %v", settings.GetTokenDuration(), DefaultIssuerTokenDuration)
+	}
+	if !settings.IsAllowedSubject("any", "ns", "sa") {
+		t.Error("expected empty allowed_subjects to allow everyone")
+	}
+}
+
+func TestLoad_IssuerValidation(t *testing.T) {
+	tests := map[string]string{
+		"http url": `
+issuer:
+  url: "http://federated.example.com"
+  signing_key: "/key.pem"
+  audiences: ["vault"]
+  subject_audiences: ["kube-federated-auth"]
+clusters:
+  c:
+    issuer: "https://c.example.com"
+`,
+		"url with path": `
+issuer:
+  url: "https://example.com/federated"
+  signing_key: "/key.pem"
+  audiences: ["vault"]
+  subject_audiences: ["kube-federated-auth"]
+clusters:
+  c:
+    issuer: "https://c.example.com"
+`,
+		"missing signing key": `
+issuer:
+  url: "https://federated.example.com"
+  audiences: ["vault"]
+  subject_audiences: ["kube-federated-auth"]
+clusters:
+  c:
+    issuer: "https://c.example.com"
+`,
+		"missing audiences": `
+issuer:
+  url: "https://federated.example.com"
+  signing_key: "/key.pem"
+  subject_audiences: ["kube-federated-auth"]
+clusters:
+  c:
+    issuer: "https://c.example.com"
+`,
+		"missing subject audiences": `
+issuer:
+  url: "https://federated.example.com"
+  signing_key: "/key.pem"
+  audiences: ["vault"]
+clusters:
+  c:
+    issuer: "https://c.example.com"
+`,
+		"colon in cluster name": `
+issuer:
+  url: "https://federated.example.com"
+  signing_key: "/key.pem"
+  audiences: ["vault"]
+  subject_audiences: ["kube-federated-auth"]
+clusters:
+  "a:b":
+    issuer: "https://c.example.com"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_Exchange(t *testing.T) {
+	content := `
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - from: "cluster-a/ci/*"
+      to: "cluster-b/apps/deployer"
+      audiences: ["https://b.example.com"]
+      max_expiration: "30m"
+    - from: "cluster-a/ci/deployer"
+      to: "cluster-b/apps/reader"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+  cluster-b:
+    issuer: "https://b.example.com"
+`
+	cfg := loadFromString(t, content)
+
+	if len(cfg.Exchange.Mappings) != 2 {
+		t.Fatalf("expected 2 mappings, got %d", len(cfg.Exchange.Mappings))
+	}
+	m := cfg.Exchange.Mappings[0]
+	if m.GetMaxExpiration() != 30*time.Minute {
+		t.Errorf("max_expiration = %v, want 30m", m.GetMaxExpiration())
+	}
+	if cfg.Exchange.Mappings[1].GetMaxExpiration() != DefaultExchangeMaxExpiration {
+		t.Errorf("default max_expiration = %v, want %v", cfg.Exchange.Mappings[1].GetMaxExpiration(), DefaultExchangeMaxExpiration)
+	}
+	if cluster, ns, sa := m.Target(); cluster != "cluster-b" || ns != "apps" || sa != "deployer" {
+		t.Errorf("Target() = %s/%s/%s, want cluster-b/apps/deployer", cluster, ns, sa)
+	}
+	if !m.IsAllowedAudience("https://b.example.com") || m.IsAllowedAudience("vault") {
+		t.Error("IsAllowedAudience should only allow configured audiences")
+	}
+
+	if got := cfg.Exchange.MappingsFor("cluster-a", "ci", "deployer"); len(got) != 2 {
+		t.Errorf("MappingsFor(ci/deployer) returned %d mappings, want 2", len(got))
+	}
+	if got := cfg.Exchange.MappingsFor("cluster-a", "ci", "tester"); len(got) != 1 {
+		t.Errorf("MappingsFor(ci/tester) returned %d mappings, want 1", len(got))
+	}
+	if got := cfg.Exchange.MappingsFor("cluster-b", "ci", "deployer"); len(got) != 0 {
+		t.Errorf("MappingsFor(cluster-b) returned %d mappings, want 0", len(got))
+	}
+}
+
+func TestLoad_ExchangeValidation(t *testing.T) {
+	tests := map[string]string{
+		"malformed from": `
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - from: "cluster-a/deployer"
+      to: "cluster-a/apps/deployer"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"wildcard target": `
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - from: "cluster-a/ci/deployer"
+      to: "cluster-a/apps/*"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"unknown target cluster": `
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - from: "cluster-a/ci/deployer"
+      to: "cluster-x/apps/deployer"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"missing subject audiences": `
+exchange:
+  mappings:
+    - from: "cluster-a/ci/deployer"
+      to: "cluster-a/apps/deployer"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"max_expiration below minimum": `
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - from: "cluster-a/ci/deployer"
+      to: "cluster-a/apps/deployer"
+      max_expiration: "5m"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_ExchangeCompactMapping(t *testing.T) {
+	content := `
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - "cluster-a/ci/deployer -> cluster-b/apps/deployer"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+  cluster-b:
+    issuer: "https://b.example.com"
+`
+	cfg := loadFromString(t, content)
+
+	m := cfg.Exchange.Mappings[0]
+	if m.From != "cluster-a/ci/deployer" || m.To != "cluster-b/apps/deployer" {
+		t.Errorf("mapping = %+v, want cluster-a/ci/deployer -> cluster-b/apps/deployer", m)
+	}
+	if m.GetMaxExpiration() != DefaultExchangeMaxExpiration {
+		t.Errorf("max_expiration = %v, want default", m.GetMaxExpiration())
+	}
+
+	if _, err := loadFromStringErr(`
+exchange:
+  subject_audiences: ["kube-federated-auth"]
+  mappings:
+    - "cluster-a/ci/deployer cluster-b/apps/deployer"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`); err == nil {
+		t.Error("expected error for mapping without ->")
+	}
+}
+
+func TestExchangeMapping_BoundExpiration(t *testing.T) {
+	m := ExchangeMapping{MaxExpiration: 30 * time.Minute}
+
+	tests := []struct {
+		requested time.Duration
+		want      time.Duration
+		wantErr   bool
+	}{
+		{0, 30 * time.Minute, false},
+		{15 * time.Minute, 15 * time.Minute, false},
+		{2 * time.Hour, 30 * time.Minute, false},
+		{time.Minute, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := m.BoundExpiration(tt.requested)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("BoundExpiration(%v) = %v, %v; want %v, error %v", tt.requested, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSetCluster(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.SetCluster("cluster-b", ClusterConfig{Issuer: "https://b.example.com"}); err != nil {
+		t.Fatalf("SetCluster: %v", err)
+	}
+	if _, ok := cfg.Cluster("cluster-b"); !ok {
+		t.Error("expected cluster-b to be added")
+	}
+
+	if err := cfg.SetCluster("cluster-c", ClusterConfig{APIServer: "https://10.0.0.1:6443"}); err == nil {
+		t.Error("expected error for cluster without issuer")
+	}
+	if _, ok := cfg.Cluster("cluster-c"); ok {
+		t.Error("expected invalid cluster not to be added")
+	}
+
+	cfg.DeleteCluster("cluster-b")
+	if len(cfg.ClusterNames()) != 0 {
+		t.Errorf("clusters = %v, want none", cfg.ClusterNames())
+	}
+}
+
+func TestSetAccessPolicy(t *testing.T) {
+	cfg := &Config{AuthorizedClients: []string{"cluster-a/default/client"}}
+
+	authorized, admin := cfg.AccessPolicy()
+	cfg.SetAccessPolicy(append(authorized, "cluster-b/ci/*"), append(admin, "cluster-a/ops/operator"))
+
+	if !cfg.IsAuthorizedClient("cluster-b", "ci", "runner") || !cfg.IsAdminClient("cluster-a", "ops", "operator") {
+		t.Error("expected updated access policy to apply")
+	}
+	if !cfg.HasAuthorizedClients() {
+		t.Error("expected HasAuthorizedClients to be true")
+	}
+}
+
+func TestLoad_DiscoveryKubeconfigSecrets(t *testing.T) {
+	content := `
+discovery:
+  kubeconfig_secrets:
+    namespace: capi-system
+    cluster_api: true
+    label_selector: "kube-federated-auth.io/federate=true"
+clusters:
+  local:
+    issuer: "https://kubernetes.default.svc.cluster.local"
+`
+	cfg := loadFromString(t, content)
+
+	d := cfg.Discovery.KubeconfigSecrets
+	if d.Namespace != "capi-system" || !d.ClusterAPI || d.LabelSelector != "kube-federated-auth.io/federate=true" {
+		t.Errorf("unexpected discovery settings: %+v", d)
+	}
+	if ns, name := d.GetServiceAccount(); ns != "kube-federated-auth" || name != "kube-federated-auth" {
+		t.Errorf("GetServiceAccount() = %s/%s, want default", ns, name)
+	}
+
+	d.ServiceAccount = "federation/reviewer"
+	if ns, name := d.GetServiceAccount(); ns != "federation" || name != "reviewer" {
+		t.Errorf("GetServiceAccount() = %s/%s, want federation/reviewer", ns, name)
+	}
+}
+
+func TestLoad_DiscoveryValidation(t *testing.T) {
+	tests := map[string]string{
+		"no selection": `
+discovery:
+  kubeconfig_secrets:
+    namespace: capi-system
+clusters:
+  local:
+    issuer: "https://kubernetes.default.svc.cluster.local"
+`,
+		"no namespace": `
+discovery:
+  kubeconfig_secrets:
+    cluster_api: true
+clusters:
+  local:
+    issuer: "https://kubernetes.default.svc.cluster.local"
+`,
+		"malformed service_account": `
+discovery:
+  kubeconfig_secrets:
+    namespace: capi-system
+    cluster_api: true
+    service_account: "reviewer"
+clusters:
+  local:
+    issuer: "https://kubernetes.default.svc.cluster.local"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_StrictValidation(t *testing.T) {
+	tests := map[string]string{
+		"api_server without ca_cert": `
+clusters:
+  remote:
+    issuer: "https://remote.example.com"
+    api_server: "https://10.0.0.1:6443"
+`,
+		"malformed authorized_clients entry": `
+authorized_clients:
+  - "cluster-a/default"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"partial wildcard in admin_clients": `
+admin_clients:
+  - "cluster-a/ci-*/deployer"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"renew_before not less than token_duration": `
+renewal:
+  token_duration: "24h"
+  renew_before: "24h"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"unknown top-level key": `
+authorised_clients:
+  - "cluster-a/default/client"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+		"unknown cluster key": `
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+    ca_crt: "/path/to/ca.crt"
+`,
+		"unknown renewal key": `
+renewal:
+  renew_after: "24h"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+`,
+	}
+
+	for name, content := range tests {
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_StrictValidationAllowed(t *testing.T) {
+	// Insecure clusters and kubeconfig clusters don't need ca_cert, and the default
+	// renew_before may exceed a short token_duration
+	content := `
+renewal:
+  token_duration: "24h"
+clusters:
+  insecure:
+    issuer: "https://insecure.example.com"
+    api_server: "https://10.0.0.1:6443"
+    insecure_skip_tls_verify: true
+  gke:
+    issuer: "https://container.googleapis.com/v1/projects/p/locations/l/clusters/c"
+    kubeconfig: "/path/to/kubeconfig"
+    api_server: "https://10.0.0.2"
+`
+	loadFromString(t, content)
+}
+
+func TestLoad_AggregatedErrors(t *testing.T) {
+	content := `authorized_clients:
+  - "cluster-a/default/client"
+  - "cluster-a/default"
+renewal:
+  interval: "1h"
+  renew_after: "24h"
+clusters:
+  cluster-a:
+    issuer: "https://a.example.com"
+  prod.eu:
+    api_server: "https://10.0.0.1:6443"
+`
+	_, err := loadFromStringErr(content)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+
+	want := []string{
+		`line 3: authorized_clients.1: entry must be cluster/namespace/serviceaccount, got "cluster-a/default"`,
+		`line 6: renewal.renew_after: unknown field "renew_after"`,
+		`line 10: clusters.prod.eu.issuer: issuer is required`,
+		`line 11: clusters.prod.eu.api_server: ca_cert is required with api_server`,
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateClientEntry(t *testing.T) {
+	for _, entry := range []string{"cluster-a/default/client", "*/*/*", "cluster-a/*/reader"} {
+		if err := ValidateClientEntry(entry); err != nil {
+			t.Errorf("ValidateClientEntry(%q) = %v, want nil", entry, err)
+		}
+	}
+	for _, entry := range []string{"", "cluster-a/default", "cluster-a//client", "a/b/c/d", "cluster-a/ci-*/deployer"} {
+		if err := ValidateClientEntry(entry); err == nil {
+			t.Errorf("ValidateClientEntry(%q) = nil, want error", entry)
+		}
+	}
+}
+
+func TestLoad_Limits(t *testing.T) {
+	cfg := loadFromString(t, `
+limits:
+  max_body_bytes: 1024
+  max_token_bytes: 2048
+  max_batch_size: 10
+clusters:
+  cluster-a:
+    issuer: "https://oidc.example.com"
+`)
+	if cfg.GetMaxBodyBytes() != 1024 || cfg.GetMaxTokenBytes() != 2048 {
+		t.Errorf("limits = %d/%d, want 1024/2048", cfg.GetMaxBodyBytes(), cfg.GetMaxTokenBytes())
+	}
+	if cfg.GetMaxBatchSize() != 10 || cfg.GetBatchParallelism() != DefaultBatchParallelism {
+		t.Errorf("batch limits = %d/%d, want 10 and the default parallelism", cfg.GetMaxBatchSize(), cfg.GetBatchParallelism())
+	}
+
+	var unset *Config
+	if unset.GetMaxBodyBytes() != DefaultMaxBodyBytes || unset.GetMaxTokenBytes() != DefaultMaxTokenBytes {
+		t.Errorf("expected defaults on a nil config")
+	}
+
+	tests := map[string]string{
+		"negative body":    "max_body_bytes: -1",
+		"token over bound": "max_token_bytes: 1000000",
+		"negative batch":   "batch_parallelism: -1",
+	}
+	for name, limit := range tests {
+		content := "limits:\n  " + limit + "\nclusters:\n  c:\n    issuer: \"https://c.example.com\"\n"
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_RateLimits(t *testing.T) {
+	cfg := loadFromString(t, `
+rate_limits:
+  callers:
+    requests_per_second: 2.5
+  clusters:
+    requests_per_second: 100
+    burst: 50
+  max_concurrent_reviews: 10
+clusters:
+  cluster-a:
+    issuer: "https://oidc.example.com"
+`)
+	if got := cfg.RateLimits.Callers.GetBurst(); got != 3 {
+		t.Errorf("callers burst = %d, want the rate rounded up", got)
+	}
+	if got := cfg.RateLimits.Clusters.GetBurst(); got != 50 {
+		t.Errorf("clusters burst = %d, want 50", got)
+	}
+	if cfg.RateLimits.MaxConcurrentReviews != 10 {
+		t.Errorf("max_concurrent_reviews = %d, want 10", cfg.RateLimits.MaxConcurrentReviews)
+	}
+
+	tests := map[string]string{
+		"no rate":              "callers: {burst: 10}",
+		"negative burst":       "clusters: {requests_per_second: 1, burst: -1}",
+		"negative concurrency": "max_concurrent_reviews: -1",
+	}
+	for name, limit := range tests {
+		content := "rate_limits:\n  " + limit + "\nclusters:\n  c:\n    issuer: \"https://c.example.com\"\n"
+		if _, err := loadFromStringErr(content); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestLoad_ExtAuthz(t *testing.T) {
+	cfg := loadFromString(t, `
+ext_authz:
+  audiences: ["edge"]
+  allowed_subjects:
+    - "cluster-b/apps/*"
+clusters:
+  cluster-b:
+    issuer: "https://oidc.example.com"
+`)
+	if len(cfg.ExtAuthz.Audiences) != 1 || cfg.ExtAuthz.Audiences[0] != "edge" {
+		t.Errorf("audiences = %v, want [edge]", cfg.ExtAuthz.Audiences)
+	}
+	if !cfg.ExtAuthz.IsAllowedSubject("cluster-b", "apps", "web") || cfg.ExtAuthz.IsAllowedSubject("cluster-b", "default", "web") {
+		t.Error("allowed_subjects not applied")
+	}
+	if !(&ExtAuthzSettings{}).IsAllowedSubject("cluster-b", "default", "web") {
+		t.Error("empty allowed_subjects should allow every subject")
+	}
+
+	if _, err := loadFromStringErr("ext_authz:\n  audiences: [\"edge\"]\n  allowed_subjects: [\"cluster-b/apps\"]\nclusters:\n  c:\n    issuer: \"https://c.example.com\"\n"); err == nil {
+		t.Error("expected error for an invalid allowed_subjects entry, got nil")
+	}
+	if _, err := loadFromStringErr("ext_authz:\n  allowed_subjects: [\"cluster-b/apps/*\"]\nclusters:\n  c:\n    issuer: \"https://c.example.com\"\n"); err == nil {
+		t.Error("expected error for ext_authz without audiences, got nil")
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is an invalid setting in the config file
+type ValidationError struct {
+	Path    string // dotted path of the setting, e.g. clusters.cluster-b.api_server
+	Line    int    // line in the config file, 0 if unknown
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Message
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	return msg
+}
+
+// ValidationErrors is every invalid setting found in a config file, ordered by line
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors:\n  %s", len(e), strings.Join(msgs, "\n  "))
+}
+
+// validator collects validation errors
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// clientEntries checks entries in the authorized_clients format
+func (v *validator) clientEntries(path string, entries []string) {
+	for i, entry := range entries {
+		if err := ValidateClientEntry(entry); err != nil {
+			v.add(path+"."+strconv.Itoa(i), "entry %v", err)
+		}
+	}
+}
+
+var (
+	typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+	unknownField  = regexp.MustCompile(`^field (\S+) not found in type`)
+)
+
+// addTypeErrors adds decoding errors such as unknown keys and mistyped values
+func (v *validator) addTypeErrors(err *yaml.TypeError) {
+	for _, msg := range err.Errors {
+		e := &ValidationError{Message: msg}
+		if m := typeErrorLine.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Message = m[2]
+			if f := unknownField.FindStringSubmatch(e.Message); f != nil {
+				e.Message = fmt.Sprintf("unknown field %q", f[1])
+			}
+		}
+		v.errs = append(v.errs, e)
+	}
+}
+
+// result returns the collected errors, or nil. With the document's node tree, errors
+// are given the line of their setting, or of the closest enclosing one if it's unset.
+func (v *validator) result(root *yaml.Node) error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	if root != nil {
+		for _, e := range v.errs {
+			if e.Line == 0 && e.Path != "" {
+				e.Line = locate(root, strings.Split(e.Path, "."))
+			} else if e.Line > 0 && e.Path == "" {
+				e.Path, _ = pathAt(root, e.Line, "")
+			}
+		}
+	}
+	slices.SortStableFunc(v.errs, func(a, b *ValidationError) int {
+		// Errors without a line last
+		if a.Line == 0 || b.Line == 0 {
+			return b.Line - a.Line
+		}
+		return a.Line - b.Line
+	})
+	return v.errs
+}
+
+// locate returns the line of the deepest node along path. Mapping keys may contain
+// dots, e.g. cluster names.
+func locate(node *yaml.Node, path []string) int {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := 0
+	for len(path) > 0 {
+		switch node.Kind {
+		case yaml.MappingNode:
+			key, value, n := lookupKey(node, path)
+			if key == nil {
+				return line
+			}
+			line, node, path = key.Line, value, path[n:]
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(path[0])
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return line
+			}
+			node = node.Content[i]
+			line, path = node.Line, path[1:]
+		default:
+			return line
+		}
+	}
+	return line
+}
+
+// lookupKey finds the mapping key matching the longest prefix of path, and returns it
+// with its value and the number of path segments it matched
+func lookupKey(node *yaml.Node, path []string) (key, value *yaml.Node, n int) {
+	for n = len(path); n > 0; n-- {
+		name := strings.Join(path[:n], ".")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == name {
+				return node.Content[i], node.Content[i+1], n
+			}
+		}
+	}
+	return nil, nil, 0
+}
+
+// pathAt returns the path of the first key on a line
+func pathAt(node *yaml.Node, line int, prefix string) (string, bool) {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if path, ok := pathAt(child, line, prefix); ok {
+				return path, true
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Line == line {
+				return join(key.Value), true
+			}
+			if path, ok := pathAt(node.Content[i+1], line, join(key.Value)); ok {
+				return path, true
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if child.Line == line && child.Kind == yaml.ScalarNode {
+				return join(strconv.Itoa(i)), true
+			}
+			if path, ok := pathAt(child, line, join(strconv.Itoa(i))); ok {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// clusterPath returns the path of a cluster's setting
+func clusterPath(name string, fields ...string) string {
+	return strings.Join(append([]string{"clusters", name}, fields...), ".")
+}
+
+// ValidateClientEntry checks an entry in the authorized_clients format:
+// cluster/namespace/serviceaccount, each segment a name or "*"
+func ValidateClientEntry(entry string) error {
+	parts := strings.Split(entry, "/")
+	if len(parts) != 3 || slices.Contains(parts, "") {
+		return fmt.Errorf("must be cluster/namespace/serviceaccount, got %q", entry)
+	}
+	for _, part := range parts {
+		if part != "*" && strings.Contains(part, "*") {
+			return fmt.Errorf("%q: segments must be a name or \"*\", got %q", entry, part)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+// Package controller reconciles FederatedCluster and FederatedAccessPolicy resources
+// into the running config. Clusters and access policy from the config file are kept;
+// resources add to them and are removed from the running config when deleted.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+)
+
+// DefaultResyncInterval is how often all resources are reconciled, re-reading
+// referenced Secrets and refreshing JWKS health, in addition to watch events
+const DefaultResyncInterval = time.Minute
+
+// jwksCheckTimeout bounds the JWKS health check of a single cluster
+const jwksCheckTimeout = 10 * time.Second
+
+// JWKSChecker fetches a cluster's JWKS and returns the number of published keys
+type JWKSChecker interface {
+	CheckJWKS(ctx context.Context, clusterName string) (int, error)
+}
+
+// Controller watches FederatedCluster and FederatedAccessPolicy resources in a
+// namespace and applies them to a config.Config.
+type Controller struct {
+	config    *config.Config
+	credStore *credentials.Store
+	dynamic   dynamic.Interface
+	kube      kubernetes.Interface
+	namespace string
+	jwks      JWKSChecker
+	resync    time.Duration
+	now       func() time.Time
+
+	// the access policy from the config file is kept in the running config
+	fileAuthorizedClients []string
+	fileAdminClients      []string
+
+	// resources are read from the informers' caches, which watch events keep current
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	clusters informers.GenericInformer
+	policies informers.GenericInformer
+
+	// reconcileMu serializes reconciles, which own managed and secretVersions
+	reconcileMu sync.Mutex
+	// managed holds the cluster configs applied from FederatedCluster resources
+	managed map[string]config.ClusterConfig
+	// secretVersions holds the resource versions of the Secrets last loaded per cluster
+	secretVersions map[string]string
+
+	mu        sync.Mutex
+	listeners []func(cluster string)
+
+	trigger chan struct{}
+}
+
+// New creates a controller for resources in namespace. Bootstrap credentials from
+// referenced Secrets are loaded into credStore.
+func New(cfg *config.Config, credStore *credentials.Store, dyn dynamic.Interface, kube kubernetes.Interface, namespace string, jwks JWKSChecker) *Controller {
+	authorized, admin := cfg.AccessPolicy()
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dyn, 0, namespace, nil)
+
+	return &Controller{
+		config:                cfg,
+		credStore:             credStore,
+		dynamic:               dyn,
+		kube:                  kube,
+		namespace:             namespace,
+		jwks:                  jwks,
+		resync:                DefaultResyncInterval,
+		now:                   time.Now,
+		fileAuthorizedClients: authorized,
+		fileAdminClients:      admin,
+		factory:               factory,
+		clusters:              factory.ForResource(FederatedClusterResource),
+		policies:              factory.ForResource(FederatedAccessPolicyResource),
+		managed:               make(map[string]config.ClusterConfig),
+		secretVersions:        make(map[string]string),
+		trigger:               make(chan struct{}, 1),
+	}
+}
+
+// OnClusterChange registers a callback invoked after a cluster is added, changed or
+// removed. Callbacks run synchronously and must not block.
+func (c *Controller) OnClusterChange(fn func(cluster string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Run watches resources and reconciles them until ctx is cancelled
+func (c *Controller) Run(ctx context.Context) {
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { c.enqueue() },
+		DeleteFunc: func(any) { c.enqueue() },
+		UpdateFunc: func(oldObj, newObj any) {
+			// Ignore our own status updates
+			oldU, ok1 := oldObj.(*unstructured.Unstructured)
+			newU, ok2 := newObj.(*unstructured.Unstructured)
+			if ok1 && ok2 && oldU.GetGeneration() == newU.GetGeneration() {
+				return
+			}
+			c.enqueue()
+		},
+	}
+	for _, informer := range []informers.GenericInformer{c.clusters, c.policies} {
+		if _, err := informer.Informer().AddEventHandler(handler); err != nil {
+			log.Printf("Controller: watching resources failed: %v", err)
+		}
+	}
+	defer c.factory.Shutdown()
+	if !c.start(ctx) {
+		return
+	}
+
+	log.Printf("Controller: watching FederatedCluster and FederatedAccessPolicy resources in namespace %s", c.namespace)
+
+	ticker := time.NewTicker(c.resync)
+	defer ticker.Stop()
+	c.enqueue()
+	for {
+		select {
+		case <-c.trigger:
+			c.Reconcile(ctx)
+		case <-ticker.C:
+			c.Reconcile(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// start starts the informers and waits for their caches to fill, returning false if
+// ctx was cancelled first
+func (c *Controller) start(ctx context.Context) bool {
+	c.factory.Start(ctx.Done())
+	for gvr, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			log.Printf("Controller: syncing %s failed", gvr.Resource)
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Controller) enqueue() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Reconcile applies all resources to the running config and updates their status
+func (c *Controller) Reconcile(ctx context.Context) {
+	c.reconcileMu.Lock()
+	defer c.reconcileMu.Unlock()
+
+	if err := c.reconcileClusters(ctx); err != nil {
+		log.Printf("Controller: reconciling FederatedClusters failed: %v", err)
+	}
+	if err := c.reconcilePolicies(ctx); err != nil {
+		log.Printf("Controller: reconciling FederatedAccessPolicies failed: %v", err)
+	}
+}
+
+func (c *Controller) reconcileClusters(ctx context.Context) error {
+	objs, err := c.list(c.clusters)
+	if err != nil {
+		return err
+	}
+
+	type reconciled struct {
+		obj    *unstructured.Unstructured
+		name   string
+		status FederatedClusterStatus
+	}
+	var results []reconciled
+	seen := make(map[string]bool)
+	for _, obj := range objs {
+		var fc FederatedCluster
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &fc); err != nil {
+			log.Printf("Controller: decoding FederatedCluster %s failed: %v", obj.GetName(), err)
+			continue
+		}
+		name := fc.Name
+		seen[name] = true
+
+		status := fc.Status
+		status.Conditions = slices.Clone(status.Conditions)
+		status.ObservedGeneration = fc.Generation
+		if err := c.applyCluster(ctx, &fc); err != nil {
+			log.Printf("Controller: FederatedCluster %s not applied: %v", name, err)
+			setCondition(&status, ConditionReady, metav1.ConditionFalse, reasonFor(err), err.Error(), fc.Generation)
+		} else {
+			setCondition(&status, ConditionReady, metav1.ConditionTrue, "Applied", "cluster is configured", fc.Generation)
+		}
+		results = append(results, reconciled{obj: obj, name: name, status: status})
+	}
+
+	for name := range c.managed {
+		if !seen[name] {
+			log.Printf("Controller: removing cluster %s", name)
+			delete(c.managed, name)
+			delete(c.secretVersions, name)
+			c.config.DeleteCluster(name)
+			// A cluster recreated under the same name starts from its new bootstrap
+			// credentials, not tokens renewed for the old one
+			if c.credStore != nil {
+				if err := c.credStore.Delete(ctx, name); err != nil {
+					log.Printf("Controller: deleting credentials for cluster %s failed: %v", name, err)
+				}
+			}
+			c.notify(name)
+		}
+	}
+
+	var checked []string
+	for _, r := range results {
+		if _, ok := c.managed[r.name]; ok {
+			checked = append(checked, r.name)
+		}
+	}
+	health := c.checkJWKS(ctx, checked)
+	for _, r := range results {
+		if result, ok := health[r.name]; ok {
+			c.refreshHealth(r.name, &r.status, result)
+		}
+		c.updateStatus(ctx, FederatedClusterResource, r.obj, &r.status)
+	}
+	return nil
+}
+
+// list returns the resources in an informer's cache, sorted by name. They are shared
+// with the cache and must not be modified.
+func (c *Controller) list(informer informers.GenericInformer) ([]*unstructured.Unstructured, error) {
+	objs, err := informer.Lister().ByNamespace(c.namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			list = append(list, u)
+		}
+	}
+	slices.SortFunc(list, func(a, b *unstructured.Unstructured) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+	return list, nil
+}
+
+// clusterError classifies why a FederatedCluster could not be applied
+type clusterError struct {
+	reason string
+	err    error
+}
+
+func (e *clusterError) Error() string {
+	return e.err.Error()
+}
+
+func reasonFor(err error) string {
+	if ce, ok := err.(*clusterError); ok {
+		return ce.reason
+	}
+	return "Error"
+}
+
+// applyCluster loads the bootstrap credentials of a FederatedCluster and adds it to
+// the running config. A cluster that was applied before keeps its previous config
+// if its Secrets cannot be read.
+func (c *Controller) applyCluster(ctx context.Context, fc *FederatedCluster) error {
+	name := fc.Name
+	if _, managed := c.managed[name]; !managed {
+		if _, exists := c.config.Cluster(name); exists {
+			return &clusterError{"NameConflict", fmt.Errorf("cluster %s is already defined in the config file or by another source", name)}
+		}
+	}
+
+	clusterCfg := config.ClusterConfig{
+		Issuer:                fc.Spec.Issuer,
+		APIServer:             fc.Spec.APIServer,
+		ProxyURL:              fc.Spec.ProxyURL,
+		TLSServerName:         fc.Spec.TLSServerName,
+		TLSMinVersion:         fc.Spec.TLSMinVersion,
+		InsecureSkipTLSVerify: fc.Spec.InsecureSkipTLSVerify,
+	}
+
+	if clusterCfg.APIServer != "" {
+		if fc.Spec.TokenSecretRef == nil || fc.Spec.CASecretRef == nil {
+			return &clusterError{"InvalidSpec", fmt.Errorf("tokenSecretRef and caSecretRef are required with apiServer")}
+		}
+		if err := c.loadCredentials(ctx, name, fc.Spec.TokenSecretRef, fc.Spec.CASecretRef); err != nil {
+			return &clusterError{"SecretError", err}
+		}
+	}
+
+	if previous, ok := c.managed[name]; ok && reflect.DeepEqual(previous, clusterCfg) {
+		return nil
+	}
+	if err := c.config.SetCluster(name, clusterCfg); err != nil {
+		return &clusterError{"InvalidSpec", err}
+	}
+	log.Printf("Controller: applied cluster %s", name)
+	c.managed[name] = clusterCfg
+	c.notify(name)
+	return nil
+}
+
+// loadCredentials reads the bootstrap token and CA from Secrets. They are loaded into
+// the credential store on first sight unless renewed credentials are already stored,
+// and again whenever either Secret changes.
+func (c *Controller) loadCredentials(ctx context.Context, cluster string, tokenRef, caRef *SecretKeySelector) error {
+	token, tokenVersion, err := c.readSecret(ctx, tokenRef, DefaultTokenKey)
+	if err != nil {
+		return err
+	}
+	ca, caVersion, err := c.readSecret(ctx, caRef, DefaultCAKey)
+	if err != nil {
+		return err
+	}
+
+	version := tokenVersion + "/" + caVersion
+	previous, seen := c.secretVersions[cluster]
+	if seen && previous == version {
+		return nil
+	}
+	c.secretVersions[cluster] = version
+
+	if c.credStore == nil {
+		return fmt.Errorf("credential store is not enabled")
+	}
+	if !seen {
+		if !c.credStore.LoadBootstrap(cluster, strings.TrimSpace(string(token)), ca) {
+			log.Printf("Controller: skipping bootstrap for cluster %s: credentials already loaded from secret", cluster)
+		}
+		return nil
+	}
+	log.Printf("Controller: reloading bootstrap credentials for cluster %s", cluster)
+	c.credStore.Load(cluster, strings.TrimSpace(string(token)), ca)
+	return nil
+}
+
+func (c *Controller) readSecret(ctx context.Context, ref *SecretKeySelector, defaultKey string) ([]byte, string, error) {
+	key := ref.Key
+	if key == "" {
+		key = defaultKey
+	}
+	secret, err := c.kube.CoreV1().Secrets(c.namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("reading secret %s: %w", ref.Name, err)
+	}
+	data, ok := secret.Data[key]
+	if !ok || len(data) == 0 {
+		return nil, "", fmt.Errorf("secret %s has no key %s", ref.Name, key)
+	}
+	return data, secret.ResourceVersion, nil
+}
+
+// jwksHealth is the result of a cluster's JWKS check
+type jwksHealth struct {
+	keys int
+	err  error
+}
+
+// checkJWKS checks the JWKS of clusters concurrently, so a slow cluster doesn't
+// hold up the others
+func (c *Controller) checkJWKS(ctx context.Context, clusters []string) map[string]jwksHealth {
+	results := make([]jwksHealth, len(clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, jwksCheckTimeout)
+			defer cancel()
+			keys, err := c.jwks.CheckJWKS(checkCtx, cluster)
+			results[i] = jwksHealth{keys: keys, err: err}
+		}()
+	}
+	wg.Wait()
+
+	health := make(map[string]jwksHealth, len(clusters))
+	for i, cluster := range clusters {
+		health[cluster] = results[i]
+	}
+	return health
+}
+
+// refreshHealth records the cluster's JWKS health and the stored credential's expiry
+// in status. The check time is only updated if anything else changed.
+func (c *Controller) refreshHealth(cluster string, status *FederatedClusterStatus, health jwksHealth) {
+	previous := *status
+	previous.Conditions = slices.Clone(status.Conditions)
+
+	keys, err := health.keys, health.err
+	status.JWKSKeys = keys
+	if err != nil {
+		setCondition(status, ConditionJWKSHealthy, metav1.ConditionFalse, "FetchFailed", err.Error(), status.ObservedGeneration)
+	} else {
+		setCondition(status, ConditionJWKSHealthy, metav1.ConditionTrue, "KeysFetched", fmt.Sprintf("%d key(s) published", keys), status.ObservedGeneration)
+	}
+
+	status.CredentialExpiresAt = nil
+	if c.credStore != nil {
+		if creds, ok := c.credStore.Get(cluster); ok {
+			if exp, err := credentials.TokenExpiration(creds.Token); err == nil {
+				expiresAt := metav1.NewTime(exp)
+				status.CredentialExpiresAt = &expiresAt
+			}
+		}
+	}
+
+	if status.LastJWKSCheck == nil || !sameHealth(&previous, status) {
+		now := metav1.NewTime(c.now())
+		status.LastJWKSCheck = &now
+	}
+}
+
+// sameHealth reports whether two statuses have the same conditions, keys and
+// credential expiry
+func sameHealth(a, b *FederatedClusterStatus) bool {
+	return a.JWKSKeys == b.JWKSKeys && a.CredentialExpiresAt.Equal(b.CredentialExpiresAt) &&
+		slices.EqualFunc(a.Conditions, b.Conditions, func(x, y metav1.Condition) bool {
+			return x.Type == y.Type && x.Status == y.Status && x.Reason == y.Reason && x.Message == y.Message &&
+				x.ObservedGeneration == y.ObservedGeneration && x.LastTransitionTime.Equal(&y.LastTransitionTime)
+		})
+}
+
+func (c *Controller) reconcilePolicies(ctx context.Context) error {
+	objs, err := c.list(c.policies)
+	if err != nil {
+		return err
+	}
+
+	authorized := slices.Clone(c.fileAuthorizedClients)
+	admin := slices.Clone(c.fileAdminClients)
+	for _, obj := range objs {
+		var policy FederatedAccessPolicy
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &policy); err != nil {
+			log.Printf("Controller: decoding FederatedAccessPolicy %s failed: %v", obj.GetName(), err)
+			continue
+		}
+
+		status := policy.Status
+		status.Conditions = slices.Clone(status.Conditions)
+		status.ObservedGeneration = policy.Generation
+		invalid := append(invalidEntries(policy.Spec.AuthorizedClients), invalidEntries(policy.Spec.AdminClients)...)
+		if len(invalid) > 0 {
+			// Apply nothing from a policy with malformed entries rather than part of it
+			setCondition(&status, ConditionReady, metav1.ConditionFalse, "InvalidEntry",
+				"entries must be cluster/namespace/serviceaccount: "+strings.Join(invalid, ", "), policy.Generation)
+		} else {
+			authorized = append(authorized, policy.Spec.AuthorizedClients...)
+			admin = append(admin, policy.Spec.AdminClients...)
+			setCondition(&status, ConditionReady, metav1.ConditionTrue, "Applied", "policy is applied", policy.Generation)
+		}
+		c.updateStatus(ctx, FederatedAccessPolicyResource, obj, &status)
+	}
+
+	c.config.SetAccessPolicy(authorized, admin)
+	return nil
+}
+
+func invalidEntries(entries []string) []string {
+	var invalid []string
+	for _, entry := range entries {
+		if config.ValidateClientEntry(entry) != nil {
+			invalid = append(invalid, entry)
+		}
+	}
+	return invalid
+}
+
+// updateStatus writes status to the resource if it changed
+func (c *Controller) updateStatus(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, status any) {
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		log.Printf("Controller: encoding status of %s %s failed: %v", gvr.Resource, obj.GetName(), err)
+		return
+	}
+	if current, _, _ := unstructured.NestedMap(obj.Object, "status"); reflect.DeepEqual(current, data) {
+		return
+	}
+
+	obj = obj.DeepCopy()
+	obj.Object["status"] = data
+	if _, err := c.dynamic.Resource(gvr).Namespace(c.namespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		log.Printf("Controller: updating status of %s %s failed: %v", gvr.Resource, obj.GetName(), err)
+	}
+}
+
+func (c *Controller) notify(cluster string) {
+	c.mu.Lock()
+	listeners := slices.Clone(c.listeners)
+	c.mu.Unlock()
+	for _, fn := range listeners {
+		fn(cluster)
+	}
+}
+
+// conditionedStatus is implemented by the status of both resources
+type conditionedStatus interface {
+	conditions() *[]metav1.Condition
+}
+
+func (s *FederatedClusterStatus) conditions() *[]metav1.Condition      { return &s.Conditions }
+func (s *FederatedAccessPolicyStatus) conditions() *[]metav1.Condition { return &s.Conditions }
+
+func setCondition(status conditionedStatus, conditionType string, value metav1.ConditionStatus, reason, message string, generation int64) {
+	meta.SetStatusCondition(status.conditions(), metav1.Condition{
+		Type:               conditionType,
+		Status:             value,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
@@ -0,0 +1,745 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+)
+
+const testNamespace = "kube-federated-auth"
+
+type mockJWKS struct {
+	keys map[string]int
+}
+
+func (m *mockJWKS) CheckJWKS(ctx context.Context, cluster string) (int, error) {
+	if n, ok := m.keys[cluster]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("connection refused")
+}
+
+func makeJWT(exp time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	payload, _ := json.Marshal(map[string]any{"sub": "system:serviceaccount:kube-federated-auth:reader", "exp": exp.Unix()})
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+func toUnstructured(t *testing.T, obj any) *unstructured.Unstructured {
+	t.Helper()
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: data}
+}
+
+func federatedCluster(name string, spec FederatedClusterSpec) *FederatedCluster {
+	return &FederatedCluster{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: "FederatedCluster"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Generation: 1},
+		Spec:       spec,
+	}
+}
+
+func accessPolicy(name string, spec FederatedAccessPolicySpec) *FederatedAccessPolicy {
+	return &FederatedAccessPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: "FederatedAccessPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Generation: 1},
+		Spec:       spec,
+	}
+}
+
+func bootstrapSecret(name, token string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, ResourceVersion: "1"},
+		Data:       map[string][]byte{DefaultTokenKey: []byte(token + "\n"), DefaultCAKey: []byte("ca-pem")},
+	}
+}
+
+type testEnv struct {
+	config     *config.Config
+	store      *credentials.Store
+	dynamic    *dynamicfake.FakeDynamicClient
+	kube       *kubefake.Clientset
+	controller *Controller
+	changed    []string
+}
+
+func newTestEnv(t *testing.T, objects []any, secrets ...runtime.Object) *testEnv {
+	t.Helper()
+	cfg := &config.Config{
+		AuthorizedClients: []string{"cluster-a/default/client"},
+		Clusters: map[string]config.ClusterConfig{
+			"cluster-a": {Issuer: "https://kubernetes.default.svc.cluster.local"},
+		},
+	}
+	store, err := credentials.NewStore(testNamespace, "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dynObjects []runtime.Object
+	for _, obj := range objects {
+		dynObjects = append(dynObjects, toUnstructured(t, obj))
+	}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		FederatedClusterResource:      "FederatedClusterList",
+		FederatedAccessPolicyResource: "FederatedAccessPolicyList",
+	}, dynObjects...)
+	kube := kubefake.NewSimpleClientset(secrets...)
+
+	env := &testEnv{config: cfg, store: store, dynamic: dyn, kube: kube}
+	env.controller = New(cfg, store, dyn, kube, testNamespace, &mockJWKS{keys: map[string]int{"cluster-b": 2}})
+	env.controller.OnClusterChange(func(cluster string) { env.changed = append(env.changed, cluster) })
+	if !env.controller.start(t.Context()) {
+		t.Fatal("informer caches did not sync")
+	}
+	return env
+}
+
+// deleteResource deletes a resource and waits for the informer cache to drop it
+func (e *testEnv) deleteResource(t *testing.T, gvr schema.GroupVersionResource, name string) {
+	t.Helper()
+	if err := e.dynamic.Resource(gvr).Namespace(testNamespace).Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	informer := e.controller.clusters
+	if gvr == FederatedAccessPolicyResource {
+		informer = e.controller.policies
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := informer.Lister().ByNamespace(testNamespace).Get(name); err != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s %s still cached after deletion", gvr.Resource, name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// createCluster creates a FederatedCluster and waits for the informer cache to see it
+func (e *testEnv) createCluster(t *testing.T, fc *FederatedCluster) {
+	t.Helper()
+	if _, err := e.dynamic.Resource(FederatedClusterResource).Namespace(testNamespace).Create(context.Background(), toUnstructured(t, fc), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := e.controller.clusters.Lister().ByNamespace(testNamespace).Get(fc.Name); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("FederatedCluster %s not cached after creation", fc.Name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForStatus waits for the informer cache to see the status written to a cluster
+func (e *testEnv) waitForStatus(t *testing.T, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if obj, err := e.controller.clusters.Lister().ByNamespace(testNamespace).Get(name); err == nil {
+			if status, ok, _ := unstructured.NestedMap(obj.(*unstructured.Unstructured).Object, "status"); ok && len(status) > 0 {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status of %s not cached", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (e *testEnv) clusterStatus(t *testing.T, name string) FederatedClusterStatus {
+	t.Helper()
+	obj, err := e.dynamic.Resource(FederatedClusterResource).Namespace(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fc FederatedCluster
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &fc); err != nil {
+		t.Fatal(err)
+	}
+	return fc.Status
+}
+
+func TestReconcile_AppliesClusters(t *testing.T) {
+	exp := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	env := newTestEnv(t, []any{
+		federatedCluster("cluster-b", FederatedClusterSpec{
+			Issuer:         "https://kubernetes.default.svc.cluster.local",
+			APIServer:      "https://10.0.0.2:6443",
+			TokenSecretRef: &SecretKeySelector{Name: "cluster-b-bootstrap"},
+			CASecretRef:    &SecretKeySelector{Name: "cluster-b-bootstrap"},
+		}),
+		federatedCluster("cluster-a", FederatedClusterSpec{Issuer: "https://other.example.com"}),
+		federatedCluster("cluster-c", FederatedClusterSpec{Issuer: "https://c.example.com", APIServer: "https://10.0.0.3:6443"}),
+	}, bootstrapSecret("cluster-b-bootstrap", makeJWT(exp)))
+
+	env.controller.Reconcile(context.Background())
+
+	clusterB, ok := env.config.Cluster("cluster-b")
+	if !ok || clusterB.APIServer != "https://10.0.0.2:6443" {
+		t.Fatalf("cluster-b = %+v, want it applied from the resource", clusterB)
+	}
+	if creds, ok := env.store.Get("cluster-b"); !ok || creds.Token != makeJWT(exp) || string(creds.CACert) != "ca-pem" {
+		t.Errorf("stored credentials = %+v, want bootstrap token and CA from the Secret", creds)
+	}
+	if clusterA, _ := env.config.Cluster("cluster-a"); clusterA.Issuer != "https://kubernetes.default.svc.cluster.local" {
+		t.Error("expected the config file to take precedence for cluster-a")
+	}
+	if _, ok := env.config.Cluster("cluster-c"); ok {
+		t.Error("expected cluster-c without secret refs not to be applied")
+	}
+	if !slices.Equal(env.changed, []string{"cluster-b"}) {
+		t.Errorf("changed = %v, want [cluster-b]", env.changed)
+	}
+
+	status := env.clusterStatus(t, "cluster-b")
+	if !meta.IsStatusConditionTrue(status.Conditions, ConditionReady) || !meta.IsStatusConditionTrue(status.Conditions, ConditionJWKSHealthy) {
+		t.Errorf("conditions = %+v, want Ready and JWKSHealthy", status.Conditions)
+	}
+	if status.JWKSKeys != 2 || status.LastJWKSCheck == nil {
+		t.Errorf("JWKS status = %d keys at %v, want 2 keys", status.JWKSKeys, status.LastJWKSCheck)
+	}
+	if status.CredentialExpiresAt == nil || !status.CredentialExpiresAt.Time.Equal(exp) {
+		t.Errorf("credentialExpiresAt = %v, want %v", status.CredentialExpiresAt, exp)
+	}
+
+	for name, reason := range map[string]string{"cluster-a": "NameConflict", "cluster-c": "InvalidSpec"} {
+		cond := meta.FindStatusCondition(env.clusterStatus(t, name).Conditions, ConditionReady)
+		if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != reason {
+			t.Errorf("%s Ready condition = %+v, want False/%s", name, cond, reason)
+		}
+	}
+}
+
+func TestReconcile_JWKSUnhealthy(t *testing.T) {
+	env := newTestEnv(t, []any{
+		federatedCluster("cluster-d", FederatedClusterSpec{Issuer: "https://d.example.com"}),
+	})
+
+	env.controller.Reconcile(context.Background())
+
+	cond := meta.FindStatusCondition(env.clusterStatus(t, "cluster-d").Conditions, ConditionJWKSHealthy)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Message != "connection refused" {
+		t.Errorf("JWKSHealthy condition = %+v, want False with the fetch error", cond)
+	}
+}
+
+func TestReconcile_SkipsUnchangedStatus(t *testing.T) {
+	env := newTestEnv(t, []any{
+		federatedCluster("cluster-d", FederatedClusterSpec{Issuer: "https://d.example.com"}),
+	})
+	ctx := context.Background()
+	env.controller.Reconcile(ctx)
+	env.waitForStatus(t, "cluster-d")
+
+	// A resync that changes nothing but the check time writes nothing
+	env.dynamic.ClearActions()
+	env.controller.now = func() time.Time { return time.Now().Add(time.Hour) }
+	env.controller.Reconcile(ctx)
+	for _, action := range env.dynamic.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("unexpected %s of %s", action.GetVerb(), action.GetSubresource())
+		}
+	}
+}
+
+// concurrentJWKS answers a check once all of the expected checks are in progress
+type concurrentJWKS struct {
+	pending sync.WaitGroup
+	all     chan struct{}
+}
+
+func newConcurrentJWKS(n int) *concurrentJWKS {
+	j := &concurrentJWKS{all: make(chan struct{})}
+	j.pending.Add(n)
+	go func() {
+		j.pending.Wait()
+		close(j.all)
+	}()
+	return j
+}
+
+func (j *concurrentJWKS) CheckJWKS(ctx context.Context, cluster string) (int, error) {
+	j.pending.Done()
+	select {
+	case <-j.all:
+		return 1, nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("checked alone")
+	}
+}
+
+func TestReconcile_ChecksJWKSConcurrently(t *testing.T) {
+	env := newTestEnv(t, []any{
+		federatedCluster("cluster-d", FederatedClusterSpec{Issuer: "https://d.example.com"}),
+		federatedCluster("cluster-e", FederatedClusterSpec{Issuer: "https://e.example.com"}),
+	})
+	env.controller.jwks = newConcurrentJWKS(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env.controller.Reconcile(ctx)
+
+	for _, name := range []string{"cluster-d", "cluster-e"} {
+		if !meta.IsStatusConditionTrue(env.clusterStatus(t, name).Conditions, ConditionJWKSHealthy) {
+			t.Errorf("%s JWKS was not checked concurrently with the other cluster", name)
+		}
+	}
+}
+
+func TestReconcile_RemovesDeletedClusters(t *testing.T) {
+	env := newTestEnv(t, []any{
+		federatedCluster("cluster-d", FederatedClusterSpec{Issuer: "https://d.example.com"}),
+	})
+	ctx := context.Background()
+	env.controller.Reconcile(ctx)
+
+	env.deleteResource(t, FederatedClusterResource, "cluster-d")
+	env.controller.Reconcile(ctx)
+
+	if _, ok := env.config.Cluster("cluster-d"); ok {
+		t.Error("expected cluster-d to be removed")
+	}
+	if _, ok := env.config.Cluster("cluster-a"); !ok {
+		t.Error("expected cluster-a from the config file to be kept")
+	}
+	if !slices.Equal(env.changed, []string{"cluster-d", "cluster-d"}) {
+		t.Errorf("changed = %v, want cluster-d added then removed", env.changed)
+	}
+}
+
+func TestReconcile_RecreatedClusterUsesNewBootstrap(t *testing.T) {
+	spec := func(secret string) FederatedClusterSpec {
+		return FederatedClusterSpec{
+			Issuer:         "https://kubernetes.default.svc.cluster.local",
+			APIServer:      "https://10.0.0.2:6443",
+			TokenSecretRef: &SecretKeySelector{Name: secret},
+			CASecretRef:    &SecretKeySelector{Name: secret},
+		}
+	}
+	env := newTestEnv(t, []any{federatedCluster("cluster-b", spec("old-bootstrap"))},
+		bootstrapSecret("old-bootstrap", "old-token"), bootstrapSecret("new-bootstrap", "new-token"))
+	ctx := context.Background()
+	env.controller.Reconcile(ctx)
+	env.store.Load("cluster-b", "renewed-old-token", []byte("ca-pem"))
+
+	env.deleteResource(t, FederatedClusterResource, "cluster-b")
+	env.controller.Reconcile(ctx)
+	if _, ok := env.store.Get("cluster-b"); ok {
+		t.Error("expected credentials of the deleted cluster to be removed")
+	}
+
+	// A cluster recreated under the same name, e.g. for another API server, starts from
+	// its own bootstrap token
+	env.createCluster(t, federatedCluster("cluster-b", spec("new-bootstrap")))
+	env.controller.Reconcile(ctx)
+	if creds, ok := env.store.Get("cluster-b"); !ok || creds.Token != "new-token" {
+		t.Errorf("credentials = %+v, want the new bootstrap token", creds)
+	}
+}
+
+func TestReconcile_ReloadsChangedSecret(t *testing.T) {
+	env := newTestEnv(t, []any{
+		federatedCluster("cluster-b", FederatedClusterSpec{
+			Issuer:         "https://kubernetes.default.svc.cluster.local",
+			APIServer:      "https://10.0.0.2:6443",
+			TokenSecretRef: &SecretKeySelector{Name: "bootstrap", Key: "bootstrap-token"},
+			CASecretRef:    &SecretKeySelector{Name: "bootstrap"},
+		}),
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: testNamespace, ResourceVersion: "1"},
+		Data:       map[string][]byte{"bootstrap-token": []byte("first"), DefaultCAKey: []byte("ca-pem")},
+	})
+	ctx := context.Background()
+
+	// Renewed credentials persisted before startup win over the bootstrap Secret
+	env.store.Load("cluster-b", "renewed", []byte("ca-pem"))
+	env.controller.Reconcile(ctx)
+	if creds, _ := env.store.Get("cluster-b"); creds.Token != "renewed" {
+		t.Errorf("token = %q, want stored credentials to be kept", creds.Token)
+	}
+
+	// Unchanged Secret is not reloaded
+	env.controller.Reconcile(ctx)
+	if creds, _ := env.store.Get("cluster-b"); creds.Token != "renewed" {
+		t.Errorf("token = %q, want stored credentials to be kept", creds.Token)
+	}
+
+	// A rotated bootstrap Secret replaces the stored credentials
+	if _, err := env.kube.CoreV1().Secrets(testNamespace).Update(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: testNamespace, ResourceVersion: "2"},
+		Data:       map[string][]byte{"bootstrap-token": []byte("second"), DefaultCAKey: []byte("ca-pem")},
+	}, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.controller.Reconcile(ctx)
+	if creds, _ := env.store.Get("cluster-b"); creds.Token != "second" {
+		t.Errorf("token = %q, want rotated bootstrap token", creds.Token)
+	}
+}
+
+func TestReconcile_AccessPolicies(t *testing.T) {
+	env := newTestEnv(t, []any{
+		accessPolicy("ci", FederatedAccessPolicySpec{
+			AuthorizedClients: []string{"cluster-b/ci/*"},
+			AdminClients:      []string{"cluster-b/ops/operator"},
+		}),
+		accessPolicy("broken", FederatedAccessPolicySpec{
+			AuthorizedClients: []string{"cluster-b/everyone", "cluster-c/apps/reader"},
+		}),
+	})
+	ctx := context.Background()
+	env.controller.Reconcile(ctx)
+
+	if !env.config.IsAuthorizedClient("cluster-a", "default", "client") {
+		t.Error("expected authorized_clients from the config file to be kept")
+	}
+	if !env.config.IsAuthorizedClient("cluster-b", "ci", "runner") || !env.config.IsAdminClient("cluster-b", "ops", "operator") {
+		t.Error("expected entries from the ci policy to be applied")
+	}
+	if env.config.IsAuthorizedClient("cluster-c", "apps", "reader") {
+		t.Error("expected no entries from a policy with malformed entries")
+	}
+
+	obj, err := env.dynamic.Resource(FederatedAccessPolicyResource).Namespace(testNamespace).Get(ctx, "broken", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy FederatedAccessPolicy
+	runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &policy)
+	cond := meta.FindStatusCondition(policy.Status.Conditions, ConditionReady)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "InvalidEntry" {
+		t.Errorf("Ready condition = %+v, want False/InvalidEntry", cond)
+	}
+
+	// Deleting the policy revokes its entries
+	env.deleteResource(t, FederatedAccessPolicyResource, "ci")
+	env.controller.Reconcile(ctx)
+	if env.config.IsAuthorizedClient("cluster-b", "ci", "runner") {
+		t.Error("expected entries of the deleted policy to be revoked")
+	}
+}
+
+func kubeconfigSecret(name string, labels, annotations map[string]string, kubeconfig string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, ResourceVersion: "1", Labels: labels, Annotations: annotations},
+		Data:       map[string][]byte{capiKubeconfigKey: []byte(kubeconfig)},
+	}
+}
+
+type kubeconfigEnv struct {
+	config  *config.Config
+	store   *credentials.Store
+	kube    *kubefake.Clientset
+	source  *KubeconfigSource
+	derived []string // kubeconfigs passed to derive, suffixed with "+token" if a token was requested
+	changed []string
+}
+
+func newKubeconfigEnv(t *testing.T, settings *config.KubeconfigSecretsDiscovery, secrets ...runtime.Object) *kubeconfigEnv {
+	t.Helper()
+	cfg := &config.Config{
+		Clusters: map[string]config.ClusterConfig{
+			"cluster-a": {Issuer: "https://kubernetes.default.svc.cluster.local"},
+		},
+	}
+	store, err := credentials.NewStore(testNamespace, "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &kubeconfigEnv{config: cfg, store: store, kube: kubefake.NewSimpleClientset(secrets...)}
+	env.source, err = NewKubeconfigSource(cfg, store, env.kube, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test kubeconfig is the API server URL
+	env.source.derive = func(ctx context.Context, kubeconfig []byte, withToken bool) (*derivedCluster, error) {
+		host := string(kubeconfig)
+		if withToken {
+			env.derived = append(env.derived, host+"+token")
+		} else {
+			env.derived = append(env.derived, host)
+		}
+		if host == "https://unreachable" {
+			return nil, fmt.Errorf("connection refused")
+		}
+		derived := &derivedCluster{
+			cluster: config.ClusterConfig{Issuer: host + "/issuer", APIServer: host},
+			caCert:  []byte("ca-pem"),
+		}
+		if withToken {
+			derived.token = "token-for-" + host
+		}
+		return derived, nil
+	}
+	env.source.OnClusterChange(func(cluster string) { env.changed = append(env.changed, cluster) })
+	return env
+}
+
+func TestKubeconfigSource_ClusterAPI(t *testing.T) {
+	env := newKubeconfigEnv(t, &config.KubeconfigSecretsDiscovery{Namespace: testNamespace, ClusterAPI: true},
+		kubeconfigSecret("workload-1-kubeconfig", map[string]string{capiClusterNameLabel: "workload-1"}, nil, "https://workload-1"),
+		// Not the cluster's admin kubeconfig
+		kubeconfigSecret("workload-1-user-kubeconfig", map[string]string{capiClusterNameLabel: "workload-1"}, nil, "https://user"),
+		kubeconfigSecret("unlabeled-kubeconfig", nil, nil, "https://unlabeled"),
+	)
+	env.source.Reconcile(context.Background())
+
+	cluster, ok := env.config.Cluster("workload-1")
+	if !ok {
+		t.Fatalf("expected cluster workload-1, have %v", env.config.ClusterNames())
+	}
+	if cluster.Issuer != "https://workload-1/issuer" || cluster.APIServer != "https://workload-1" {
+		t.Errorf("unexpected cluster config: %+v", cluster)
+	}
+	if len(env.config.ClusterNames()) != 2 {
+		t.Errorf("expected only cluster-a and workload-1, have %v", env.config.ClusterNames())
+	}
+	creds, ok := env.store.Get("workload-1")
+	if !ok || creds.Token != "token-for-https://workload-1" || string(creds.CACert) != "ca-pem" {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+	if !slices.Equal(env.changed, []string{"workload-1"}) {
+		t.Errorf("expected change notification for workload-1, got %v", env.changed)
+	}
+
+	// An unchanged Secret is not derived again
+	env.source.Reconcile(context.Background())
+	if len(env.derived) != 1 {
+		t.Errorf("expected a single derivation, got %v", env.derived)
+	}
+}
+
+func TestKubeconfigSource_LabelSelector(t *testing.T) {
+	env := newKubeconfigEnv(t, &config.KubeconfigSecretsDiscovery{Namespace: testNamespace, LabelSelector: "federation=enabled"},
+		kubeconfigSecret("edge-kubeconfig", map[string]string{"federation": "enabled"}, nil, "https://edge"),
+		kubeconfigSecret("prod", map[string]string{"federation": "enabled"}, map[string]string{ClusterNameAnnotation: "prod-eu"}, "https://prod"),
+		kubeconfigSecret("other", map[string]string{"federation": "disabled"}, nil, "https://other"),
+		// Cluster API Secrets are not registered unless cluster_api is set
+		kubeconfigSecret("workload-1-kubeconfig", map[string]string{capiClusterNameLabel: "workload-1"}, nil, "https://workload-1"),
+	)
+	env.source.Reconcile(context.Background())
+
+	names := env.config.ClusterNames()
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"cluster-a", "edge", "prod-eu"}) {
+		t.Errorf("unexpected clusters: %v", names)
+	}
+}
+
+func TestKubeconfigSource_Conflicts(t *testing.T) {
+	env := newKubeconfigEnv(t, &config.KubeconfigSecretsDiscovery{Namespace: testNamespace, ClusterAPI: true},
+		kubeconfigSecret("cluster-a-kubeconfig", map[string]string{capiClusterNameLabel: "cluster-a"}, nil, "https://cluster-a"),
+	)
+	env.source.Reconcile(context.Background())
+
+	cluster, _ := env.config.Cluster("cluster-a")
+	if cluster.Issuer != "https://kubernetes.default.svc.cluster.local" {
+		t.Errorf("config file cluster was overwritten: %+v", cluster)
+	}
+	if len(env.derived) != 0 || len(env.changed) != 0 {
+		t.Errorf("expected conflicting Secret to be ignored, derived %v, changed %v", env.derived, env.changed)
+	}
+
+	// Deleting the Secret leaves the config file cluster alone
+	if err := env.kube.CoreV1().Secrets(testNamespace).Delete(context.Background(), "cluster-a-kubeconfig", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.source.Reconcile(context.Background())
+	if _, ok := env.config.Cluster("cluster-a"); !ok {
+		t.Error("config file cluster was removed")
+	}
+}
+
+func TestKubeconfigSource_UpdatesAndRemoves(t *testing.T) {
+	secret := kubeconfigSecret("workload-1-kubeconfig", map[string]string{capiClusterNameLabel: "workload-1"}, nil, "https://unreachable")
+	env := newKubeconfigEnv(t, &config.KubeconfigSecretsDiscovery{Namespace: testNamespace, ClusterAPI: true}, secret)
+	ctx := context.Background()
+
+	// Failed derivations are retried on the next reconcile
+	env.source.Reconcile(ctx)
+	if _, ok := env.config.Cluster("workload-1"); ok {
+		t.Fatal("expected no cluster while the API server is unreachable")
+	}
+	env.source.Reconcile(ctx)
+	if len(env.derived) != 2 {
+		t.Errorf("expected derivation to be retried, got %v", env.derived)
+	}
+
+	secret.Data[capiKubeconfigKey] = []byte("https://workload-1")
+	secret.ResourceVersion = "2"
+	if _, err := env.kube.CoreV1().Secrets(testNamespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.source.Reconcile(ctx)
+	if _, ok := env.config.Cluster("workload-1"); !ok {
+		t.Fatal("expected cluster workload-1 after the Secret was fixed")
+	}
+
+	if err := env.kube.CoreV1().Secrets(testNamespace).Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.source.Reconcile(ctx)
+	if _, ok := env.config.Cluster("workload-1"); ok {
+		t.Error("expected cluster workload-1 to be removed with its Secret")
+	}
+	if !slices.Equal(env.changed, []string{"workload-1", "workload-1"}) {
+		t.Errorf("expected add and remove notifications, got %v", env.changed)
+	}
+	if _, ok := env.store.Get("workload-1"); ok {
+		t.Error("expected credentials of workload-1 to be removed with its Secret")
+	}
+}
+
+func TestKubeconfigSource_DuplicateNames(t *testing.T) {
+	env := newKubeconfigEnv(t, &config.KubeconfigSecretsDiscovery{Namespace: testNamespace, ClusterAPI: true, LabelSelector: "federation=enabled"},
+		kubeconfigSecret("workload-1-kubeconfig", map[string]string{capiClusterNameLabel: "workload-1"}, nil, "https://workload-1"),
+	)
+	ctx := context.Background()
+	env.source.Reconcile(ctx)
+	if _, ok := env.config.Cluster("workload-1"); !ok {
+		t.Fatal("expected cluster workload-1")
+	}
+
+	// A second Secret naming the same cluster removes it rather than racing the first
+	impostor := kubeconfigSecret("impostor", map[string]string{"federation": "enabled"}, map[string]string{ClusterNameAnnotation: "workload-1"}, "https://impostor")
+	if _, err := env.kube.CoreV1().Secrets(testNamespace).Create(ctx, impostor, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.source.Reconcile(ctx)
+	if _, ok := env.config.Cluster("workload-1"); ok {
+		t.Error("expected cluster workload-1 to be removed while two Secrets name it")
+	}
+	if _, ok := env.store.Get("workload-1"); ok {
+		t.Error("expected credentials of workload-1 to be removed")
+	}
+	if slices.Contains(env.derived, "https://impostor+token") {
+		t.Errorf("expected the conflicting Secret not to be derived, got %v", env.derived)
+	}
+
+	// Resolving the conflict registers the cluster again
+	if err := env.kube.CoreV1().Secrets(testNamespace).Delete(ctx, impostor.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.source.Reconcile(ctx)
+	if cluster, ok := env.config.Cluster("workload-1"); !ok || cluster.APIServer != "https://workload-1" {
+		t.Errorf("expected cluster workload-1 from its Cluster API Secret, got %+v", cluster)
+	}
+}
+
+func TestKubeconfigSource_KeepsStoredCredentials(t *testing.T) {
+	env := newKubeconfigEnv(t, &config.KubeconfigSecretsDiscovery{Namespace: testNamespace, ClusterAPI: true},
+		kubeconfigSecret("workload-1-kubeconfig", map[string]string{capiClusterNameLabel: "workload-1"}, nil, "https://workload-1"),
+	)
+	env.store.Load("workload-1", "renewed-token", []byte("ca-pem"))
+	env.source.Reconcile(context.Background())
+
+	if !slices.Equal(env.derived, []string{"https://workload-1"}) {
+		t.Errorf("expected no bootstrap token request, got %v", env.derived)
+	}
+	if creds, _ := env.store.Get("workload-1"); creds.Token != "renewed-token" {
+		t.Errorf("stored credentials were replaced: %+v", creds)
+	}
+}
+
+func TestDeriveCluster(t *testing.T) {
+	var tokenRequestPath string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/.well-known/openid-configuration":
+			fmt.Fprint(w, `{"issuer":"https://oidc.workload-1.example.com"}`)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/token"):
+			tokenRequestPath = r.URL.Path
+			fmt.Fprint(w, `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenRequest","status":{"token":"bootstrap-token"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: workload-1
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: admin
+  user:
+    token: admin-token
+contexts:
+- name: admin@workload-1
+  context:
+    cluster: workload-1
+    user: admin
+current-context: admin@workload-1
+`, srv.URL, base64.StdEncoding.EncodeToString(caPEM))
+
+	derived, err := deriveCluster(context.Background(), []byte(kubeconfig), "kube-federated-auth", "kube-federated-auth", time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived.cluster.Issuer != "https://oidc.workload-1.example.com" || derived.cluster.APIServer != srv.URL {
+		t.Errorf("unexpected cluster config: %+v", derived.cluster)
+	}
+	if derived.token != "bootstrap-token" || string(derived.caCert) != string(caPEM) {
+		t.Errorf("unexpected credentials: token %q", derived.token)
+	}
+	if tokenRequestPath != "/api/v1/namespaces/kube-federated-auth/serviceaccounts/kube-federated-auth/token" {
+		t.Errorf("unexpected TokenRequest path %q", tokenRequestPath)
+	}
+
+	// Only embedded credentials are used; anything that runs commands or reads local
+	// files is rejected before connecting
+	rejected := map[string]string{
+		"CA file":        strings.Replace(kubeconfig, "certificate-authority-data", "certificate-authority", 1),
+		"token file":     strings.Replace(kubeconfig, "token: admin-token", "tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token", 1),
+		"client cert":    strings.Replace(kubeconfig, "token: admin-token", "client-certificate: /etc/tls.crt\n    client-key: /etc/tls.key", 1),
+		"exec":           strings.Replace(kubeconfig, "token: admin-token", "exec:\n      apiVersion: client.authentication.k8s.io/v1\n      command: sh", 1),
+		"auth provider":  strings.Replace(kubeconfig, "token: admin-token", "auth-provider:\n      name: oidc", 1),
+		"basic auth":     strings.Replace(kubeconfig, "token: admin-token", "username: admin\n    password: secret", 1),
+		"no credentials": strings.Replace(kubeconfig, "token: admin-token", "{}", 1),
+		"no context":     strings.Replace(kubeconfig, "current-context: admin@workload-1", "current-context: other", 1),
+	}
+	for name, kubeconfig := range rejected {
+		if _, err := deriveCluster(context.Background(), []byte(kubeconfig), "kube-federated-auth", "kube-federated-auth", time.Hour, false); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+	"github.com/rophy/kube-federated-auth/internal/oidc"
+)
+
+// Cluster API conventions for kubeconfig Secrets
+const (
+	capiClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+	capiKubeconfigSuffix = "-kubeconfig"
+	capiKubeconfigKey    = "value"
+)
+
+// ClusterNameAnnotation sets the cluster name of a label-selected kubeconfig Secret.
+// Without it the Secret name is used, minus a "-kubeconfig" suffix.
+const ClusterNameAnnotation = Group + "/cluster-name"
+
+// discoveryTimeout bounds deriving a cluster from its kubeconfig
+const discoveryTimeout = 30 * time.Second
+
+// derivedCluster is what a kubeconfig Secret contributes to the running config
+type derivedCluster struct {
+	cluster config.ClusterConfig
+	token   string // empty if not requested
+	caCert  []byte
+}
+
+// deriveFunc derives a cluster from a kubeconfig, requesting a bootstrap token only
+// if withToken is set
+type deriveFunc func(ctx context.Context, kubeconfig []byte, withToken bool) (*derivedCluster, error)
+
+// KubeconfigSource registers a cluster for each kubeconfig Secret selected by
+// discovery.kubeconfig_secrets. The issuer is discovered from the API server and a
+// bootstrap token is requested via TokenRequest with the kubeconfig's credentials,
+// so the server never keeps the kubeconfig itself.
+type KubeconfigSource struct {
+	config    *config.Config
+	credStore *credentials.Store
+	kube      kubernetes.Interface
+	settings  *config.KubeconfigSecretsDiscovery
+	selector  labels.Selector // nil unless label_selector is set
+	resync    time.Duration
+	derive    deriveFunc
+
+	mu sync.Mutex
+	// managed holds the clusters registered from Secrets
+	managed map[string]bool
+	// versions holds the Secret (namespace/name@resourceVersion) last processed per cluster
+	versions  map[string]string
+	listeners []func(cluster string)
+
+	trigger chan struct{}
+}
+
+// NewKubeconfigSource creates a kubeconfig Secret source. kube must be able to list
+// and watch Secrets in settings.Namespace.
+func NewKubeconfigSource(cfg *config.Config, credStore *credentials.Store, kube kubernetes.Interface, settings *config.KubeconfigSecretsDiscovery) (*KubeconfigSource, error) {
+	s := &KubeconfigSource{
+		config:    cfg,
+		credStore: credStore,
+		kube:      kube,
+		settings:  settings,
+		resync:    DefaultResyncInterval,
+		managed:   make(map[string]bool),
+		versions:  make(map[string]string),
+		trigger:   make(chan struct{}, 1),
+	}
+	if settings.LabelSelector != "" {
+		selector, err := labels.Parse(settings.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("parsing label_selector: %w", err)
+		}
+		s.selector = selector
+	}
+
+	namespace, name := settings.GetServiceAccount()
+	tokenDuration := cfg.GetRenewalTokenDuration()
+	s.derive = func(ctx context.Context, kubeconfig []byte, withToken bool) (*derivedCluster, error) {
+		return deriveCluster(ctx, kubeconfig, namespace, name, tokenDuration, withToken)
+	}
+	return s, nil
+}
+
+// OnClusterChange registers a callback invoked after a cluster is added, changed or
+// removed. Callbacks run synchronously and must not block.
+func (s *KubeconfigSource) OnClusterChange(fn func(cluster string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Run watches kubeconfig Secrets and registers clusters until ctx is cancelled
+func (s *KubeconfigSource) Run(ctx context.Context) {
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { s.enqueue() },
+		UpdateFunc: func(any, any) { s.enqueue() },
+		DeleteFunc: func(any) { s.enqueue() },
+	}
+	var factories []informers.SharedInformerFactory
+	for _, selector := range s.listSelectors() {
+		factory := informers.NewSharedInformerFactoryWithOptions(s.kube, 0,
+			informers.WithNamespace(s.settings.Namespace),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) { opts.LabelSelector = selector }))
+		if _, err := factory.Core().V1().Secrets().Informer().AddEventHandler(handler); err != nil {
+			log.Printf("Discovery: watching Secrets failed: %v", err)
+		}
+		factory.Start(ctx.Done())
+		factories = append(factories, factory)
+	}
+
+	log.Printf("Discovery: watching kubeconfig Secrets (namespace: %q, cluster_api: %v, label_selector: %q)",
+		s.settings.Namespace, s.settings.ClusterAPI, s.settings.LabelSelector)
+
+	ticker := time.NewTicker(s.resync)
+	defer ticker.Stop()
+	s.enqueue()
+	for {
+		select {
+		case <-s.trigger:
+			s.Reconcile(ctx)
+		case <-ticker.C:
+			s.Reconcile(ctx)
+		case <-ctx.Done():
+			for _, factory := range factories {
+				factory.Shutdown()
+			}
+			return
+		}
+	}
+}
+
+func (s *KubeconfigSource) enqueue() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// listSelectors returns the label selectors Secrets are listed with
+func (s *KubeconfigSource) listSelectors() []string {
+	var selectors []string
+	if s.settings.ClusterAPI {
+		selectors = append(selectors, capiClusterNameLabel)
+	}
+	if s.selector != nil {
+		selectors = append(selectors, s.selector.String())
+	}
+	return selectors
+}
+
+// Reconcile registers clusters for new or changed kubeconfig Secrets and removes
+// clusters, with their stored credentials, whose Secret was deleted
+func (s *KubeconfigSource) Reconcile(ctx context.Context) {
+	found, err := s.listKubeconfigs(ctx)
+	if err != nil {
+		log.Printf("Discovery: listing kubeconfig Secrets failed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, secret := range found {
+		version := secret.Namespace + "/" + secret.Name + "@" + secret.ResourceVersion
+		if s.versions[name] == version {
+			continue
+		}
+		if err := s.register(ctx, name, secret); err != nil {
+			// Retried on the next resync
+			log.Printf("Discovery: registering cluster %s from Secret %s/%s failed: %v", name, secret.Namespace, secret.Name, err)
+			continue
+		}
+		s.versions[name] = version
+	}
+
+	for name := range s.versions {
+		if _, ok := found[name]; ok {
+			continue
+		}
+		delete(s.versions, name)
+		if s.managed[name] {
+			log.Printf("Discovery: removing cluster %s, its kubeconfig Secret was deleted or conflicts with another", name)
+			delete(s.managed, name)
+			s.config.DeleteCluster(name)
+			if err := s.credStore.Delete(ctx, name); err != nil {
+				log.Printf("Discovery: deleting credentials for cluster %s failed: %v", name, err)
+			}
+			s.notify(name)
+		}
+	}
+}
+
+func (s *KubeconfigSource) register(ctx context.Context, name string, secret *corev1.Secret) error {
+	if !s.managed[name] {
+		if _, exists := s.config.Cluster(name); exists {
+			log.Printf("Discovery: ignoring Secret %s/%s, cluster %s is already defined in the config file or by another source", secret.Namespace, secret.Name, name)
+			return nil
+		}
+	}
+
+	// A cluster seen for the first time with stored credentials (renewed before a
+	// restart) keeps them; otherwise a fresh bootstrap token is requested
+	_, haveCreds := s.credStore.Get(name)
+	withToken := s.managed[name] || !haveCreds
+
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	derived, err := s.derive(ctx, kubeconfigData(secret), withToken)
+	if err != nil {
+		return err
+	}
+	if err := s.config.SetCluster(name, derived.cluster); err != nil {
+		return err
+	}
+	if derived.token != "" {
+		s.credStore.Load(name, derived.token, derived.caCert)
+	}
+
+	log.Printf("Discovery: registered cluster %s (issuer %s, api_server %s) from Secret %s/%s",
+		name, derived.cluster.Issuer, derived.cluster.APIServer, secret.Namespace, secret.Name)
+	s.managed[name] = true
+	s.notify(name)
+	return nil
+}
+
+// listKubeconfigs returns the selected kubeconfig Secrets by cluster name. A cluster
+// named by more than one Secret is left out, whatever order the Secrets are listed
+// in, so it is not registered (or is removed) until the conflict is resolved.
+func (s *KubeconfigSource) listKubeconfigs(ctx context.Context) (map[string]*corev1.Secret, error) {
+	// Secrets by namespace/name per cluster; a Secret may match both selectors
+	candidates := make(map[string]map[string]*corev1.Secret)
+	for _, selector := range s.listSelectors() {
+		list, err := s.kube.CoreV1().Secrets(s.settings.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			secret := &list.Items[i]
+			name, ok := s.clusterName(secret)
+			if !ok || len(kubeconfigData(secret)) == 0 {
+				continue
+			}
+			if candidates[name] == nil {
+				candidates[name] = make(map[string]*corev1.Secret)
+			}
+			candidates[name][secret.Namespace+"/"+secret.Name] = secret
+		}
+	}
+
+	found := make(map[string]*corev1.Secret, len(candidates))
+	for name, secrets := range candidates {
+		if len(secrets) > 1 {
+			log.Printf("Discovery: not registering cluster %s, it is named by several Secrets: %s",
+				name, strings.Join(slices.Sorted(maps.Keys(secrets)), ", "))
+			continue
+		}
+		for _, secret := range secrets {
+			found[name] = secret
+		}
+	}
+	return found, nil
+}
+
+// clusterName returns the cluster a Secret registers, and false if the Secret is not
+// a kubeconfig Secret
+func (s *KubeconfigSource) clusterName(secret *corev1.Secret) (string, bool) {
+	if capiName, ok := secret.Labels[capiClusterNameLabel]; ok && s.settings.ClusterAPI && secret.Name == capiName+capiKubeconfigSuffix {
+		return capiName, true
+	}
+	if s.selector != nil && s.selector.Matches(labels.Set(secret.Labels)) {
+		if name := secret.Annotations[ClusterNameAnnotation]; name != "" {
+			return name, true
+		}
+		return strings.TrimSuffix(secret.Name, capiKubeconfigSuffix), true
+	}
+	return "", false
+}
+
+// kubeconfigData returns the kubeconfig of a Secret: key "value" (Cluster API) or "kubeconfig"
+func kubeconfigData(secret *corev1.Secret) []byte {
+	if data := secret.Data[capiKubeconfigKey]; len(data) > 0 {
+		return data
+	}
+	return secret.Data["kubeconfig"]
+}
+
+func (s *KubeconfigSource) notify(cluster string) {
+	for _, fn := range s.listeners {
+		fn(cluster)
+	}
+}
+
+// deriveCluster connects to the API server of a kubeconfig's current context,
+// discovers its ServiceAccount issuer and, if withToken is set, requests a token for
+// the given ServiceAccount to bootstrap credential renewal
+func deriveCluster(ctx context.Context, kubeconfig []byte, namespace, serviceAccount string, tokenDuration time.Duration, withToken bool) (*derivedCluster, error) {
+	restConfig, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP client: %w", err)
+	}
+	issuer, err := oidc.DiscoverIssuer(ctx, httpClient, restConfig.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := &derivedCluster{
+		cluster: config.ClusterConfig{
+			Issuer:        issuer,
+			APIServer:     restConfig.Host,
+			TLSServerName: restConfig.ServerName,
+		},
+		caCert: restConfig.CAData,
+	}
+	if !withToken {
+		return derived, nil
+	}
+
+	client, err := kubernetes.NewForConfigAndClient(restConfig, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+	result, err := credentials.CreateToken(ctx, client, namespace, serviceAccount, nil, tokenDuration)
+	if err != nil {
+		return nil, fmt.Errorf("requesting bootstrap token for %s/%s: %w", namespace, serviceAccount, err)
+	}
+	derived.token = result.Status.Token
+	return derived, nil
+}
+
+// restConfigFromKubeconfig builds a client config from the current context of a
+// kubeconfig. Kubeconfig Secrets may be written by anyone allowed to create Secrets
+// in the watched namespace, so only embedded credentials are accepted: exec plugins,
+// auth providers and file references would run commands or read local files in the
+// server pod.
+func restConfigFromKubeconfig(kubeconfig []byte) (*rest.Config, error) {
+	cfg, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	kubeContext, ok := cfg.Contexts[cfg.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig current-context %q not found", cfg.CurrentContext)
+	}
+	cluster, ok := cfg.Clusters[kubeContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig cluster %q not found", kubeContext.Cluster)
+	}
+	authInfo, ok := cfg.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig user %q not found", kubeContext.AuthInfo)
+	}
+
+	switch {
+	case cluster.CertificateAuthority != "":
+		return nil, fmt.Errorf("kubeconfig must embed certificate-authority-data, not reference a file")
+	case len(cluster.CertificateAuthorityData) == 0:
+		return nil, fmt.Errorf("kubeconfig must embed certificate-authority-data")
+	case authInfo.Exec != nil:
+		return nil, fmt.Errorf("kubeconfig exec credential plugins are not supported")
+	case authInfo.AuthProvider != nil:
+		return nil, fmt.Errorf("kubeconfig auth providers are not supported")
+	case authInfo.TokenFile != "" || authInfo.ClientCertificate != "" || authInfo.ClientKey != "":
+		return nil, fmt.Errorf("kubeconfig must embed credentials, not reference files")
+	case authInfo.Username != "" || authInfo.Password != "":
+		return nil, fmt.Errorf("kubeconfig basic authentication is not supported")
+	case authInfo.Token == "" && (len(authInfo.ClientCertificateData) == 0 || len(authInfo.ClientKeyData) == 0):
+		return nil, fmt.Errorf("kubeconfig must embed a token or client-certificate-data and client-key-data")
+	}
+
+	// Only the validated entries are kept, so nothing else in the kubeconfig is read
+	validated := clientcmdapi.NewConfig()
+	validated.Clusters["cluster"] = cluster
+	validated.AuthInfos["user"] = authInfo
+	validated.Contexts["context"] = &clientcmdapi.Context{Cluster: "cluster", AuthInfo: "user"}
+	validated.CurrentContext = "context"
+	restConfig, err := clientcmd.NewDefaultClientConfig(*validated, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	return restConfig, nil
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// API group and version of the FederatedCluster and FederatedAccessPolicy CRDs
+const (
+	Group   = "kube-federated-auth.io"
+	Version = "v1alpha1"
+)
+
+var (
+	FederatedClusterResource      = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "federatedclusters"}
+	FederatedAccessPolicyResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "federatedaccesspolicies"}
+)
+
+// Default keys read from the Secrets referenced by a FederatedCluster
+const (
+	DefaultTokenKey = "token"
+	DefaultCAKey    = "ca.crt"
+)
+
+// Condition types reported in status
+const (
+	ConditionReady       = "Ready"
+	ConditionJWKSHealthy = "JWKSHealthy"
+)
+
+// SecretKeySelector references a key of a Secret in the server's namespace
+type SecretKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// FederatedCluster is a cluster whose ServiceAccount tokens are federated. It is the
+// CRD equivalent of an entry under clusters in the config file.
+type FederatedCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FederatedClusterSpec   `json:"spec"`
+	Status FederatedClusterStatus `json:"status,omitempty"`
+}
+
+type FederatedClusterSpec struct {
+	Issuer    string `json:"issuer"`
+	APIServer string `json:"apiServer,omitempty"`
+
+	// TokenSecretRef and CASecretRef hold the bootstrap token and CA certificate used
+	// with apiServer (defaults: keys "token" and "ca.crt")
+	TokenSecretRef *SecretKeySelector `json:"tokenSecretRef,omitempty"`
+	CASecretRef    *SecretKeySelector `json:"caSecretRef,omitempty"`
+
+	ProxyURL              string `json:"proxyURL,omitempty"`
+	TLSServerName         string `json:"tlsServerName,omitempty"`
+	TLSMinVersion         string `json:"tlsMinVersion,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+type FederatedClusterStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+
+	// JWKSKeys is the number of keys published by the cluster at LastJWKSCheck
+	JWKSKeys int `json:"jwksKeys,omitempty"`
+	// LastJWKSCheck is the time of the last check that changed the status. Checks
+	// that change nothing aren't written, so resyncs don't update every resource.
+	LastJWKSCheck *metav1.Time `json:"lastJWKSCheck,omitempty"`
+
+	// CredentialExpiresAt is the expiry of the stored API server token
+	CredentialExpiresAt *metav1.Time `json:"credentialExpiresAt,omitempty"`
+}
+
+// FederatedAccessPolicy grants callers access to the TokenReview and admin endpoints.
+// Entries of all policies are added to authorized_clients and admin_clients from the
+// config file.
+type FederatedAccessPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FederatedAccessPolicySpec   `json:"spec"`
+	Status FederatedAccessPolicyStatus `json:"status,omitempty"`
+}
+
+type FederatedAccessPolicySpec struct {
+	// AuthorizedClients and AdminClients use the config file format:
+	// cluster/namespace/serviceaccount with "*" wildcards
+	AuthorizedClients []string `json:"authorizedClients,omitempty"`
+	AdminClients      []string `json:"adminClients,omitempty"`
+}
+
+type FederatedAccessPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
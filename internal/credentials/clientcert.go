@@ -0,0 +1,29 @@
+package credentials
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ClientCertExpiration returns the expiration time of the first certificate in a PEM file
+func ClientCertExpiration(certPath string) (time.Time, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading client cert: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("failed to decode client certificate PEM")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing client certificate: %w", err)
+	}
+
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,52 @@
+package credentials
+
+import (
+	"time"
+
+	"github.com/rophy/kube-federated-auth/internal/ringbuffer"
+)
+
+// maxRenewalHistory is the number of renewal attempts retained in memory
+const maxRenewalHistory = 100
+
+// Renewal triggers recorded in RenewalAttempt
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+// RenewalAttempt records the outcome of a single credential renewal attempt
+type RenewalAttempt struct {
+	Cluster string    `json:"cluster"`
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// renewalHistory is a fixed-size ring buffer of renewal attempts
+type renewalHistory struct {
+	attempts *ringbuffer.Buffer[RenewalAttempt]
+}
+
+func newRenewalHistory(size int) *renewalHistory {
+	return &renewalHistory{attempts: ringbuffer.New[RenewalAttempt](size)}
+}
+
+func (h *renewalHistory) record(cluster, trigger string, err error) {
+	attempt := RenewalAttempt{
+		Cluster: cluster,
+		Time:    time.Now(),
+		Trigger: trigger,
+		Success: err == nil,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	h.attempts.Add(attempt)
+}
+
+// list returns up to limit attempts, newest first. A limit <= 0 returns all.
+func (h *renewalHistory) list(limit int) []RenewalAttempt {
+	return h.attempts.List(limit)
+}
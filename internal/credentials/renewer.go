@@ -2,21 +2,19 @@ package credentials
 
 import (
 	"context"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"log"
-	"os"
+	"math/rand/v2"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
-	authv1 "k8s.io/api/authentication/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"github.com/rophy/kube-federated-auth/internal/config"
 )
@@ -26,54 +24,167 @@ type VerifierInvalidator interface {
 	InvalidateVerifier(clusterName string)
 }
 
+// ClientBuilder creates Kubernetes clients for remote clusters from explicit credentials
+type ClientBuilder interface {
+	ClientFor(cfg config.ClusterConfig, creds *Credentials) (kubernetes.Interface, error)
+}
+
 // clientFactory creates a Kubernetes client for a remote cluster.
 type clientFactory func(cfg config.ClusterConfig, creds *Credentials) (kubernetes.Interface, error)
 
+// randDuration returns a random duration in [0, n).
+type randDuration func(n time.Duration) time.Duration
+
 // Renewer handles automatic credential renewal for remote clusters
 type Renewer struct {
 	config        *config.Config
 	credStore     *Store
 	verifier      VerifierInvalidator
 	clientFactory clientFactory
+	randDuration  randDuration
+
+	// locks serializes each cluster's renewals so on-demand requests don't race its
+	// renewal loop, without a slow API server holding up other clusters
+	locks   clusterLocks
+	history *renewalHistory
+
+	// loopsMu guards the renewal loops started by Start and Sync
+	loopsMu sync.Mutex
+	ctx     context.Context
+	loops   map[string]*renewalLoop
 }
 
 // NewRenewer creates a new credential renewer
-func NewRenewer(cfg *config.Config, store *Store, verifier VerifierInvalidator) *Renewer {
+func NewRenewer(cfg *config.Config, store *Store, verifier VerifierInvalidator, clients ClientBuilder) *Renewer {
 	r := &Renewer{
-		config:    cfg,
-		credStore: store,
-		verifier:  verifier,
+		config:       cfg,
+		credStore:    store,
+		verifier:     verifier,
+		randDuration: defaultRandDuration,
+		history:      newRenewalHistory(maxRenewalHistory),
+	}
+	if clients != nil {
+		r.clientFactory = clients.ClientFor
+	} else {
+		r.clientFactory = func(config.ClusterConfig, *Credentials) (kubernetes.Interface, error) {
+			return nil, fmt.Errorf("no client builder configured")
+		}
 	}
-	r.clientFactory = r.createClient
 	return r
 }
 
+func defaultRandDuration(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return rand.N(n)
+}
+
 // Start begins the renewal loops for all remote clusters
 func (r *Renewer) Start(ctx context.Context) {
-	interval := r.config.GetRenewalInterval()
-	for clusterName, clusterCfg := range r.config.Clusters {
-		if clusterCfg.IsRemote() {
-			go r.renewLoop(ctx, clusterName, clusterCfg, interval)
+	r.loopsMu.Lock()
+	r.ctx = ctx
+	r.loops = make(map[string]*renewalLoop)
+	r.loopsMu.Unlock()
+
+	// Wake a cluster's loop whenever its credentials change so it can reschedule
+	// from the new token's expiry
+	r.credStore.OnChange(func(cluster string) {
+		r.loopsMu.Lock()
+		loop, ok := r.loops[cluster]
+		r.loopsMu.Unlock()
+		if ok && loop.wake != nil {
+			select {
+			case loop.wake <- struct{}{}:
+			default:
+			}
 		}
-	}
+	})
+
+	r.Sync()
 }
 
-func (r *Renewer) renewLoop(ctx context.Context, cluster string, cfg config.ClusterConfig, interval time.Duration) {
-	log.Printf("Starting credential renewal loop for cluster %s (interval: %s)", cluster, interval)
+// renewalLoop tracks the renewal loop of a cluster. cancel and wake are nil for
+// remote clusters whose credentials are not renewed.
+type renewalLoop struct {
+	cfg    config.ClusterConfig
+	cancel context.CancelFunc
+	wake   chan struct{}
+}
 
-	// Initial renewal
-	if err := r.renew(ctx, cluster, cfg); err != nil {
-		log.Printf("Initial credential renewal failed for cluster %s: %v", cluster, err)
+// Sync reconciles the running renewal loops with the clusters currently configured:
+// loops are started for remote clusters added since Start and stopped for clusters
+// that were removed or changed. It does nothing before Start.
+func (r *Renewer) Sync() {
+	r.loopsMu.Lock()
+	defer r.loopsMu.Unlock()
+	if r.ctx == nil {
+		return
 	}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	clusters := r.config.ClusterConfigs()
+	for name, loop := range r.loops {
+		if cfg, ok := clusters[name]; !ok || !reflect.DeepEqual(cfg, loop.cfg) {
+			if loop.cancel != nil {
+				loop.cancel()
+			}
+			delete(r.loops, name)
+		}
+	}
+
+	interval := r.config.GetRenewalInterval()
+	for clusterName, clusterCfg := range clusters {
+		if _, ok := r.loops[clusterName]; ok || !clusterCfg.IsRemote() {
+			continue
+		}
+		loop := &renewalLoop{cfg: clusterCfg}
+		r.loops[clusterName] = loop
+		if clusterCfg.UsesExternalAuth() {
+			log.Printf("Skipping credential renewal for cluster %s: credentials managed by kubeconfig or exec plugin", clusterName)
+			continue
+		}
+		if !clusterCfg.RenewsToken() {
+			log.Printf("Skipping credential renewal for cluster %s: authenticating with client certificate", clusterName)
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(r.ctx)
+		loop.cancel = cancel
+		loop.wake = make(chan struct{}, 1)
+		go r.renewLoop(ctx, clusterName, clusterCfg, interval, loop.wake)
+	}
+}
 
+// renewLoop renews a cluster's credentials at the time derived from the current
+// token's expiry (see renewalTime), waking at least every interval as a safety net.
+// A signal on wake reschedules from the latest stored token.
+func (r *Renewer) renewLoop(ctx context.Context, cluster string, cfg config.ClusterConfig, interval time.Duration, wake <-chan struct{}) {
+	// Spread initial renewals so replicas and clusters don't hit the API servers in lockstep
+	startupDelay := r.randDuration(r.config.GetRenewalStartupJitter())
+	log.Printf("Starting credential renewal loop for cluster %s (max interval: %s, initial delay: %s)",
+		cluster, interval, startupDelay.Round(time.Millisecond))
+
+	timer := time.NewTimer(startupDelay)
+	defer timer.Stop()
+
+	failures := 0
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			if err := r.renew(ctx, cluster, cfg); err != nil {
-				log.Printf("Credential renewal failed for cluster %s: %v", cluster, err)
+				failures++
+				delay := r.retryDelay(cluster, failures)
+				log.Printf("Credential renewal failed for cluster %s (attempt %d, retrying in %s): %v",
+					cluster, failures, delay.Round(time.Millisecond), err)
+				timer.Reset(delay)
+				continue
+			}
+			failures = 0
+			timer.Reset(r.nextRenewal(cluster, interval))
+		case <-wake:
+			// Keep an active retry schedule; otherwise follow the new token
+			if failures == 0 {
+				timer.Reset(r.nextRenewal(cluster, interval))
 			}
 		case <-ctx.Done():
 			log.Printf("Stopping credential renewal loop for cluster %s", cluster)
@@ -82,39 +193,183 @@ func (r *Renewer) renewLoop(ctx context.Context, cluster string, cfg config.Clus
 	}
 }
 
-func (r *Renewer) renew(ctx context.Context, cluster string, cfg config.ClusterConfig) error {
-	// Get current credentials (bootstrap or previously renewed)
-	creds, ok := r.credStore.Get(cluster)
-	if !ok {
-		// Try to load bootstrap credentials from files
-		if cfg.TokenPath != "" && cfg.CACert != "" {
-			if err := r.credStore.LoadFromFiles(cluster, cfg.TokenPath, cfg.CACert); err != nil {
-				return fmt.Errorf("loading bootstrap credentials: %w", err)
+// nextRenewal returns how long to sleep until the stored token for the cluster is due
+// for renewal, bounded by interval. It's shortened by up to 10% so loops started
+// together drift apart over time, and never shorter than retry_backoff so a token that
+// is due immediately after renewal cannot cause a tight loop.
+func (r *Renewer) nextRenewal(cluster string, interval time.Duration) time.Duration {
+	delay := interval
+	if creds, ok := r.credStore.Get(cluster); ok {
+		if issuedAt, exp, err := getTokenTimes(creds.Token); err == nil {
+			if until := time.Until(r.renewalTime(issuedAt, exp)); until < delay {
+				delay = until
 			}
-			creds, _ = r.credStore.Get(cluster)
-		} else {
-			return fmt.Errorf("no credentials available for cluster %s", cluster)
 		}
 	}
+	delay -= r.randDuration(delay / 10)
+	if base := r.config.GetRenewalRetryBackoff(); delay < base {
+		delay = base
+	}
+	return delay
+}
+
+// renewalTime returns when a token should be renewed: renew_before ahead of its expiry,
+// or at 80% of its lifetime when renew_before is not shorter than the lifetime
+// (e.g. the API server capped the requested token duration).
+func (r *Renewer) renewalTime(issuedAt, exp time.Time) time.Time {
+	renewBefore := r.config.GetRenewalRenewBefore()
+	if !issuedAt.IsZero() {
+		if lifetime := exp.Sub(issuedAt); lifetime > 0 && renewBefore >= lifetime {
+			return issuedAt.Add(lifetime * 4 / 5)
+		}
+	}
+	return exp.Add(-renewBefore)
+}
+
+// retryDelay computes how long to wait before retrying after the given number of
+// consecutive failures. The delay grows exponentially from retry_backoff up to
+// max_backoff (never beyond the regular interval), and is capped at a quarter of the
+// current token's remaining lifetime so retries become more aggressive as expiry nears.
+// Equal jitter is applied to the result.
+func (r *Renewer) retryDelay(cluster string, failures int) time.Duration {
+	base := r.config.GetRenewalRetryBackoff()
+	maxDelay := r.config.GetRenewalMaxBackoff()
+	if interval := r.config.GetRenewalInterval(); interval < maxDelay {
+		maxDelay = interval
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if creds, ok := r.credStore.Get(cluster); ok {
+		if exp, err := TokenExpiration(creds.Token); err == nil {
+			if limit := time.Until(exp) / 4; delay > limit {
+				delay = limit
+			}
+		}
+	}
+	if delay < base {
+		delay = base
+	}
+
+	half := delay / 2
+	return half + r.randDuration(delay-half)
+}
+
+func (r *Renewer) renew(ctx context.Context, cluster string, cfg config.ClusterConfig) error {
+	defer r.locks.lock(cluster)()
+
+	creds, err := r.currentCredentials(cluster, cfg)
+	if err != nil {
+		r.history.record(cluster, TriggerScheduled, err)
+		return err
+	}
 
 	// Check CA certificate expiration
 	checkCACertExpiration(cluster, creds.CACert)
 
 	// Check if token needs renewal based on expiration
-	renewBefore := r.config.GetRenewalRenewBefore()
-	if exp, err := getTokenExpiration(creds.Token); err == nil {
+	if issuedAt, exp, err := getTokenTimes(creds.Token); err == nil {
 		timeUntilExpiry := time.Until(exp)
-		if timeUntilExpiry > renewBefore {
-			log.Printf("Skipping renewal for cluster %s: token expires in %s (threshold: %s)",
-				cluster, timeUntilExpiry.Round(time.Minute), renewBefore)
+		renewAt := r.renewalTime(issuedAt, exp)
+		if time.Now().Before(renewAt) {
+			log.Printf("Skipping renewal for cluster %s: token expires in %s (renewal due in %s)",
+				cluster, timeUntilExpiry.Round(time.Minute), time.Until(renewAt).Round(time.Minute))
 			return nil
 		}
-		log.Printf("Renewing credentials for cluster %s: token expires in %s (threshold: %s)",
-			cluster, timeUntilExpiry.Round(time.Minute), renewBefore)
+		log.Printf("Renewing credentials for cluster %s: token expires in %s",
+			cluster, timeUntilExpiry.Round(time.Minute))
 	} else {
 		log.Printf("Renewing credentials for cluster %s: could not determine expiration (%v)", cluster, err)
 	}
 
+	err = r.renewCredentials(ctx, cluster, cfg, creds)
+	r.history.record(cluster, TriggerScheduled, err)
+	return err
+}
+
+// RenewNow renews credentials for a remote cluster immediately, regardless of
+// when the current token is due for renewal.
+func (r *Renewer) RenewNow(ctx context.Context, cluster string) error {
+	cfg, ok := r.config.Cluster(cluster)
+	if !ok {
+		return fmt.Errorf("cluster not found: %s", cluster)
+	}
+	if !cfg.IsRemote() {
+		return fmt.Errorf("cluster %s is not a remote cluster", cluster)
+	}
+	if cfg.UsesExternalAuth() {
+		return fmt.Errorf("cluster %s credentials are managed by kubeconfig or exec plugin", cluster)
+	}
+	if !cfg.RenewsToken() {
+		return fmt.Errorf("cluster %s authenticates with a client certificate and has no token to renew", cluster)
+	}
+
+	defer r.locks.lock(cluster)()
+
+	log.Printf("Renewing credentials for cluster %s on demand", cluster)
+
+	creds, err := r.currentCredentials(cluster, cfg)
+	if err == nil {
+		err = r.renewCredentials(ctx, cluster, cfg, creds)
+	}
+	r.history.record(cluster, TriggerManual, err)
+	return err
+}
+
+// clusterLocks is a mutex per cluster
+type clusterLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock locks the cluster's mutex, returning the function that unlocks it
+func (l *clusterLocks) lock(cluster string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+	m, ok := l.locks[cluster]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[cluster] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// History returns up to limit of the most recent renewal attempts, newest first.
+func (r *Renewer) History(limit int) []RenewalAttempt {
+	return r.history.list(limit)
+}
+
+// currentCredentials returns the stored credentials for a cluster, loading bootstrap
+// credentials from files if none are stored yet.
+func (r *Renewer) currentCredentials(cluster string, cfg config.ClusterConfig) (*Credentials, error) {
+	if creds, ok := r.credStore.Get(cluster); ok {
+		return creds, nil
+	}
+
+	if cfg.TokenPath == "" || cfg.CACert == "" {
+		return nil, fmt.Errorf("no credentials available for cluster %s", cluster)
+	}
+	if err := r.credStore.LoadFromFiles(cluster, cfg.TokenPath, cfg.CACert); err != nil {
+		return nil, fmt.Errorf("loading bootstrap credentials: %w", err)
+	}
+	creds, _ := r.credStore.Get(cluster)
+	return creds, nil
+}
+
+// renewCredentials requests a new token using creds, falling back to the bootstrap
+// token file if that fails.
+func (r *Renewer) renewCredentials(ctx context.Context, cluster string, cfg config.ClusterConfig, creds *Credentials) error {
 	// Try renewal with current credentials
 	if err := r.requestNewToken(ctx, cluster, cfg, creds); err != nil {
 		// If renewal failed and bootstrap credentials are available, retry with bootstrap
@@ -153,20 +408,7 @@ func (r *Renewer) requestNewToken(ctx context.Context, cluster string, cfg confi
 	}
 
 	// Call TokenRequest API
-	tokenDuration := r.config.GetRenewalTokenDuration()
-	expirationSeconds := int64(tokenDuration.Seconds())
-	tokenRequest := &authv1.TokenRequest{
-		Spec: authv1.TokenRequestSpec{
-			ExpirationSeconds: &expirationSeconds,
-		},
-	}
-
-	token, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(
-		ctx,
-		serviceAccount,
-		tokenRequest,
-		metav1.CreateOptions{},
-	)
+	token, err := CreateToken(ctx, client, namespace, serviceAccount, nil, r.config.GetRenewalTokenDuration())
 	if err != nil {
 		return err
 	}
@@ -221,30 +463,41 @@ func parseServiceAccountFromToken(token string) (namespace, serviceAccount strin
 	return subParts[2], subParts[3], nil
 }
 
-// getTokenExpiration extracts the expiration time from a JWT token
-func getTokenExpiration(token string) (time.Time, error) {
+// TokenExpiration extracts the expiration time from a JWT token without verifying it
+func TokenExpiration(token string) (time.Time, error) {
+	_, exp, err := getTokenTimes(token)
+	return exp, err
+}
+
+// getTokenTimes extracts the issued-at and expiration times from a JWT token.
+// issuedAt is zero if the token has no iat claim.
+func getTokenTimes(token string) (issuedAt, exp time.Time, err error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return time.Time{}, fmt.Errorf("invalid JWT format")
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid JWT format")
 	}
 
 	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return time.Time{}, fmt.Errorf("decoding JWT payload: %w", err)
+		return time.Time{}, time.Time{}, fmt.Errorf("decoding JWT payload: %w", err)
 	}
 
 	var claims struct {
-		Exp int64 `json:"exp"`
+		IssuedAt int64 `json:"iat"`
+		Exp      int64 `json:"exp"`
 	}
 	if err := json.Unmarshal(payload, &claims); err != nil {
-		return time.Time{}, fmt.Errorf("parsing JWT claims: %w", err)
+		return time.Time{}, time.Time{}, fmt.Errorf("parsing JWT claims: %w", err)
 	}
 
 	if claims.Exp == 0 {
-		return time.Time{}, fmt.Errorf("token has no expiration claim")
+		return time.Time{}, time.Time{}, fmt.Errorf("token has no expiration claim")
 	}
 
-	return time.Unix(claims.Exp, 0), nil
+	if claims.IssuedAt != 0 {
+		issuedAt = time.Unix(claims.IssuedAt, 0)
+	}
+	return issuedAt, time.Unix(claims.Exp, 0), nil
 }
 
 // checkCACertExpiration logs a warning if the CA certificate is within the last 20% of its lifetime.
@@ -273,50 +526,3 @@ func checkCACertExpiration(cluster string, caCertPEM []byte) {
 			cluster, int(timeUntilExpiry.Hours()/24), cert.NotAfter.Format(time.RFC3339))
 	}
 }
-
-func (r *Renewer) createClient(cfg config.ClusterConfig, creds *Credentials) (kubernetes.Interface, error) {
-	// Load CA cert
-	var caCert []byte
-	if creds != nil && len(creds.CACert) > 0 {
-		caCert = creds.CACert
-	} else if cfg.CACert != "" {
-		var err error
-		caCert, err = os.ReadFile(cfg.CACert)
-		if err != nil {
-			return nil, fmt.Errorf("reading CA cert: %w", err)
-		}
-	}
-
-	// Build TLS config
-	tlsConfig := &tls.Config{}
-	if len(caCert) > 0 {
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to parse CA cert")
-		}
-		tlsConfig.RootCAs = caCertPool
-	}
-
-	// Get token
-	var token string
-	if creds != nil && creds.Token != "" {
-		token = creds.Token
-	} else if cfg.TokenPath != "" {
-		tokenBytes, err := os.ReadFile(cfg.TokenPath)
-		if err != nil {
-			return nil, fmt.Errorf("reading token: %w", err)
-		}
-		token = string(tokenBytes)
-	}
-
-	// Create REST config
-	restConfig := &rest.Config{
-		Host:        cfg.APIServer,
-		BearerToken: token,
-		TLSClientConfig: rest.TLSClientConfig{
-			CAData: caCert,
-		},
-	}
-
-	return kubernetes.NewForConfig(restConfig)
-}
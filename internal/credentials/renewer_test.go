@@ -16,6 +16,8 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -30,6 +32,19 @@ import (
 	"github.com/rophy/kube-federated-auth/internal/config"
 )
 
+// makeJWTWithIat creates a minimal JWT with issued-at and expiration claims for testing.
+func makeJWTWithIat(sub string, iat, exp time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	claims := map[string]interface{}{
+		"sub": sub,
+		"iat": iat.Unix(),
+		"exp": exp.Unix(),
+	}
+	claimsJSON, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(claimsJSON)
+	return header + "." + payload + ".sig"
+}
+
 // makeJWT creates a minimal JWT with the given claims for testing.
 func makeJWT(sub string, exp time.Time) string {
 	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
@@ -170,7 +185,7 @@ func TestRenew_SuccessWithValidToken(t *testing.T) {
 	fakeClient := setupFakeClient(t, renewedToken)
 	cfg := defaultConfig()
 
-	r := NewRenewer(cfg, store, nil)
+	r := NewRenewer(cfg, store, nil, nil)
 	r.clientFactory = fakeClientFactory(fakeClient)
 
 	// Force renew_before to be very large so renewal is triggered
@@ -197,7 +212,7 @@ func TestRenew_SkipsWhenTokenNotExpiring(t *testing.T) {
 	fakeClient := setupFakeClient(t, "should-not-be-used")
 	cfg := defaultConfig()
 
-	r := NewRenewer(cfg, store, nil)
+	r := NewRenewer(cfg, store, nil, nil)
 	r.clientFactory = fakeClientFactory(fakeClient)
 
 	var buf bytes.Buffer
@@ -248,7 +263,7 @@ func TestRenew_FallsBackToBootstrapOnFailure(t *testing.T) {
 	}
 	cfg.Renewal = &config.RenewalSettings{RenewBefore: 8760 * time.Hour}
 
-	r := NewRenewer(cfg, store, nil)
+	r := NewRenewer(cfg, store, nil, nil)
 	r.clientFactory = fakeClientFactory(fakeClient)
 
 	var buf bytes.Buffer
@@ -295,7 +310,7 @@ func TestRenew_BothStoredAndBootstrapFail(t *testing.T) {
 	}
 	cfg.Renewal = &config.RenewalSettings{RenewBefore: 8760 * time.Hour}
 
-	r := NewRenewer(cfg, store, nil)
+	r := NewRenewer(cfg, store, nil, nil)
 	r.clientFactory = fakeClientFactory(fakeClient)
 
 	var buf bytes.Buffer
@@ -326,7 +341,7 @@ func TestRenew_NoTokenPathConfigured(t *testing.T) {
 	cfg := defaultConfig() // no TokenPath set
 	cfg.Renewal = &config.RenewalSettings{RenewBefore: 8760 * time.Hour}
 
-	r := NewRenewer(cfg, store, nil)
+	r := NewRenewer(cfg, store, nil, nil)
 	r.clientFactory = fakeClientFactory(fakeClient)
 
 	var buf bytes.Buffer
@@ -360,7 +375,7 @@ func TestRenew_BootstrapFileNotReadable(t *testing.T) {
 	}
 	cfg.Renewal = &config.RenewalSettings{RenewBefore: 8760 * time.Hour}
 
-	r := NewRenewer(cfg, store, nil)
+	r := NewRenewer(cfg, store, nil, nil)
 	r.clientFactory = fakeClientFactory(fakeClient)
 
 	var buf bytes.Buffer
@@ -377,3 +392,447 @@ func TestRenew_BootstrapFileNotReadable(t *testing.T) {
 		t.Errorf("expected 'failed to read' log, got: %s", output)
 	}
 }
+
+// --- Retry backoff tests ---
+
+func noJitter(time.Duration) time.Duration { return 0 }
+
+func TestRetryDelay_GrowsExponentially(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RetryBackoff: 10 * time.Second, MaxBackoff: 10 * time.Minute}
+
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+	r.randDuration = noJitter
+
+	// With no jitter the delay is half of the backoff step
+	for failures, want := range map[int]time.Duration{
+		1: 5 * time.Second,
+		2: 10 * time.Second,
+		3: 20 * time.Second,
+		4: 40 * time.Second,
+	} {
+		if got := r.retryDelay("cluster-b", failures); got != want {
+			t.Errorf("failures=%d: delay = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestRetryDelay_CappedAtMaxBackoff(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RetryBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+	r.randDuration = noJitter
+
+	if got := r.retryDelay("cluster-b", 100); got != 30*time.Second {
+		t.Errorf("delay = %s, want %s", got, 30*time.Second)
+	}
+}
+
+func TestRetryDelay_ShrinksNearExpiry(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RetryBackoff: time.Second, MaxBackoff: time.Hour}
+
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{
+		Token: makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(8*time.Minute)),
+	}
+
+	r := NewRenewer(cfg, store, nil, nil)
+	r.randDuration = noJitter
+
+	// 2^20 seconds of backoff, but only 8m of token lifetime left → capped at ~2m, halved by jitter
+	got := r.retryDelay("cluster-b", 21)
+	if got > time.Minute || got < 59*time.Second {
+		t.Errorf("delay = %s, want ~1m", got)
+	}
+}
+
+func TestRetryDelay_NeverBelowBase(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RetryBackoff: 10 * time.Second}
+
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{
+		Token: makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(-time.Hour)),
+	}
+
+	r := NewRenewer(cfg, store, nil, nil)
+	r.randDuration = noJitter
+
+	if got := r.retryDelay("cluster-b", 5); got != 5*time.Second {
+		t.Errorf("delay = %s, want %s", got, 5*time.Second)
+	}
+}
+
+func TestRenewLoop_RetriesAfterFailure(t *testing.T) {
+	storedToken := makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(1*time.Hour))
+	renewedToken := makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(168*time.Hour))
+
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{Token: storedToken, CACert: []byte("ca")}
+
+	calls := 0
+	fakeClient := kubefake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "serviceaccounts/token", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls < 3 {
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		return true, &authv1.TokenRequest{
+			Status: authv1.TokenRequestStatus{
+				Token:               renewedToken,
+				ExpirationTimestamp: metav1.NewTime(time.Now().Add(168 * time.Hour)),
+			},
+		}, nil
+	})
+
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{
+		RenewBefore:   8760 * time.Hour,
+		RetryBackoff:  time.Millisecond,
+		StartupJitter: time.Millisecond,
+	}
+
+	r := NewRenewer(cfg, store, nil, nil)
+	r.clientFactory = fakeClientFactory(fakeClient)
+
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.renewLoop(ctx, "cluster-b", cfg.Clusters["cluster-b"], time.Hour, nil)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if creds, _ := store.Get("cluster-b"); creds.Token == renewedToken {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	creds, _ := store.Get("cluster-b")
+	if creds.Token != renewedToken {
+		t.Fatalf("expected token to be renewed after retries (calls: %d)", calls)
+	}
+}
+
+// --- Renewal scheduling tests ---
+
+func TestRenewalTime_UsesRenewBefore(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RenewBefore: 48 * time.Hour}
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+
+	iat := time.Unix(1_700_000_000, 0)
+	exp := iat.Add(168 * time.Hour)
+
+	if got, want := r.renewalTime(iat, exp), exp.Add(-48*time.Hour); !got.Equal(want) {
+		t.Errorf("renewalTime = %s, want %s", got, want)
+	}
+}
+
+func TestRenewalTime_ShortLivedTokenUsesLifetimeFraction(t *testing.T) {
+	// renew_before (48h) exceeds the 1h token lifetime → renew at 80% of lifetime
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RenewBefore: 48 * time.Hour}
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+
+	iat := time.Unix(1_700_000_000, 0)
+	exp := iat.Add(time.Hour)
+
+	if got, want := r.renewalTime(iat, exp), iat.Add(48*time.Minute); !got.Equal(want) {
+		t.Errorf("renewalTime = %s, want %s", got, want)
+	}
+}
+
+func TestNextRenewal_ShorterThanInterval(t *testing.T) {
+	now := time.Now()
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{
+		Token: makeJWTWithIat("system:serviceaccount:kube-federated-auth:reader", now, now.Add(10*time.Minute)),
+	}
+
+	r := NewRenewer(defaultConfig(), store, nil, nil)
+
+	// 10m token with default 48h renew_before → due at 8m, well before the 1h interval
+	got := r.nextRenewal("cluster-b", time.Hour)
+	if got > 8*time.Minute || got < 7*time.Minute {
+		t.Errorf("nextRenewal = %s, want ~8m", got)
+	}
+}
+
+func TestNextRenewal_BoundedByInterval(t *testing.T) {
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{
+		Token: makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(168*time.Hour)),
+	}
+
+	r := NewRenewer(defaultConfig(), store, nil, nil)
+	r.randDuration = noJitter
+
+	if got := r.nextRenewal("cluster-b", time.Hour); got != time.Hour {
+		t.Errorf("nextRenewal = %s, want %s", got, time.Hour)
+	}
+}
+
+func TestNextRenewal_Jitter(t *testing.T) {
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{
+		Token: makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(168*time.Hour)),
+	}
+
+	r := NewRenewer(defaultConfig(), store, nil, nil)
+	r.randDuration = func(n time.Duration) time.Duration { return n - 1 }
+
+	// Up to 10% shorter, so loops started together drift apart
+	if got, want := r.nextRenewal("cluster-b", time.Hour), 54*time.Minute+1; got != want {
+		t.Errorf("nextRenewal = %s, want %s", got, want)
+	}
+}
+
+func TestNextRenewal_NeverBelowRetryBackoff(t *testing.T) {
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{
+		Token: makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(time.Minute)),
+	}
+
+	r := NewRenewer(defaultConfig(), store, nil, nil)
+
+	if got := r.nextRenewal("cluster-b", time.Hour); got != config.DefaultRenewalRetryBackoff {
+		t.Errorf("nextRenewal = %s, want %s", got, config.DefaultRenewalRetryBackoff)
+	}
+}
+
+func TestStart_ReschedulesOnCredentialChange(t *testing.T) {
+	now := time.Now()
+	subject := "system:serviceaccount:kube-federated-auth:reader"
+	renewedToken := makeJWTWithIat(subject, now, now.Add(168*time.Hour))
+
+	store := newTestStore()
+	// Fresh token: nothing to do until it is replaced
+	store.credentials["cluster-b"] = &Credentials{Token: makeJWTWithIat(subject, now, now.Add(168*time.Hour)), CACert: []byte("ca")}
+
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{
+		RetryBackoff:  time.Millisecond,
+		StartupJitter: time.Millisecond,
+	}
+
+	r := NewRenewer(cfg, store, nil, nil)
+	r.clientFactory = fakeClientFactory(setupFakeClient(t, renewedToken))
+
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx)
+
+	// Give the loop time to run its initial check and go to sleep
+	time.Sleep(50 * time.Millisecond)
+
+	// An externally stored short-lived token (e.g. re-read bootstrap) is already due
+	shortToken := makeJWTWithIat(subject, now.Add(-time.Hour), now.Add(time.Minute))
+	if err := store.Set(ctx, "cluster-b", &Credentials{Token: shortToken, CACert: []byte("ca")}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if creds, _ := store.Get("cluster-b"); creds.Token == renewedToken {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("expected loop to reschedule and renew after credentials changed")
+}
+
+func TestSync_FollowsConfigChanges(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{StartupJitter: time.Hour}
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx)
+
+	running := func() []string {
+		r.loopsMu.Lock()
+		defer r.loopsMu.Unlock()
+		var names []string
+		for name, loop := range r.loops {
+			if loop.cancel != nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got := running(); !slices.Equal(got, []string{"cluster-b"}) {
+		t.Fatalf("running loops = %v, want [cluster-b]", got)
+	}
+
+	if err := cfg.SetCluster("cluster-c", config.ClusterConfig{Issuer: "https://c.example.com", APIServer: "https://10.0.0.2:6443"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Sync()
+	if got := running(); !slices.Equal(got, []string{"cluster-b", "cluster-c"}) {
+		t.Errorf("running loops = %v, want [cluster-b cluster-c]", got)
+	}
+
+	cfg.DeleteCluster("cluster-b")
+	r.Sync()
+	if got := running(); !slices.Equal(got, []string{"cluster-c"}) {
+		t.Errorf("running loops = %v, want [cluster-c]", got)
+	}
+}
+
+// --- On-demand renewal tests ---
+
+func TestRenewNow_IgnoresRenewalSchedule(t *testing.T) {
+	// Token expires in 168h, well outside renew_before, but manual renewal forces it
+	storedToken := makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(168*time.Hour))
+	renewedToken := makeJWT("system:serviceaccount:kube-federated-auth:reader", time.Now().Add(169*time.Hour))
+
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{Token: storedToken, CACert: []byte("ca")}
+
+	r := NewRenewer(defaultConfig(), store, nil, nil)
+	r.clientFactory = fakeClientFactory(setupFakeClient(t, renewedToken))
+
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	if err := r.RenewNow(context.Background(), "cluster-b"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	creds, _ := store.Get("cluster-b")
+	if creds.Token != renewedToken {
+		t.Error("expected token to be renewed")
+	}
+
+	history := r.History(10)
+	if len(history) != 1 || history[0].Trigger != TriggerManual || !history[0].Success {
+		t.Errorf("history = %+v, want one successful manual attempt", history)
+	}
+}
+
+func TestRenewNow_UnknownOrLocalCluster(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Clusters["cluster-a"] = config.ClusterConfig{Issuer: "https://a.example.com"}
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+
+	if err := r.RenewNow(context.Background(), "unknown"); err == nil {
+		t.Error("expected error for unknown cluster")
+	}
+	if err := r.RenewNow(context.Background(), "cluster-a"); err == nil {
+		t.Error("expected error for local cluster")
+	}
+}
+
+func TestRenew_RecordsFailuresInHistory(t *testing.T) {
+	store := newTestStore()
+	store.credentials["cluster-b"] = &Credentials{Token: "invalid.token.here", CACert: []byte("ca")}
+
+	cfg := defaultConfig()
+	cfg.Renewal = &config.RenewalSettings{RenewBefore: 8760 * time.Hour}
+
+	r := NewRenewer(cfg, store, nil, nil)
+	r.clientFactory = fakeClientFactory(setupFailingClient(t))
+
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	r.renew(context.Background(), "cluster-b", cfg.Clusters["cluster-b"])
+
+	history := r.History(0)
+	if len(history) != 1 || history[0].Success || history[0].Error == "" {
+		t.Errorf("history = %+v, want one failed attempt with error", history)
+	}
+}
+
+func TestRenewalHistory_KeepsMostRecent(t *testing.T) {
+	h := newRenewalHistory(3)
+	for _, cluster := range []string{"a", "b", "c", "d"} {
+		h.record(cluster, TriggerScheduled, nil)
+	}
+
+	got := h.list(0)
+	if len(got) != 3 || got[0].Cluster != "d" || got[2].Cluster != "b" {
+		t.Errorf("history = %+v, want [d c b]", got)
+	}
+	if got := h.list(2); len(got) != 2 || got[1].Cluster != "c" {
+		t.Errorf("limited history = %+v, want [d c]", got)
+	}
+}
+
+func TestClusterLocks_PerCluster(t *testing.T) {
+	var locks clusterLocks
+	unlockA := locks.lock("cluster-a")
+
+	// Another cluster isn't held up by cluster-a's renewal
+	done := make(chan struct{})
+	go func() {
+		locks.lock("cluster-b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cluster-b blocked by cluster-a's lock")
+	}
+
+	// The same cluster waits for it
+	locked := make(chan struct{})
+	go func() {
+		locks.lock("cluster-a")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("cluster-a locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockA()
+	<-locked
+}
+
+func TestRenewNow_ExternalAuthCluster(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Clusters["cluster-b"] = config.ClusterConfig{
+		Issuer:    "https://kubernetes.default.svc.cluster.local",
+		APIServer: "https://10.0.0.1:6443",
+		Exec:      &config.ExecConfig{Command: "aws"},
+	}
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+
+	if err := r.RenewNow(context.Background(), "cluster-b"); err == nil {
+		t.Error("expected error for cluster with exec credentials")
+	}
+}
+
+func TestRenewNow_ClientCertCluster(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Clusters["cluster-b"] = config.ClusterConfig{
+		Issuer:     "https://kubernetes.default.svc.cluster.local",
+		APIServer:  "https://10.0.0.1:6443",
+		ClientCert: "/path/to/client.crt",
+		ClientKey:  "/path/to/client.key",
+	}
+	r := NewRenewer(cfg, newTestStore(), nil, nil)
+
+	if err := r.RenewNow(context.Background(), "cluster-b"); err == nil {
+		t.Error("expected error for client-cert cluster without token")
+	}
+}
@@ -29,6 +29,7 @@ type Store struct {
 	client      kubernetes.Interface
 	namespace   string
 	secretName  string
+	listeners   []func(cluster string)
 }
 
 // NewStore creates a new credential store
@@ -71,11 +72,30 @@ func (s *Store) Get(cluster string) (*Credentials, bool) {
 	return creds, ok
 }
 
+// OnChange registers a callback invoked after credentials for a cluster are replaced
+// via Set, Load or LoadFromFiles, or removed via Delete. Callbacks run synchronously
+// and must not block.
+func (s *Store) OnChange(fn func(cluster string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *Store) notify(cluster string) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(cluster)
+	}
+}
+
 // Set stores credentials for a cluster and persists to Secret
 func (s *Store) Set(ctx context.Context, cluster string, creds *Credentials) error {
 	s.mu.Lock()
 	s.credentials[cluster] = creds
 	s.mu.Unlock()
+	s.notify(cluster)
 
 	// Persist to Secret if we have a client
 	if s.client != nil {
@@ -87,6 +107,26 @@ func (s *Store) Set(ctx context.Context, cluster string, creds *Credentials) err
 	return nil
 }
 
+// Delete removes credentials for a cluster and persists the removal to Secret
+func (s *Store) Delete(ctx context.Context, cluster string) error {
+	s.mu.Lock()
+	_, ok := s.credentials[cluster]
+	delete(s.credentials, cluster)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	s.notify(cluster)
+
+	if s.client != nil {
+		if err := s.saveToSecret(ctx); err != nil {
+			return fmt.Errorf("persisting credentials: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // loadFromSecret loads credentials from the Kubernetes Secret
 func (s *Store) loadFromSecret(ctx context.Context) error {
 	if s.client == nil {
@@ -197,15 +237,31 @@ func (s *Store) LoadFromFiles(cluster, tokenPath, caPath string) error {
 		return fmt.Errorf("reading CA file: %w", err)
 	}
 
+	s.Load(cluster, string(token), ca)
+	log.Printf("Loaded bootstrap credentials for cluster %s from files", cluster)
+	return nil
+}
+
+// LoadBootstrap stores bootstrap credentials only if the store doesn't already have
+// credentials for the cluster. Returns true if the credentials were stored.
+func (s *Store) LoadBootstrap(cluster, token string, caCert []byte) bool {
+	if _, ok := s.Get(cluster); ok {
+		return false
+	}
+	s.Load(cluster, token, caCert)
+	return true
+}
+
+// Load replaces credentials for a cluster in memory, e.g. bootstrap credentials read
+// from a Secret. Renewed credentials are persisted by the next Set.
+func (s *Store) Load(cluster, token string, caCert []byte) {
 	s.mu.Lock()
 	s.credentials[cluster] = &Credentials{
-		Token:  string(token),
-		CACert: ca,
+		Token:  token,
+		CACert: caCert,
 	}
 	s.mu.Unlock()
-
-	log.Printf("Loaded bootstrap credentials for cluster %s from files", cluster)
-	return nil
+	s.notify(cluster)
 }
 
 // ParseBase64CACert decodes a base64-encoded CA certificate
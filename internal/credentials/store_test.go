@@ -1,9 +1,14 @@
 package credentials
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 )
 
 func newTestStore() *Store {
@@ -84,3 +89,70 @@ func TestLoadFromFiles_AlwaysOverwrites(t *testing.T) {
 		t.Errorf("expected LoadFromFiles to overwrite with 'bootstrap-token', got '%s'", creds.Token)
 	}
 }
+
+func TestOnChange_NotifiesOnSetAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	tokenPath, caPath := writeTestFiles(t, dir, "bootstrap-token", "bootstrap-ca")
+
+	store := newTestStore()
+	var changed []string
+	store.OnChange(func(cluster string) {
+		changed = append(changed, cluster)
+	})
+
+	if err := store.Set(context.Background(), "cluster-b", &Credentials{Token: "renewed-token"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadFromFiles("cluster-c", tokenPath, caPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 2 || changed[0] != "cluster-b" || changed[1] != "cluster-c" {
+		t.Errorf("changed = %v, want [cluster-b cluster-c]", changed)
+	}
+}
+
+func TestDelete_RemovesPersistedCredentials(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	store.client = kubefake.NewClientset()
+	store.namespace = "kube-federated-auth"
+	store.secretName = "credentials"
+	var changed []string
+	store.OnChange(func(cluster string) {
+		changed = append(changed, cluster)
+	})
+
+	for _, cluster := range []string{"cluster-b", "cluster-c"} {
+		if err := store.Set(ctx, cluster, &Credentials{Token: cluster + "-token", CACert: []byte("ca")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "cluster-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Get("cluster-b"); ok {
+		t.Error("expected cluster-b credentials to be deleted")
+	}
+	if _, ok := store.Get("cluster-c"); !ok {
+		t.Error("expected cluster-c credentials to be kept")
+	}
+	if want := []string{"cluster-b", "cluster-c", "cluster-b"}; !slices.Equal(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+
+	secret, err := store.client.CoreV1().Secrets("kube-federated-auth").Get(ctx, "credentials", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Data["cluster-b-token"]; ok {
+		t.Error("expected cluster-b token to be removed from the Secret")
+	}
+	if string(secret.Data["cluster-c-token"]) != "cluster-c-token" {
+		t.Errorf("cluster-c token = %q, want cluster-c-token", secret.Data["cluster-c-token"])
+	}
+}
@@ -0,0 +1,29 @@
+package credentials
+
+import (
+	"context"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CreateToken requests a token for a ServiceAccount via the TokenRequest API.
+// Empty audiences yield a token for the API server's default audiences.
+func CreateToken(ctx context.Context, client kubernetes.Interface, namespace, serviceAccount string, audiences []string, expiration time.Duration) (*authv1.TokenRequest, error) {
+	expirationSeconds := int64(expiration.Seconds())
+	tokenRequest := &authv1.TokenRequest{
+		Spec: authv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+
+	return client.CoreV1().ServiceAccounts(namespace).CreateToken(
+		ctx,
+		serviceAccount,
+		tokenRequest,
+		metav1.CreateOptions{},
+	)
+}
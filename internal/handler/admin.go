@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rophy/kube-federated-auth/internal/audit"
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+)
+
+// defaultHistoryLimit is the number of renewal attempts or audit events returned when
+// no limit is given
+const defaultHistoryLimit = 20
+
+// CredentialRenewer triggers credential renewals and reports past attempts.
+type CredentialRenewer interface {
+	RenewNow(ctx context.Context, cluster string) error
+	History(limit int) []credentials.RenewalAttempt
+}
+
+// VerifierCache invalidates cached per-cluster verifiers and their JWKS.
+type VerifierCache interface {
+	InvalidateVerifier(clusterName string)
+	InvalidateAll()
+}
+
+type AdminResponse struct {
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type RenewalsResponse struct {
+	Renewals []credentials.RenewalAttempt `json:"renewals"`
+}
+
+type AuditResponse struct {
+	Events []audit.Event `json:"events"`
+}
+
+// AdminHandler serves credential management endpoints for callers in admin_clients.
+type AdminHandler struct {
+	verifier  TokenVerifier
+	cache     VerifierCache
+	config    *config.Config
+	credStore *credentials.Store
+	renewer   CredentialRenewer
+	audit     *audit.Log
+}
+
+// NewAdminHandler creates an admin handler. renewer may be nil when no remote clusters
+// are configured.
+func NewAdminHandler(v TokenVerifier, cache VerifierCache, cfg *config.Config, store *credentials.Store, renewer CredentialRenewer, auditLog *audit.Log) *AdminHandler {
+	return &AdminHandler{
+		verifier:  v,
+		cache:     cache,
+		config:    cfg,
+		credStore: store,
+		renewer:   renewer,
+		audit:     auditLog,
+	}
+}
+
+// Authenticate is middleware that requires the caller's SA token to match admin_clients.
+func (h *AdminHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := authenticateCaller(r, h.verifier, h.config, h.config.IsAdminClient); err != nil {
+			h.writeJSON(w, err.Status, AdminResponse{Error: err.Message})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Renew forces an immediate credential renewal for a remote cluster.
+func (h *AdminHandler) Renew(w http.ResponseWriter, r *http.Request) {
+	cluster, ok := h.remoteCluster(w, r)
+	if !ok {
+		return
+	}
+	if h.renewer == nil {
+		h.writeJSON(w, http.StatusConflict, AdminResponse{Error: "credential renewal is not enabled"})
+		return
+	}
+
+	if err := h.renewer.RenewNow(r.Context(), cluster); err != nil {
+		log.Printf("Admin: renewal failed for cluster %s: %v", cluster, err)
+		h.writeJSON(w, http.StatusBadGateway, AdminResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("Admin: renewed credentials for cluster %s", cluster)
+	h.writeJSON(w, http.StatusOK, AdminResponse{Status: "renewed"})
+}
+
+// Reload re-reads bootstrap credentials for a remote cluster from token_path and ca_cert.
+func (h *AdminHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	cluster, ok := h.remoteCluster(w, r)
+	if !ok {
+		return
+	}
+
+	clusterCfg, _ := h.config.Cluster(cluster)
+	if clusterCfg.TokenPath == "" || clusterCfg.CACert == "" {
+		h.writeJSON(w, http.StatusConflict, AdminResponse{Error: "cluster has no token_path and ca_cert configured"})
+		return
+	}
+	if h.credStore == nil {
+		h.writeJSON(w, http.StatusConflict, AdminResponse{Error: "credential store is not enabled"})
+		return
+	}
+
+	if err := h.credStore.LoadFromFiles(cluster, clusterCfg.TokenPath, clusterCfg.CACert); err != nil {
+		log.Printf("Admin: reloading bootstrap credentials failed for cluster %s: %v", cluster, err)
+		h.writeJSON(w, http.StatusInternalServerError, AdminResponse{Error: err.Error()})
+		return
+	}
+	h.cache.InvalidateVerifier(cluster)
+
+	log.Printf("Admin: reloaded bootstrap credentials for cluster %s", cluster)
+	h.writeJSON(w, http.StatusOK, AdminResponse{Status: "reloaded"})
+}
+
+// Invalidate drops the cached verifier for a cluster so its JWKS is re-fetched.
+func (h *AdminHandler) Invalidate(w http.ResponseWriter, r *http.Request) {
+	cluster := chi.URLParam(r, "cluster")
+	if _, ok := h.config.Cluster(cluster); !ok {
+		h.writeJSON(w, http.StatusNotFound, AdminResponse{Error: "cluster not found: " + cluster})
+		return
+	}
+
+	h.cache.InvalidateVerifier(cluster)
+
+	log.Printf("Admin: invalidated verifier for cluster %s", cluster)
+	h.writeJSON(w, http.StatusOK, AdminResponse{Status: "invalidated"})
+}
+
+// PurgeCache drops all cached verifiers.
+func (h *AdminHandler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	h.cache.InvalidateAll()
+
+	log.Printf("Admin: purged all cached verifiers")
+	h.writeJSON(w, http.StatusOK, AdminResponse{Status: "purged"})
+}
+
+// Renewals lists the most recent renewal attempts, limited by the "limit" query parameter.
+func (h *AdminHandler) Renewals(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.limit(w, r)
+	if !ok {
+		return
+	}
+
+	renewals := []credentials.RenewalAttempt{}
+	if h.renewer != nil {
+		renewals = h.renewer.History(limit)
+	}
+	h.writeJSON(w, http.StatusOK, RenewalsResponse{Renewals: renewals})
+}
+
+// Audit lists the most recent audit events, limited by the "limit" query parameter.
+func (h *AdminHandler) Audit(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.limit(w, r)
+	if !ok {
+		return
+	}
+
+	events := []audit.Event{}
+	if h.audit != nil {
+		events = h.audit.List(limit)
+	}
+	h.writeJSON(w, http.StatusOK, AuditResponse{Events: events})
+}
+
+// limit parses the "limit" query parameter, writing an error response if it is invalid.
+func (h *AdminHandler) limit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultHistoryLimit, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		h.writeJSON(w, http.StatusBadRequest, AdminResponse{Error: "limit must be a positive integer"})
+		return 0, false
+	}
+	return n, true
+}
+
+// remoteCluster resolves the {cluster} URL parameter and ensures it is a remote cluster.
+func (h *AdminHandler) remoteCluster(w http.ResponseWriter, r *http.Request) (string, bool) {
+	cluster := chi.URLParam(r, "cluster")
+	clusterCfg, ok := h.config.Cluster(cluster)
+	if !ok {
+		h.writeJSON(w, http.StatusNotFound, AdminResponse{Error: "cluster not found: " + cluster})
+		return "", false
+	}
+	if !clusterCfg.IsRemote() {
+		h.writeJSON(w, http.StatusConflict, AdminResponse{Error: "cluster " + cluster + " is not a remote cluster"})
+		return "", false
+	}
+	return cluster, true
+}
+
+func (h *AdminHandler) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/sync/errgroup"
+	authv1 "k8s.io/api/authentication/v1"
+
+	"github.com/rophy/kube-federated-auth/internal/oidc"
+)
+
+// BatchTokenReviewRequest is a list of tokens to review in one call
+type BatchTokenReviewRequest struct {
+	Specs []authv1.TokenReviewSpec `json:"specs"`
+}
+
+// BatchTokenReviewResponse has the status of each spec of the request, in order
+type BatchTokenReviewResponse struct {
+	Statuses []BatchTokenReviewStatus `json:"statuses"`
+}
+
+// BatchTokenReviewStatus is the TokenReview status of one token, with the error code if
+// it couldn't be reviewed
+type BatchTokenReviewStatus struct {
+	authv1.TokenReviewStatus
+	Code string `json:"code,omitempty"`
+}
+
+// Batch reviews a list of tokens. Each gets the status the TokenReview endpoint would
+// return; callers are authorized as there, and rate limited for one request per token.
+func (h *TokenReviewHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	resp := &reviewResponse{w: w, r: r, mediaType: MediaTypeJSON}
+
+	var caller *callerIdentity
+	if h.config != nil && h.config.HasAuthorizedClients() {
+		var err *Error
+		if caller, err = authenticateCaller(r, h.verifier, h.config, h.config.IsAuthorizedClient); err != nil {
+			resp.fail(err)
+			return
+		}
+	}
+
+	req, err := h.decodeBatch(w, r)
+	if err != nil {
+		resp.fail(err)
+		return
+	}
+
+	if err := h.limitCaller(clientAddress(r), caller, len(req.Specs)); err != nil {
+		logError(r.Context(), "Batch TokenReview", err)
+		resp.fail(err)
+		return
+	}
+
+	statuses := h.reviewBatch(r.Context(), req.Specs)
+	authenticated := 0
+	for _, status := range statuses {
+		if status.Authenticated {
+			authenticated++
+		}
+	}
+	log.Printf("Batch TokenReview (request %s): %d of %d tokens authenticated", middleware.GetReqID(r.Context()), authenticated, len(statuses))
+
+	w.Header().Set("Content-Type", MediaTypeJSON)
+	json.NewEncoder(w).Encode(BatchTokenReviewResponse{Statuses: statuses})
+}
+
+// decodeBatch reads a batch of at most limits.max_batch_size tokens. The body may hold
+// that many tokens of limits.max_token_bytes.
+func (h *TokenReviewHandler) decodeBatch(w http.ResponseWriter, r *http.Request) (*BatchTokenReviewRequest, *Error) {
+	if mediaType, err := requestMediaType(r); err != nil || mediaType != MediaTypeJSON {
+		return nil, newError(CodeUnsupportedMediaType, http.StatusUnsupportedMediaType, "Content-Type must be "+MediaTypeJSON, nil)
+	}
+
+	maxSize := h.config.GetMaxBatchSize()
+	maxBytes := h.config.GetMaxBodyBytes() + int64(maxSize)*int64(h.config.GetMaxTokenBytes())
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+	decoder.DisallowUnknownFields()
+
+	var req BatchTokenReviewRequest
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, newError(CodeRequestTooLarge, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBytes), nil)
+		}
+		return nil, newError(CodeInvalidRequest, http.StatusBadRequest, "invalid request body: "+err.Error(), err)
+	}
+	if err := h.checkBatch(len(req.Specs)); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// checkBatch rejects empty and oversized batches
+func (h *TokenReviewHandler) checkBatch(size int) *Error {
+	if size == 0 {
+		return newError(CodeInvalidRequest, http.StatusBadRequest, "specs is required", nil)
+	}
+	if maxSize := h.config.GetMaxBatchSize(); size > maxSize {
+		return newError(CodeRequestTooLarge, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d tokens", maxSize), nil)
+	}
+	return nil
+}
+
+// reviewBatch reviews specs in the two steps of a single review, each with at most
+// limits.batch_parallelism tokens at a time: detecting every token's cluster, then
+// forwarding the reviews grouped by cluster. Identical specs are reviewed once.
+func (h *TokenReviewHandler) reviewBatch(ctx context.Context, specs []authv1.TokenReviewSpec) []BatchTokenReviewStatus {
+	statuses := make([]BatchTokenReviewStatus, len(specs))
+	fail := func(i int, err *Error) {
+		statuses[i] = BatchTokenReviewStatus{TokenReviewStatus: authv1.TokenReviewStatus{Error: err.Message}, Code: err.Code}
+	}
+
+	// Index of the first of identical specs, and the others
+	var unique []int
+	duplicates := make(map[int][]int)
+	first := make(map[string]int)
+	for i, spec := range specs {
+		if h.verifier == nil || h.config == nil {
+			fail(i, newError(CodeNotConfigured, http.StatusOK, "server not configured", nil))
+			continue
+		}
+		if err := h.checkToken(spec.Token); err != nil {
+			fail(i, err)
+			continue
+		}
+		key := coalescingKey(spec)
+		if j, ok := first[key]; ok {
+			duplicates[j] = append(duplicates[j], i)
+			continue
+		}
+		first[key] = i
+		unique = append(unique, i)
+	}
+
+	parallelism := h.config.GetBatchParallelism()
+	var g errgroup.Group
+	g.SetLimit(parallelism)
+
+	// Step 1: Detect each token's cluster
+	clusters := make([]string, len(specs))
+	claims := make([]*oidc.Claims, len(specs))
+	for _, i := range unique {
+		g.Go(func() error {
+			cluster, tokenClaims, err := h.detect(ctx, specs[i].Token, nil)
+			if err != nil {
+				fail(i, err)
+				return nil
+			}
+			clusters[i], claims[i] = cluster, tokenClaims
+			return nil
+		})
+	}
+	g.Wait()
+
+	groups := make(map[string][]int)
+	for _, i := range unique {
+		if clusters[i] != "" {
+			groups[clusters[i]] = append(groups[clusters[i]], i)
+		}
+	}
+
+	// Step 2: Forward each cluster's reviews, in as many lanes as its concurrency cap
+	// allows, so the batch doesn't exceed the cap itself
+	lanes := parallelism
+	if h.forwardSlots != nil {
+		lanes = min(lanes, h.forwardSlots.max)
+	}
+	for _, cluster := range slices.Sorted(maps.Keys(groups)) {
+		group := groups[cluster]
+		for lane := range min(lanes, len(group)) {
+			g.Go(func() error {
+				for n := lane; n < len(group); n += lanes {
+					i := group[n]
+					result, err := h.forward(ctx, cluster, claims[i], &authv1.TokenReview{Spec: specs[i]}, nil)
+					if err != nil {
+						fail(i, err)
+						continue
+					}
+					statuses[i] = BatchTokenReviewStatus{TokenReviewStatus: result.status.Status}
+				}
+				return nil
+			})
+		}
+	}
+	g.Wait()
+
+	for _, i := range unique {
+		reviewRequests.WithLabelValues("false").Inc()
+		for _, j := range duplicates[i] {
+			reviewRequests.WithLabelValues("true").Inc()
+			statuses[j] = statuses[i]
+		}
+	}
+	return statuses
+}
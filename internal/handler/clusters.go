@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,15 +16,23 @@ type ClusterInfo struct {
 	Name        string       `json:"name"`
 	Issuer      string       `json:"issuer"`
 	APIServer   string       `json:"api_server,omitempty"`
+	Insecure    bool         `json:"insecure_skip_tls_verify,omitempty"`
 	TokenStatus *TokenStatus `json:"token_status,omitempty"`
 }
 
 type TokenStatus struct {
+	Type      string `json:"type,omitempty"` // "token", "client_certificate"
 	ExpiresAt string `json:"expires_at,omitempty"`
 	ExpiresIn string `json:"expires_in,omitempty"`
 	Status    string `json:"status"` // "valid", "expiring_soon", "expired", "unknown"
 }
 
+// Credential types reported in TokenStatus
+const (
+	CredentialTypeToken      = "token"
+	CredentialTypeClientCert = "client_certificate"
+)
+
 type ClustersResponse struct {
 	Clusters []ClusterInfo `json:"clusters"`
 }
@@ -39,17 +48,25 @@ func NewClustersHandler(cfg *config.Config, credStore *credentials.Store) *Clust
 
 func (h *ClustersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClustersResponse{Clusters: h.list()})
+}
 
+// list returns the configured clusters, by name
+func (h *ClustersHandler) list() []ClusterInfo {
 	var clusters []ClusterInfo
-	for name, cfg := range h.config.Clusters {
+	for name, cfg := range h.config.ClusterConfigs() {
 		info := ClusterInfo{
 			Name:      name,
 			Issuer:    cfg.Issuer,
 			APIServer: cfg.APIServer,
+			Insecure:  cfg.InsecureSkipTLSVerify,
 		}
 
-		// Add token status if we have credentials for this cluster
-		if h.credStore != nil {
+		// Client-cert clusters report the certificate; others report the token
+		// if we have credentials for this cluster
+		if cfg.UsesClientCert() {
+			info.TokenStatus = getClientCertStatus(cfg.ClientCert)
+		} else if h.credStore != nil {
 			if creds, ok := h.credStore.Get(name); ok {
 				info.TokenStatus = getTokenStatus(creds)
 			}
@@ -57,23 +74,37 @@ func (h *ClustersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		clusters = append(clusters, info)
 	}
-
-	json.NewEncoder(w).Encode(ClustersResponse{Clusters: clusters})
+	slices.SortFunc(clusters, func(a, b ClusterInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return clusters
 }
 
 func getTokenStatus(creds *credentials.Credentials) *TokenStatus {
 	if creds == nil || creds.Token == "" {
-		return &TokenStatus{Status: "unknown"}
+		return &TokenStatus{Type: CredentialTypeToken, Status: "unknown"}
 	}
 
 	exp, err := extractJWTExpiration(creds.Token)
 	if err != nil || exp == 0 {
-		return &TokenStatus{Status: "unknown"}
+		return &TokenStatus{Type: CredentialTypeToken, Status: "unknown"}
+	}
+
+	return expiryStatus(CredentialTypeToken, time.Unix(exp, 0))
+}
+
+func getClientCertStatus(certPath string) *TokenStatus {
+	expiresAt, err := credentials.ClientCertExpiration(certPath)
+	if err != nil {
+		return &TokenStatus{Type: CredentialTypeClientCert, Status: "unknown"}
 	}
+	return expiryStatus(CredentialTypeClientCert, expiresAt)
+}
 
+func expiryStatus(credType string, expiresAt time.Time) *TokenStatus {
 	now := time.Now()
-	expiresAt := time.Unix(exp, 0)
 	status := &TokenStatus{
+		Type:      credType,
 		ExpiresAt: expiresAt.Format(time.RFC3339),
 	}
 
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// coalescedReviewTimeout bounds a coalesced review, which outlives the request that
+// started it if that request is cancelled
+const coalescedReviewTimeout = 30 * time.Second
+
+// review resolves a TokenReview (see reviewToken). Identical concurrent reviews are
+// coalesced: one detects the cluster and forwards, and the rest share its result.
+// Explained reviews aren't coalesced, as each records its own trace.
+func (h *TokenReviewHandler) review(ctx context.Context, tr *authv1.TokenReview, trace *ReviewTrace) (*reviewResult, *Error) {
+	if trace != nil {
+		reviewRequests.WithLabelValues("false").Inc()
+		return h.reviewToken(ctx, tr, trace)
+	}
+
+	// The review runs on behalf of every request waiting for it, so it isn't cancelled
+	// with the one that started it
+	leader := false
+	flight := h.inFlight.DoChan(coalescingKey(tr.Spec), func() (any, error) {
+		leader = true
+		reviewCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), coalescedReviewTimeout)
+		defer cancel()
+		result, err := h.reviewToken(reviewCtx, tr, nil)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+
+	var res singleflight.Result
+	select {
+	case res = <-flight:
+	case <-ctx.Done():
+		return nil, forwardError(fmt.Errorf("waiting for review: %w", context.Cause(ctx)))
+	}
+	reviewRequests.WithLabelValues(strconv.FormatBool(!leader)).Inc()
+
+	if res.Err != nil {
+		return nil, res.Err.(*Error)
+	}
+	result := res.Val.(*reviewResult)
+	if res.Shared {
+		// Each request gets its own copy to write in its response
+		shared := *result
+		shared.status = result.status.DeepCopy()
+		result = &shared
+	}
+	return result, nil
+}
+
+// coalescingKey identifies identical reviews: the same token for the same audiences.
+// The token is hashed so it isn't held as a map key.
+func coalescingKey(spec authv1.TokenReviewSpec) string {
+	hash := sha256.Sum256([]byte(spec.Token))
+	audiences := slices.Sorted(slices.Values(spec.Audiences))
+	return hex.EncodeToString(hash[:]) + "/" + strings.Join(audiences, "\x00")
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// Media types of the TokenReview endpoint. Responses use the first of these the
+// caller accepts, or the request's.
+const (
+	MediaTypeJSON     = runtime.ContentTypeJSON
+	MediaTypeProtobuf = runtime.ContentTypeProtobuf
+)
+
+// reviewCodecs decodes TokenReviews strictly: unknown and duplicate fields are errors
+var reviewCodecs = func() serializer.CodecFactory {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(authv1.AddToScheme(scheme))
+	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Version: "v1"})
+	return serializer.NewCodecFactory(scheme, serializer.EnableStrict)
+}()
+
+var tokenReviewKind = authv1.SchemeGroupVersion.WithKind("TokenReview")
+
+// serializerFor returns the serializer of a supported media type
+func serializerFor(mediaType string) (runtime.SerializerInfo, bool) {
+	if mediaType != MediaTypeJSON && mediaType != MediaTypeProtobuf {
+		return runtime.SerializerInfo{}, false
+	}
+	return runtime.SerializerInfoForMediaType(reviewCodecs.SupportedMediaTypes(), mediaType)
+}
+
+// requestMediaType returns the media type of the request body, JSON if unset
+func requestMediaType(r *http.Request) (string, *Error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return MediaTypeJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if _, ok := serializerFor(mediaType); err != nil || !ok {
+		return "", newError(CodeUnsupportedMediaType, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Content-Type must be %s or %s", MediaTypeJSON, MediaTypeProtobuf), nil)
+	}
+	return mediaType, nil
+}
+
+// responseMediaType returns the first supported media type in the Accept header, or
+// the request's
+func responseMediaType(r *http.Request, requestType string) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if _, ok := serializerFor(mediaType); ok {
+			return mediaType
+		}
+	}
+	return requestType
+}
+
+// decodeTokenReview reads an authentication.k8s.io/v1 TokenReview of at most maxBytes
+func decodeTokenReview(w http.ResponseWriter, r *http.Request, mediaType string, maxBytes int64) (*authv1.TokenReview, *Error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		return nil, bodyError(err, maxBytes)
+	}
+
+	info, _ := serializerFor(mediaType)
+	decoder := info.StrictSerializer
+	if decoder == nil {
+		decoder = info.Serializer
+	}
+	// The kind defaults to TokenReview if unset, as with kube-apiserver, but any other
+	// kind or version is rejected
+	obj, gvk, err := decoder.Decode(data, &tokenReviewKind, nil)
+	if err != nil {
+		return nil, newError(CodeInvalidRequest, http.StatusBadRequest, "invalid request body: "+err.Error(), err)
+	}
+	tr, ok := obj.(*authv1.TokenReview)
+	if !ok || *gvk != tokenReviewKind {
+		return nil, newError(CodeInvalidRequest, http.StatusBadRequest,
+			fmt.Sprintf("expected apiVersion %s and kind TokenReview, got %q and %q", authv1.SchemeGroupVersion, gvk.GroupVersion(), gvk.Kind), nil)
+	}
+	return tr, nil
+}
+
+// encodeObject writes obj in the given media type
+func encodeObject(w http.ResponseWriter, mediaType string, code int, obj runtime.Object) {
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(code)
+	if mediaType == MediaTypeProtobuf {
+		info, _ := serializerFor(mediaType)
+		info.Serializer.Encode(obj, w)
+		return
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+// statusFor returns the Status kube-apiserver would respond with for an error
+func statusFor(err *Error) *metav1.Status {
+	return &metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Message:  err.Message,
+		Reason:   statusReason(err.Status),
+		Code:     int32(err.Status),
+	}
+}
+
+func statusReason(code int) metav1.StatusReason {
+	switch code {
+	case http.StatusBadRequest:
+		return metav1.StatusReasonBadRequest
+	case http.StatusUnauthorized:
+		return metav1.StatusReasonUnauthorized
+	case http.StatusForbidden:
+		return metav1.StatusReasonForbidden
+	case http.StatusRequestEntityTooLarge:
+		return metav1.StatusReasonRequestEntityTooLarge
+	case http.StatusUnsupportedMediaType:
+		return metav1.StatusReasonUnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return metav1.StatusReasonTooManyRequests
+	}
+	return metav1.StatusReasonInternalError
+}
+
+// bodyError is the Error of a request body that couldn't be read or decoded: too large
+// for http.MaxBytesReader, or invalid
+func bodyError(err error, maxBytes int64) *Error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return newError(CodeRequestTooLarge, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", maxBytes), nil)
+	}
+	return newError(CodeInvalidRequest, http.StatusBadRequest, "invalid request body", err)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Error codes returned to clients. A code always comes with the same public message,
+// so clients may match on either; neither ever includes internal detail such as API
+// server URLs or client-go errors.
+const (
+	CodeInvalidRequest        = "invalid_request"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeUnsupportedMediaType  = "unsupported_media_type"
+	CodeNotConfigured         = "not_configured"
+	CodeUnauthenticatedCaller = "unauthenticated_caller"
+	CodeUnauthorizedCaller    = "unauthorized_caller"
+	CodeRateLimited           = "rate_limited"
+	CodeDetectionFailed       = "detection_failed"
+	CodeRemoteUnavailable     = "remote_unavailable"
+	CodeRemoteRejected        = "remote_rejected"
+	CodeTokenRejected         = "token_rejected"
+	CodeNotServiceAccount     = "not_service_account"
+	CodeSubjectNotAllowed     = "subject_not_allowed"
+)
+
+// Response headers set on errors: the code, and the request ID under which the
+// detail was logged
+const (
+	ErrorCodeHeader = "X-Error-Code"
+	RequestIDHeader = "X-Request-Id"
+)
+
+// Error is a failure to serve a request. Message is safe to return to any caller; Err
+// holds the detail, which is only logged.
+type Error struct {
+	Code    string
+	Status  int // HTTP status
+	Message string
+	Err     error
+	// RetryAfter is when a rate limited request may be retried
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(code string, status int, message string, err error) *Error {
+	return &Error{Code: code, Status: status, Message: message, Err: err}
+}
+
+// Public messages of errors reviewing a token
+const (
+	msgDetectionFailed   = "token not valid for any configured cluster"
+	msgRemoteUnavailable = "source cluster is unavailable"
+	msgRemoteRejected    = "source cluster rejected the review request"
+	msgTokenRejected     = "token not authenticated"
+	msgNotServiceAccount = "token is not a ServiceAccount token"
+)
+
+var errNotConfiguredForAuth = newError(CodeNotConfigured, http.StatusInternalServerError, "server not configured for authentication", nil)
+
+// forwardError classifies a failed TokenReview call. An API server that answers with
+// a client error refuses our credentials or request; anything else is an outage.
+func forwardError(err error) *Error {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		if code := status.Status().Code; code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+			return newError(CodeRemoteRejected, http.StatusBadGateway, msgRemoteRejected, err)
+		}
+	}
+	return newError(CodeRemoteUnavailable, http.StatusBadGateway, msgRemoteUnavailable, err)
+}
+
+// rejectionStatus returns the status of a response rejecting a token that failed
+// review: status, unless the review was rate limited, which is reported as such
+func rejectionStatus(w http.ResponseWriter, r *http.Request, err *Error, status int) int {
+	if err.Code != CodeRateLimited {
+		return status
+	}
+	setErrorHeaders(w, r, err)
+	return err.Status
+}
+
+// logError logs the detail of an error under the request's ID
+func logError(ctx context.Context, what string, err *Error) {
+	if err.Err == nil {
+		log.Printf("%s failed [%s] (request %s): %s", what, err.Code, middleware.GetReqID(ctx), err.Message)
+		return
+	}
+	log.Printf("%s failed [%s] (request %s): %v", what, err.Code, middleware.GetReqID(ctx), err.Err)
+}
+
+// setErrorHeaders sets the headers identifying an error response
+func setErrorHeaders(w http.ResponseWriter, r *http.Request, err *Error) {
+	w.Header().Set(ErrorCodeHeader, err.Code)
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(err.RetryAfter))
+	}
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		w.Header().Set(RequestIDHeader, id)
+	}
+}
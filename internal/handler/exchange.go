@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rophy/kube-federated-auth/internal/audit"
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/issuer"
+)
+
+// OAuth 2.0 Token Exchange (RFC 8693) identifiers
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	// TokenTypeServiceAccount requests a TokenRequest-minted token for a ServiceAccount
+	// mapped in exchange.mappings, named by the resource parameter
+	TokenTypeServiceAccount = "urn:kube-federated-auth:token-type:serviceaccount"
+)
+
+// OAuth 2.0 error codes (RFC 6749 section 5.2, RFC 8693 section 2.2.2)
+const (
+	oauthInvalidRequest         = "invalid_request"
+	oauthInvalidGrant           = "invalid_grant"
+	oauthInvalidTarget          = "invalid_target"
+	oauthUnsupportedGrantType   = "unsupported_grant_type"
+	oauthServerError            = "server_error"
+	oauthTemporarilyUnavailable = "temporarily_unavailable"
+)
+
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+}
+
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// TokenExchangeHandler implements POST /token (RFC 8693). A ServiceAccount token from any
+// configured cluster is exchanged for either a token signed by the federated issuer or a
+// TokenRequest-minted token for a ServiceAccount mapped in the exchange policy.
+type TokenExchangeHandler struct {
+	reviewer *TokenReviewHandler
+	issuer   *tokenIssuer // nil if issuer is not configured
+	config   *config.Config
+	minter   *tokenMinter
+	now      func() time.Time
+}
+
+func NewTokenExchangeHandler(reviewer *TokenReviewHandler, iss *issuer.Issuer, cfg *config.Config, auditLog *audit.Log) *TokenExchangeHandler {
+	h := &TokenExchangeHandler{
+		reviewer: reviewer,
+		config:   cfg,
+		minter:   &tokenMinter{clients: reviewer.clients, exchange: cfg.Exchange, audit: auditLog},
+		now:      time.Now,
+	}
+	if iss != nil {
+		h.issuer = newTokenIssuer(iss, cfg.Issuer)
+	}
+	return h
+}
+
+func (h *TokenExchangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Clients aren't authenticated, so they are limited by address
+	if err := h.reviewer.limitCaller(clientAddress(r), nil, 1); err != nil {
+		logError(r.Context(), "Token exchange", err)
+		h.writeError(w, rejectionStatus(w, r, err, err.Status), oauthTemporarilyUnavailable, err.Message)
+		return
+	}
+
+	maxBytes := h.reviewer.config.GetMaxBodyBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	if err := r.ParseForm(); err != nil {
+		bodyErr := bodyError(err, maxBytes)
+		h.writeError(w, bodyErr.Status, oauthInvalidRequest, bodyErr.Message)
+		return
+	}
+	form := r.PostForm
+
+	if form.Get("grant_type") != GrantTypeTokenExchange {
+		h.writeError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "grant_type must be "+GrantTypeTokenExchange)
+		return
+	}
+	subjectToken := form.Get("subject_token")
+	if subjectToken == "" {
+		h.writeError(w, http.StatusBadRequest, oauthInvalidRequest, "subject_token is required")
+		return
+	}
+	if err := h.reviewer.checkToken(subjectToken); err != nil {
+		h.writeError(w, err.Status, oauthInvalidRequest, "subject_token: "+err.Message)
+		return
+	}
+	switch form.Get("subject_token_type") {
+	case TokenTypeJWT, TokenTypeAccessToken:
+	default:
+		h.writeError(w, http.StatusBadRequest, oauthInvalidRequest, "subject_token_type must be "+TokenTypeJWT+" or "+TokenTypeAccessToken)
+		return
+	}
+	if form.Get("actor_token") != "" {
+		h.writeError(w, http.StatusBadRequest, oauthInvalidRequest, "actor_token is not supported")
+		return
+	}
+
+	requestedType := form.Get("requested_token_type")
+	if requestedType == "" {
+		requestedType = TokenTypeJWT
+	}
+	// The subject token must be bound to the audiences of the requested token's policy
+	var subjectAudiences []string
+	switch requestedType {
+	case TokenTypeJWT:
+		if h.issuer == nil {
+			h.writeError(w, http.StatusBadRequest, oauthInvalidRequest, "issuer is not configured")
+			return
+		}
+		subjectAudiences = h.config.Issuer.SubjectAudiences
+	case TokenTypeServiceAccount:
+		if h.config.Exchange == nil {
+			h.writeError(w, http.StatusBadRequest, oauthInvalidRequest, "no exchange mappings are configured")
+			return
+		}
+		subjectAudiences = h.config.Exchange.SubjectAudiences
+	default:
+		h.writeError(w, http.StatusBadRequest, oauthInvalidRequest, "unsupported requested_token_type")
+		return
+	}
+
+	id, err := h.reviewer.reviewServiceAccount(r.Context(), subjectToken, subjectAudiences)
+	if err != nil {
+		if err.Code == CodeRateLimited {
+			h.writeError(w, rejectionStatus(w, r, err, http.StatusBadRequest), oauthTemporarilyUnavailable, err.Error())
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+		return
+	}
+
+	if requestedType == TokenTypeServiceAccount {
+		h.mintServiceAccountToken(w, r, id, form["audience"], form.Get("resource"))
+		return
+	}
+	h.issueFederatedToken(w, id, form["audience"])
+}
+
+// issueFederatedToken responds with a token signed by the federated issuer
+func (h *TokenExchangeHandler) issueFederatedToken(w http.ResponseWriter, id *serviceAccountIdentity, audiences []string) {
+	issued, err := h.issuer.issue(id, audiences)
+	if err != nil {
+		h.writeMintError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, TokenExchangeResponse{
+		AccessToken:     issued.token,
+		IssuedTokenType: TokenTypeJWT,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(issued.expiry.Sub(issued.issuedAt).Seconds()),
+	})
+}
+
+// mintServiceAccountToken responds with a TokenRequest-minted token for the ServiceAccount
+// the subject is mapped to. resource selects the target ("cluster/namespace/serviceaccount")
+// and may be omitted when exactly one mapping applies.
+func (h *TokenExchangeHandler) mintServiceAccountToken(w http.ResponseWriter, r *http.Request, id *serviceAccountIdentity, audiences []string, resource string) {
+	result, _, err := h.minter.mint(r.Context(), id, resource, audiences, 0)
+	if err != nil {
+		h.writeMintError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, TokenExchangeResponse{
+		AccessToken:     result.Status.Token,
+		IssuedTokenType: TokenTypeJWT,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(result.Status.ExpirationTimestamp.Sub(h.now()).Seconds()),
+	})
+}
+
+// writeMintError responds with invalid_target to a request the policy denies
+func (h *TokenExchangeHandler) writeMintError(w http.ResponseWriter, err *mintError) {
+	if err.denied {
+		h.writeError(w, http.StatusBadRequest, oauthInvalidTarget, err.Error())
+		return
+	}
+	h.writeError(w, http.StatusInternalServerError, oauthServerError, err.Error())
+}
+
+func (h *TokenExchangeHandler) writeError(w http.ResponseWriter, code int, oauthError, description string) {
+	h.writeJSON(w, code, OAuthErrorResponse{Error: oauthError, ErrorDescription: description})
+}
+
+func (h *TokenExchangeHandler) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	// Token responses must not be cached (RFC 6749 section 5.1)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/rophy/kube-federated-auth/internal/oidc"
+)
+
+// ExplainHeader requests a trace in the TokenReview response. The explain query
+// parameter does the same.
+const ExplainHeader = "X-Explain"
+
+// ReviewTrace explains how a TokenReview was resolved. It names the configured clusters
+// and why the token failed against each, so it is only returned to admin callers.
+type ReviewTrace struct {
+	// Rejected is why the token was rejected before trying any cluster, e.g. "malformed"
+	Rejected        string           `json:"rejected,omitempty"`
+	Clusters        []ClusterAttempt `json:"clusters"`
+	DetectedCluster string           `json:"detected_cluster,omitempty"`
+	Forward         *ForwardAttempt  `json:"forward,omitempty"`
+	Steps           []TraceStep      `json:"steps"`
+
+	start time.Time
+}
+
+// ClusterAttempt is the verification of the token against one cluster's JWKS
+type ClusterAttempt struct {
+	Cluster string `json:"cluster"`
+	// Result is "verified", or why verification failed: one of the oidc.Reason* values,
+	// or "failed" if the verifier doesn't classify its errors
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// Skipped is true if the cluster was ruled out without verifying, by the token's issuer
+	Skipped bool `json:"skipped,omitempty"`
+	// Cache is "hit" if the cluster's verifier was already loaded, "miss" if discovery
+	// and JWKS were fetched for this request
+	Cache     string  `json:"cache,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// ForwardAttempt is the TokenReview sent to the detected cluster
+type ForwardAttempt struct {
+	Cluster   string  `json:"cluster"`
+	Target    string  `json:"target,omitempty"` // API server URL
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// TraceStep is the latency of one step of the review
+type TraceStep struct {
+	Name      string  `json:"name"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// explainedTokenReview is a TokenReview with the trace alongside its fields
+type explainedTokenReview struct {
+	*authv1.TokenReview
+	Trace *ReviewTrace `json:"trace,omitempty"`
+}
+
+// verifierCache is implemented by verifiers that cache per-cluster state
+type verifierCache interface {
+	HasVerifier(clusterName string) bool
+}
+
+// keyIDLister is implemented by verifiers that can list a cluster's signing keys
+type keyIDLister interface {
+	KeyIDs(ctx context.Context, clusterName string) ([]string, error)
+}
+
+// restConfigs is implemented by cluster clients that expose their connection settings
+type restConfigs interface {
+	RESTConfig(clusterName string) (*rest.Config, error)
+}
+
+// wantsExplain reports whether the caller asked for a trace
+func wantsExplain(r *http.Request) bool {
+	for _, v := range []string{r.Header.Get(ExplainHeader), r.URL.Query().Get("explain")} {
+		if explain, err := strconv.ParseBool(v); err == nil && explain {
+			return true
+		}
+	}
+	return false
+}
+
+func newReviewTrace() *ReviewTrace {
+	return &ReviewTrace{Clusters: []ClusterAttempt{}, start: time.Now()}
+}
+
+// The methods below are no-ops on a nil trace, so the review path calls them
+// unconditionally.
+
+// step records the latency of a step started at start
+func (t *ReviewTrace) step(name string, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, TraceStep{Name: name, LatencyMS: millis(time.Since(start))})
+}
+
+// cacheState returns whether verifier already holds state for a cluster
+func (t *ReviewTrace) cacheState(verifier TokenVerifier, cluster string) string {
+	if t == nil {
+		return ""
+	}
+	cache, ok := verifier.(verifierCache)
+	if !ok {
+		return ""
+	}
+	if cache.HasVerifier(cluster) {
+		return "hit"
+	}
+	return "miss"
+}
+
+// verification records the result of verifying token against a cluster
+func (t *ReviewTrace) verification(ctx context.Context, verifier TokenVerifier, cluster, token, cache string, start time.Time, err error) {
+	if t == nil {
+		return
+	}
+	attempt := ClusterAttempt{Cluster: cluster, Result: "verified", Cache: cache, LatencyMS: millis(time.Since(start))}
+	if err != nil {
+		attempt.Result = oidc.FailureReason(err)
+		if attempt.Result == "" {
+			attempt.Result = "failed"
+		}
+		attempt.Error = err.Error()
+		if attempt.Result == oidc.ReasonBadSignature && unknownKeyID(ctx, verifier, cluster, token) {
+			attempt.Result = oidc.ReasonUnknownKeyID
+		}
+	} else {
+		t.DetectedCluster = cluster
+	}
+	t.Clusters = append(t.Clusters, attempt)
+}
+
+// rejected records a token rejected before trying any cluster
+func (t *ReviewTrace) rejected(err error) {
+	if t == nil {
+		return
+	}
+	t.Rejected = oidc.FailureReason(err)
+	if t.Rejected == "" {
+		t.Rejected = "failed"
+	}
+}
+
+// skipped records the clusters that aren't candidates
+func (t *ReviewTrace) skipped(clusters, candidates []string) {
+	if t == nil {
+		return
+	}
+	for _, cluster := range clusters {
+		if !slices.Contains(candidates, cluster) {
+			t.Clusters = append(t.Clusters, ClusterAttempt{Cluster: cluster, Result: oidc.ReasonIssuerMismatch, Skipped: true})
+		}
+	}
+}
+
+// forward records the TokenReview forwarded to a cluster
+func (t *ReviewTrace) forward(clients ClusterClients, cluster string, start time.Time, err error) {
+	if t == nil {
+		return
+	}
+	t.Forward = &ForwardAttempt{Cluster: cluster, LatencyMS: millis(time.Since(start))}
+	if rc, ok := clients.(restConfigs); ok {
+		if restConfig, rcErr := rc.RESTConfig(cluster); rcErr == nil {
+			t.Forward.Target = targetHost(restConfig.Host)
+		}
+	}
+	if err != nil {
+		t.Forward.Error = err.Error()
+	}
+}
+
+// finish records the total latency
+func (t *ReviewTrace) finish() *ReviewTrace {
+	if t == nil {
+		return nil
+	}
+	t.step("total", t.start)
+	return t
+}
+
+// unknownKeyID reports whether a signature failed because the token's kid is not in the
+// cluster's JWKS, as opposed to a known key not matching. Only checked when explaining,
+// as it re-reads the JWKS.
+func unknownKeyID(ctx context.Context, verifier TokenVerifier, cluster, token string) bool {
+	lister, ok := verifier.(keyIDLister)
+	if !ok {
+		return false
+	}
+	info, err := oidc.ParseUnverified(token)
+	if err != nil || info.KeyID == "" {
+		return false
+	}
+	kids, err := lister.KeyIDs(ctx, cluster)
+	return err == nil && !slices.Contains(kids, info.KeyID)
+}
+
+// targetHost strips credentials and paths from an API server URL for display
+func targetHost(host string) string {
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return host
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
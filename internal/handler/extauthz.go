@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/go-chi/chi/v5/middleware"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+// Headers of the identity of allowed requests, set on the request sent upstream
+const (
+	HeaderFederatedCluster = "x-federated-cluster"
+	HeaderFederatedUser    = "x-federated-user"
+)
+
+const msgSubjectNotAllowed = "subject is not allowed"
+
+// ExtAuthzHandler implements the Envoy ext_authz check API, over gRPC and HTTP: a
+// request is allowed if its bearer token is a ServiceAccount token its source cluster
+// authenticates, and the identity is passed upstream in the x-federated-* headers.
+// Envoy is trusted, so checks aren't subject to authorized_clients; the API is served
+// on its own ports, and checks are rate limited per peer like unauthenticated callers.
+type ExtAuthzHandler struct {
+	authv3.UnimplementedAuthorizationServer
+
+	reviewer *TokenReviewHandler
+	settings *config.ExtAuthzSettings
+}
+
+func NewExtAuthzHandler(reviewer *TokenReviewHandler, settings *config.ExtAuthzSettings) *ExtAuthzHandler {
+	return &ExtAuthzHandler{reviewer: reviewer, settings: settings}
+}
+
+// Check is the gRPC check API. Denied requests get an error status, and the HTTP
+// response Envoy returns to the client.
+func (h *ExtAuthzHandler) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+	id, err := h.check(ctx, peerAddress(ctx), headers["authorization"])
+	if err != nil {
+		status := deniedStatus(err)
+		denied := &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode(status)},
+			Body:   err.Message,
+		}
+		headers := deniedHeaders(ctx, err, status)
+		for _, key := range slices.Sorted(maps.Keys(headers)) {
+			denied.Headers = append(denied.Headers, headerOption(key, headers[key]))
+		}
+		return &authv3.CheckResponse{
+			Status:       &rpcstatus.Status{Code: int32(grpcCode(status)), Message: err.Message},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied},
+		}, nil
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+			Headers: []*corev3.HeaderValueOption{
+				headerOption(HeaderFederatedCluster, id.cluster),
+				headerOption(HeaderFederatedUser, id.user.Username),
+			},
+		}},
+	}, nil
+}
+
+// ServeHTTP is the HTTP check API, which gets the client's request under any path.
+// Allowed requests get 200 with the identity headers, to be listed in
+// allowed_upstream_headers; denied requests get the response for the client.
+func (h *ExtAuthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := h.check(r.Context(), clientAddress(r), r.Header.Get("Authorization"))
+	if err != nil {
+		status := deniedStatus(err)
+		for key, value := range deniedHeaders(r.Context(), err, status) {
+			w.Header().Set(key, value)
+		}
+		http.Error(w, err.Message, status)
+		return
+	}
+
+	w.Header().Set(HeaderFederatedCluster, id.cluster)
+	w.Header().Set(HeaderFederatedUser, id.user.Username)
+	w.WriteHeader(http.StatusOK)
+}
+
+// check reviews the bearer token of a request for the configured audiences. address
+// is the peer that asked, usually an Envoy proxy.
+func (h *ExtAuthzHandler) check(ctx context.Context, address, authHeader string) (*serviceAccountIdentity, *Error) {
+	if h.reviewer.verifier == nil || h.reviewer.config == nil {
+		return nil, errNotConfiguredForAuth
+	}
+	if err := h.reviewer.limitCaller(address, nil, 1); err != nil {
+		logError(ctx, "Ext authz", err)
+		return nil, err
+	}
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok || token == "" {
+		return nil, newError(CodeInvalidRequest, http.StatusUnauthorized, "bearer token required", nil)
+	}
+	if err := h.reviewer.checkToken(token); err != nil {
+		return nil, err
+	}
+
+	id, err := h.reviewer.reviewServiceAccount(ctx, token, h.settings.Audiences)
+	if err != nil {
+		return nil, err
+	}
+	if !h.settings.IsAllowedSubject(id.cluster, id.namespace, id.serviceAccount) {
+		log.Printf("Ext authz denied for %s/%s/%s", id.cluster, id.namespace, id.serviceAccount)
+		return nil, newError(CodeSubjectNotAllowed, http.StatusForbidden, msgSubjectNotAllowed, nil)
+	}
+	return id, nil
+}
+
+// deniedStatus is the HTTP status of a denied request: the token isn't authenticated,
+// its subject isn't allowed, it was rate limited, or it couldn't be reviewed
+func deniedStatus(err *Error) int {
+	switch {
+	case err.Status == http.StatusForbidden || err.Status == http.StatusTooManyRequests:
+		return err.Status
+	case err.Status >= 500:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// deniedHeaders are the headers of a denied request's response, as set by
+// setErrorHeaders
+func deniedHeaders(ctx context.Context, err *Error, status int) map[string]string {
+	headers := map[string]string{ErrorCodeHeader: err.Code}
+	if status == http.StatusUnauthorized {
+		headers["WWW-Authenticate"] = `Bearer error="invalid_token"`
+	}
+	if err.RetryAfter > 0 {
+		headers["Retry-After"] = retryAfterSeconds(err.RetryAfter)
+	}
+	if id := middleware.GetReqID(ctx); id != "" {
+		headers[RequestIDHeader] = id
+	}
+	return headers
+}
+
+// headerOption sets a header, replacing any value sent by the client
+func headerOption(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	authv1 "k8s.io/api/authentication/v1"
+
+	federatedauthv1 "github.com/rophy/kube-federated-auth/api/federatedauth/v1"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail of gRPC errors, whose reason is the
+// error code
+const ErrorDomain = "kube-federated-auth"
+
+// GRPCServer serves the TokenReview API over gRPC. Reviews are detected, forwarded and
+// authorized exactly as by the HTTP endpoints; the caller's token is read from the
+// "authorization" metadata.
+type GRPCServer struct {
+	federatedauthv1.UnimplementedTokenReviewServiceServer
+
+	reviewer *TokenReviewHandler
+	clusters *ClustersHandler
+}
+
+func NewGRPCServer(reviewer *TokenReviewHandler, clusters *ClustersHandler) *GRPCServer {
+	return &GRPCServer{reviewer: reviewer, clusters: clusters}
+}
+
+// Review reviews one token. A token that isn't authenticated is reported in the status,
+// as in a TokenReview; the call itself fails if the caller is refused.
+func (s *GRPCServer) Review(ctx context.Context, req *federatedauthv1.ReviewRequest) (*federatedauthv1.ReviewResponse, error) {
+	h := s.reviewer
+	caller, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := h.limitCaller(peerAddress(ctx), caller, 1); err != nil {
+		logError(ctx, "TokenReview", err)
+		return nil, grpcError(err)
+	}
+	if err := h.checkToken(req.GetToken()); err != nil {
+		return nil, grpcError(err)
+	}
+
+	if h.verifier == nil || h.config == nil {
+		return &federatedauthv1.ReviewResponse{Status: reviewFailure(newError(CodeNotConfigured, http.StatusOK, "server not configured", nil))}, nil
+	}
+
+	tr := &authv1.TokenReview{Spec: authv1.TokenReviewSpec{Token: req.GetToken(), Audiences: req.GetAudiences()}}
+	result, err := h.review(ctx, tr, nil)
+	if err != nil {
+		if err.Code == CodeRateLimited {
+			return nil, grpcError(err)
+		}
+		return &federatedauthv1.ReviewResponse{Status: reviewFailure(err)}, nil
+	}
+	return &federatedauthv1.ReviewResponse{Status: reviewStatus(result.status.Status)}, nil
+}
+
+// BatchReview reviews a list of tokens like the batch endpoint, returning their statuses
+// in order
+func (s *GRPCServer) BatchReview(ctx context.Context, req *federatedauthv1.BatchReviewRequest) (*federatedauthv1.BatchReviewResponse, error) {
+	h := s.reviewer
+	caller, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := h.checkBatch(len(req.GetReviews())); err != nil {
+		return nil, grpcError(err)
+	}
+	if err := h.limitCaller(peerAddress(ctx), caller, len(req.GetReviews())); err != nil {
+		logError(ctx, "Batch TokenReview", err)
+		return nil, grpcError(err)
+	}
+
+	specs := make([]authv1.TokenReviewSpec, len(req.GetReviews()))
+	for i, review := range req.GetReviews() {
+		specs[i] = authv1.TokenReviewSpec{Token: review.GetToken(), Audiences: review.GetAudiences()}
+	}
+
+	resp := &federatedauthv1.BatchReviewResponse{Statuses: make([]*federatedauthv1.ReviewStatus, len(specs))}
+	authenticated := 0
+	for i, batchStatus := range h.reviewBatch(ctx, specs) {
+		resp.Statuses[i] = reviewStatus(batchStatus.TokenReviewStatus)
+		resp.Statuses[i].Code = batchStatus.Code
+		if batchStatus.Authenticated {
+			authenticated++
+		}
+	}
+	log.Printf("Batch TokenReview (request %s): %d of %d tokens authenticated", middleware.GetReqID(ctx), authenticated, len(specs))
+	return resp, nil
+}
+
+// ListClusters lists the configured clusters, as GET /clusters
+func (s *GRPCServer) ListClusters(ctx context.Context, req *federatedauthv1.ListClustersRequest) (*federatedauthv1.ListClustersResponse, error) {
+	var resp federatedauthv1.ListClustersResponse
+	for _, info := range s.clusters.list() {
+		cluster := &federatedauthv1.ClusterInfo{
+			Name:                  info.Name,
+			Issuer:                info.Issuer,
+			ApiServer:             info.APIServer,
+			InsecureSkipTlsVerify: info.Insecure,
+		}
+		if info.TokenStatus != nil {
+			cluster.TokenStatus = &federatedauthv1.TokenStatus{
+				Type:      info.TokenStatus.Type,
+				ExpiresAt: info.TokenStatus.ExpiresAt,
+				ExpiresIn: info.TokenStatus.ExpiresIn,
+				Status:    info.TokenStatus.Status,
+			}
+		}
+		resp.Clusters = append(resp.Clusters, cluster)
+	}
+	return &resp, nil
+}
+
+// authenticate authenticates the caller if authorized_clients is set, returning nil
+// otherwise
+func (s *GRPCServer) authenticate(ctx context.Context) (*callerIdentity, *Error) {
+	h := s.reviewer
+	if h.config == nil || !h.config.HasAuthorizedClients() {
+		return nil, nil
+	}
+	var authHeader string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		authHeader = values[0]
+	}
+	return authenticateBearer(ctx, authHeader, h.verifier, h.config, h.config.IsAuthorizedClient)
+}
+
+// RequestIDInterceptor gives each call a request ID, as middleware.RequestID does for
+// HTTP requests, and returns it in the "x-request-id" header
+func RequestIDInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	reqID := fmt.Sprintf("%06d", middleware.NextRequestID())
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, reqID)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", reqID))
+	return handler(ctx, req)
+}
+
+// grpcError converts err to a gRPC status, with its code as the reason of an ErrorInfo
+// detail and a RetryInfo detail if rate limited
+func grpcError(err *Error) error {
+	st := status.New(grpcCode(err.Status), err.Message)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: err.Code, Domain: ErrorDomain}}
+	if err.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(err.RetryAfter)})
+	}
+	if withDetails, detailErr := st.WithDetails(details...); detailErr == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// grpcCode is the gRPC code of an HTTP status
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// reviewStatus converts a TokenReview status, taking the cluster from the user's extra
+func reviewStatus(s authv1.TokenReviewStatus) *federatedauthv1.ReviewStatus {
+	rs := &federatedauthv1.ReviewStatus{
+		Authenticated: s.Authenticated,
+		Audiences:     s.Audiences,
+		Error:         s.Error,
+	}
+	if s.Authenticated {
+		rs.User = &federatedauthv1.UserInfo{
+			Username: s.User.Username,
+			Uid:      s.User.UID,
+			Groups:   s.User.Groups,
+		}
+		if len(s.User.Extra) > 0 {
+			rs.User.Extra = make(map[string]*federatedauthv1.ExtraValue, len(s.User.Extra))
+			for key, values := range s.User.Extra {
+				rs.User.Extra[key] = &federatedauthv1.ExtraValue{Values: values}
+			}
+		}
+		if cluster := s.User.Extra[ExtraKeyClusterName]; len(cluster) > 0 {
+			rs.Cluster = cluster[0]
+		}
+	}
+	return rs
+}
+
+// reviewFailure is the status of a token that couldn't be reviewed
+func reviewFailure(err *Error) *federatedauthv1.ReviewStatus {
+	return &federatedauthv1.ReviewStatus{Error: err.Message, Code: err.Code}
+}
+
+// peerAddress identifies gRPC callers that aren't authenticated
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
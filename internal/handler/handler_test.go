@@ -1,17 +1,60 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
-
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	authv1 "k8s.io/api/authentication/v1"
-
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	federatedauthv1 "github.com/rophy/kube-federated-auth/api/federatedauth/v1"
+	"github.com/rophy/kube-federated-auth/internal/audit"
 	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+	"github.com/rophy/kube-federated-auth/internal/issuer"
 	"github.com/rophy/kube-federated-auth/internal/oidc"
 )
 
@@ -114,15 +157,15 @@ func TestTokenReview_InvalidJSON(t *testing.T) {
 		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 	}
 
-	var resp authv1.TokenReview
+	var resp metav1.Status
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
 
-	if resp.Status.Authenticated {
-		t.Error("expected authenticated = false")
+	if resp.Kind != "Status" || resp.Status != metav1.StatusFailure || resp.Reason != metav1.StatusReasonBadRequest || resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected Status %+v", resp)
 	}
-	if resp.Status.Error == "" {
+	if resp.Message == "" {
 		t.Error("expected error message")
 	}
 }
@@ -140,16 +183,13 @@ func TestTokenReview_MissingToken(t *testing.T) {
 		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 	}
 
-	var resp authv1.TokenReview
+	var resp metav1.Status
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
 
-	if resp.Status.Authenticated {
-		t.Error("expected authenticated = false")
-	}
-	if resp.Status.Error != "token is required" {
-		t.Errorf("error = %q, want %q", resp.Status.Error, "token is required")
+	if resp.Message != "token is required" {
+		t.Errorf("message = %q, want %q", resp.Message, "token is required")
 	}
 }
 
@@ -380,3 +420,1861 @@ func TestExtractIdentity_MissingFields(t *testing.T) {
 		t.Errorf("serviceAccount = %q, want empty", sa)
 	}
 }
+
+// mockCache implements VerifierCache for testing.
+type mockCache struct {
+	invalidated []string
+	purged      bool
+}
+
+func (m *mockCache) InvalidateVerifier(clusterName string) {
+	m.invalidated = append(m.invalidated, clusterName)
+}
+
+func (m *mockCache) InvalidateAll() {
+	m.purged = true
+}
+
+// mockRenewer implements CredentialRenewer for testing.
+type mockRenewer struct {
+	renewed []string
+	err     error
+	history []credentials.RenewalAttempt
+}
+
+func (m *mockRenewer) RenewNow(ctx context.Context, cluster string) error {
+	m.renewed = append(m.renewed, cluster)
+	return m.err
+}
+
+func (m *mockRenewer) History(limit int) []credentials.RenewalAttempt {
+	if limit < len(m.history) {
+		return m.history[:limit]
+	}
+	return m.history
+}
+
+func adminTestConfig() *config.Config {
+	return &config.Config{
+		AdminClients: []string{"cluster-a/kube-federated-auth/operator"},
+		Clusters: map[string]config.ClusterConfig{
+			"cluster-a": {Issuer: "https://a.example.com"},
+			"cluster-b": {Issuer: "https://b.example.com", APIServer: "https://192.168.1.100:6443"},
+		},
+	}
+}
+
+func adminTestVerifier() *mockVerifier {
+	identity := func(sa string) *oidc.Claims {
+		return &oidc.Claims{
+			Cluster: "cluster-a",
+			Kubernetes: map[string]any{
+				"namespace":      "kube-federated-auth",
+				"serviceaccount": map[string]any{"name": sa},
+			},
+		}
+	}
+	return &mockVerifier{claims: map[string]*oidc.Claims{
+		"admin-token": identity("operator"),
+		"other-token": identity("test-client"),
+	}}
+}
+
+func newAdminRouter(h *AdminHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(h.Authenticate)
+		r.Post("/clusters/{cluster}/renew", h.Renew)
+		r.Post("/clusters/{cluster}/invalidate", h.Invalidate)
+		r.Post("/cache/purge", h.PurgeCache)
+		r.Get("/renewals", h.Renewals)
+		r.Get("/audit", h.Audit)
+	})
+	return r
+}
+
+func TestAdmin_RequiresAdminCaller(t *testing.T) {
+	renewer := &mockRenewer{}
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), &mockCache{}, adminTestConfig(), nil, renewer, nil))
+
+	for token, want := range map[string]int{
+		"":            http.StatusUnauthorized,
+		"other-token": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/clusters/cluster-b/renew", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("token %q: status = %d, want %d", token, w.Code, want)
+		}
+	}
+
+	if len(renewer.renewed) != 0 {
+		t.Errorf("expected no renewals, got %v", renewer.renewed)
+	}
+}
+
+func TestAdmin_Renew(t *testing.T) {
+	renewer := &mockRenewer{}
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), &mockCache{}, adminTestConfig(), nil, renewer, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/clusters/cluster-b/renew", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if len(renewer.renewed) != 1 || renewer.renewed[0] != "cluster-b" {
+		t.Errorf("renewed = %v, want [cluster-b]", renewer.renewed)
+	}
+}
+
+func TestAdmin_RenewRejectsLocalAndUnknownClusters(t *testing.T) {
+	renewer := &mockRenewer{}
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), &mockCache{}, adminTestConfig(), nil, renewer, nil))
+
+	for cluster, want := range map[string]int{
+		"cluster-a": http.StatusConflict,
+		"unknown":   http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/clusters/"+cluster+"/renew", nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("cluster %s: status = %d, want %d", cluster, w.Code, want)
+		}
+	}
+}
+
+func TestAdmin_RenewFailure(t *testing.T) {
+	renewer := &mockRenewer{err: fmt.Errorf("Unauthorized")}
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), &mockCache{}, adminTestConfig(), nil, renewer, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/clusters/cluster-b/renew", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+
+	var resp AdminResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error != "Unauthorized" {
+		t.Errorf("error = %q, want %q", resp.Error, "Unauthorized")
+	}
+}
+
+func TestAdmin_InvalidateAndPurge(t *testing.T) {
+	cache := &mockCache{}
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), cache, adminTestConfig(), nil, nil, nil))
+
+	for _, path := range []string{"/admin/clusters/cluster-a/invalidate", "/admin/cache/purge"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+
+	if len(cache.invalidated) != 1 || cache.invalidated[0] != "cluster-a" {
+		t.Errorf("invalidated = %v, want [cluster-a]", cache.invalidated)
+	}
+	if !cache.purged {
+		t.Error("expected cache to be purged")
+	}
+}
+
+func TestAdmin_Renewals(t *testing.T) {
+	renewer := &mockRenewer{history: []credentials.RenewalAttempt{
+		{Cluster: "cluster-b", Trigger: credentials.TriggerManual, Success: true},
+		{Cluster: "cluster-b", Trigger: credentials.TriggerScheduled, Error: "Unauthorized"},
+	}}
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), &mockCache{}, adminTestConfig(), nil, renewer, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/renewals?limit=1", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var resp RenewalsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Renewals) != 1 || !resp.Renewals[0].Success {
+		t.Errorf("renewals = %+v, want the single most recent attempt", resp.Renewals)
+	}
+}
+
+func TestClusters_ClientCertStatus(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	notAfter := time.Now().Add(30 * 24 * time.Hour)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "integration"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	certDER, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certPath := filepath.Join(t.TempDir(), "client.crt")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+
+	cfg := &config.Config{
+		Clusters: map[string]config.ClusterConfig{
+			"on-prem": {
+				Issuer:     "https://kubernetes.internal.corp",
+				APIServer:  "https://10.0.0.1:6443",
+				ClientCert: certPath,
+				ClientKey:  "/path/to/client.key",
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	NewClustersHandler(cfg, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/clusters", nil))
+
+	var resp ClustersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	status := resp.Clusters[0].TokenStatus
+	if status == nil {
+		t.Fatal("expected token_status for client-cert cluster")
+	}
+	if status.Type != CredentialTypeClientCert || status.Status != "valid" {
+		t.Errorf("token_status = %+v, want valid client_certificate", status)
+	}
+	if status.ExpiresAt != notAfter.Format(time.RFC3339) {
+		t.Errorf("expires_at = %q, want %q", status.ExpiresAt, notAfter.Format(time.RFC3339))
+	}
+}
+
+// mockClusterClients returns fake clientsets that answer TokenReviews with review
+// and record TokenRequests in minted.
+type mockClusterClients struct {
+	review func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview
+	minted []mintedToken
+}
+
+type mintedToken struct {
+	cluster, namespace, serviceAccount string
+	spec                               authv1.TokenRequestSpec
+}
+
+func (m *mockClusterClients) Clientset(cluster string) (kubernetes.Interface, error) {
+	client := kubefake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tr := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		return true, m.review(cluster, tr), nil
+	})
+	client.PrependReactor("create", "serviceaccounts/token", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateActionImpl)
+		tr := create.GetObject().(*authv1.TokenRequest)
+		m.minted = append(m.minted, mintedToken{cluster, create.GetNamespace(), create.Name, tr.Spec})
+		tr.Status = authv1.TokenRequestStatus{
+			Token:               "minted-" + cluster,
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Duration(*tr.Spec.ExpirationSeconds) * time.Second)),
+		}
+		return true, tr, nil
+	})
+	return client, nil
+}
+
+// authenticatedReview answers every TokenReview as the given ServiceAccount on a pod.
+func authenticatedReview(namespace, serviceAccount string) func(string, *authv1.TokenReview) *authv1.TokenReview {
+	return func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+		return &authv1.TokenReview{Status: authv1.TokenReviewStatus{
+			Authenticated: true,
+			User: authv1.UserInfo{
+				Username: "system:serviceaccount:" + namespace + ":" + serviceAccount,
+				UID:      "sa-uid",
+				Extra: map[string]authv1.ExtraValue{
+					"authentication.kubernetes.io/pod-name": {"deployer-abc"},
+					"authentication.kubernetes.io/pod-uid":  {"pod-uid"},
+				},
+			},
+		}}
+	}
+}
+
+// boundReview answers TokenReviews with review if the token is reviewed for audience,
+// as the API server does for a token bound to it, and rejects it otherwise
+func boundReview(audience string, review func(string, *authv1.TokenReview) *authv1.TokenReview) func(string, *authv1.TokenReview) *authv1.TokenReview {
+	return func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+		if !slices.Contains(tr.Spec.Audiences, audience) {
+			return &authv1.TokenReview{Status: authv1.TokenReviewStatus{Error: "token audiences are invalid"}}
+		}
+		resp := review(cluster, tr)
+		resp.Status.Audiences = []string{audience}
+		return resp
+	}
+}
+
+func newIssuerRouter(t *testing.T, settings *config.IssuerSettings, clients ClusterClients) (http.Handler, *issuer.Issuer) {
+	t.Helper()
+	return newIssuerRouterWithConfig(t, &config.Config{Issuer: settings}, clients)
+}
+
+// newIssuerRouterWithConfig serves the issuer endpoints of cfg, with cluster-b
+func newIssuerRouterWithConfig(t *testing.T, cfg *config.Config, clients ClusterClients) (http.Handler, *issuer.Issuer) {
+	t.Helper()
+	settings := cfg.Issuer
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss, err := issuer.NewFromKey(settings.URL, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Clusters = map[string]config.ClusterConfig{
+		"cluster-b": {Issuer: "https://b.example.com", APIServer: "https://b.example.com:6443"},
+	}
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"subject-token": {Cluster: "cluster-b"},
+	}}
+	h := NewIssuerHandler(NewTokenReviewHandler(verifier, cfg, clients), iss, settings)
+
+	r := chi.NewRouter()
+	r.Get("/.well-known/openid-configuration", h.Discovery)
+	r.Get(issuer.JWKSPath, h.JWKS)
+	r.Post("/exchange", h.Exchange)
+	return r, iss
+}
+
+func testIssuerSettings() *config.IssuerSettings {
+	return &config.IssuerSettings{
+		URL:              "https://federated.example.com",
+		Audiences:        []string{"sts.amazonaws.com", "vault"},
+		SubjectAudiences: []string{"kube-federated-auth"},
+	}
+}
+
+func postExchange(router http.Handler, body string) (*httptest.ResponseRecorder, ExchangeResponse) {
+	req := httptest.NewRequest(http.MethodPost, "/exchange", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp ExchangeResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestIssuer_DiscoveryAndJWKS(t *testing.T) {
+	router, _ := newIssuerRouter(t, testIssuerSettings(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var discovery issuer.Discovery
+	if err := json.Unmarshal(w.Body.Bytes(), &discovery); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if discovery.Issuer != "https://federated.example.com" || discovery.JWKSURI != "https://federated.example.com/openid/v1/jwks" {
+		t.Errorf("discovery = %+v", discovery)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, issuer.JWKSPath, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var jwks struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0]["kid"] == "" || jwks.Keys[0]["d"] != nil {
+		t.Errorf("jwks = %+v, want one public key", jwks.Keys)
+	}
+}
+
+func TestIssuer_Exchange(t *testing.T) {
+	router, iss := newIssuerRouter(t, testIssuerSettings(), &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("apps", "deployer"))})
+
+	w, resp := postExchange(router, `{"token": "subject-token", "audiences": ["vault"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if resp.Subject != "cluster-b:apps:deployer" {
+		t.Errorf("subject = %q, want %q", resp.Subject, "cluster-b:apps:deployer")
+	}
+	if w.Header().Get("Deprecation") != "true" {
+		t.Error("expected /exchange to be marked deprecated")
+	}
+	if link := w.Header().Get("Link"); link != `</token>; rel="successor-version"` {
+		t.Errorf("Link = %q, want /token as the successor", link)
+	}
+
+	keySet := &gooidc.StaticKeySet{PublicKeys: []crypto.PublicKey{iss.JWKS().Keys[0].Key}}
+	verifier := gooidc.NewVerifier(iss.URL(), keySet, &gooidc.Config{ClientID: "vault", SupportedSigningAlgs: []string{"ES256"}})
+	idToken, err := verifier.Verify(context.Background(), resp.Token)
+	if err != nil {
+		t.Fatalf("issued token does not verify: %v", err)
+	}
+
+	var claims issuer.Claims
+	idToken.Claims(&claims)
+	if claims.Cluster != "cluster-b" || claims.Kubernetes == nil || claims.Kubernetes.Pod == nil || claims.Kubernetes.Pod.Name != "deployer-abc" {
+		t.Errorf("claims = %+v, want cluster and pod claims", claims)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "vault" {
+		t.Errorf("aud = %v, want [vault]", claims.Audience)
+	}
+}
+
+func TestIssuer_ExchangeRejections(t *testing.T) {
+	settings := testIssuerSettings()
+	settings.AllowedSubjects = []string{"cluster-b/apps/*"}
+
+	unauthenticated := func(string, *authv1.TokenReview) *authv1.TokenReview {
+		return &authv1.TokenReview{Status: authv1.TokenReviewStatus{Error: "token has been invalidated"}}
+	}
+
+	tests := []struct {
+		name   string
+		review func(string, *authv1.TokenReview) *authv1.TokenReview
+		body   string
+		want   int
+	}{
+		{"missing token", authenticatedReview("apps", "deployer"), `{}`, http.StatusBadRequest},
+		{"unknown audience", authenticatedReview("apps", "deployer"), `{"token": "subject-token", "audiences": ["other"]}`, http.StatusForbidden},
+		{"unknown cluster", authenticatedReview("apps", "deployer"), `{"token": "foreign-token"}`, http.StatusUnauthorized},
+		{"rejected by cluster", unauthenticated, `{"token": "subject-token"}`, http.StatusUnauthorized},
+		{"not bound to subject audience", boundReview("https://kubernetes.default.svc", authenticatedReview("apps", "deployer")), `{"token": "subject-token"}`, http.StatusUnauthorized},
+		{"subject not allowed", authenticatedReview("default", "builder"), `{"token": "subject-token"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newIssuerRouter(t, settings, &mockClusterClients{review: tt.review})
+			w, resp := postExchange(router, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if resp.Token != "" {
+				t.Error("expected no token on rejection")
+			}
+		})
+	}
+}
+
+func TestIssuer_ExchangeLimits(t *testing.T) {
+	cfg := &config.Config{
+		Issuer:     testIssuerSettings(),
+		Limits:     &config.LimitSettings{MaxBodyBytes: 512, MaxTokenBytes: 64},
+		RateLimits: &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01, Burst: 3}},
+	}
+	router, _ := newIssuerRouterWithConfig(t, cfg, &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("apps", "deployer"))})
+
+	if w, _ := postExchange(router, `{"token": "`+strings.Repeat("x", 600)+`"}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d for an oversized body, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if w, _ := postExchange(router, `{"token": "`+strings.Repeat("x", 65)+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an oversized token, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w, _ := postExchange(router, `{"token": "subject-token"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// Every request counts against the client's address, rejected or not
+	w, resp := postExchange(router, `{"token": "subject-token"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(ErrorCodeHeader) != CodeRateLimited || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, headers = %v, want 429 %s with Retry-After", w.Code, w.Header(), CodeRateLimited)
+	}
+	if resp.Token != "" {
+		t.Error("expected no token when rate limited")
+	}
+}
+
+func TestParseServiceAccountUsername(t *testing.T) {
+	tests := map[string]bool{
+		"system:serviceaccount:apps:deployer": true,
+		"system:serviceaccount:apps":          false,
+		"system:serviceaccount::deployer":     false,
+		"system:node:worker-1":                false,
+		"alice":                               false,
+	}
+	for username, wantOK := range tests {
+		if _, _, ok := parseServiceAccountUsername(username); ok != wantOK {
+			t.Errorf("parseServiceAccountUsername(%q) ok = %v, want %v", username, ok, wantOK)
+		}
+	}
+}
+
+func newTokenExchangeRouter(t *testing.T, cfg *config.Config, clients ClusterClients) http.Handler {
+	t.Helper()
+	var iss *issuer.Issuer
+	if cfg.Issuer != nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iss, err = issuer.NewFromKey(cfg.Issuer.URL, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Clusters = map[string]config.ClusterConfig{
+		"cluster-a": {Issuer: "https://a.example.com", APIServer: "https://a.example.com:6443"},
+		"cluster-b": {Issuer: "https://b.example.com", APIServer: "https://b.example.com:6443"},
+	}
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"subject-token": {Cluster: "cluster-a"},
+	}}
+
+	r := chi.NewRouter()
+	r.Post("/token", NewTokenExchangeHandler(NewTokenReviewHandler(verifier, cfg, clients), iss, cfg, nil).ServeHTTP)
+	return r
+}
+
+func postToken(router http.Handler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func exchangeForm(extra url.Values) url.Values {
+	form := url.Values{
+		"grant_type":         {GrantTypeTokenExchange},
+		"subject_token":      {"subject-token"},
+		"subject_token_type": {TokenTypeJWT},
+	}
+	for k, v := range extra {
+		form[k] = v
+	}
+	return form
+}
+
+func testExchangeSettings() *config.ExchangeSettings {
+	return &config.ExchangeSettings{
+		SubjectAudiences: []string{"kube-federated-auth"},
+		Mappings: []config.ExchangeMapping{
+			{From: "cluster-a/ci/deployer", To: "cluster-b/apps/deployer", Audiences: []string{"https://b.example.com"}, MaxExpiration: 30 * time.Minute},
+			{From: "cluster-a/ci/*", To: "cluster-b/apps/reader"},
+		},
+	}
+}
+
+func TestTokenExchange_IssuerToken(t *testing.T) {
+	cfg := &config.Config{Issuer: testIssuerSettings()}
+	router := newTokenExchangeRouter(t, cfg, &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("ci", "deployer"))})
+
+	w := postToken(router, exchangeForm(url.Values{"audience": {"vault"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("expected Cache-Control: no-store")
+	}
+
+	var resp TokenExchangeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if resp.AccessToken == "" || resp.IssuedTokenType != TokenTypeJWT || resp.TokenType != "Bearer" {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.ExpiresIn <= 0 || resp.ExpiresIn > int64(config.DefaultIssuerTokenDuration.Seconds()) {
+		t.Errorf("expires_in = %d, want within token_duration", resp.ExpiresIn)
+	}
+}
+
+func TestTokenExchange_MappedServiceAccount(t *testing.T) {
+	clients := &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("ci", "deployer"))}
+	router := newTokenExchangeRouter(t, &config.Config{Exchange: testExchangeSettings()}, clients)
+
+	w := postToken(router, exchangeForm(url.Values{
+		"requested_token_type": {TokenTypeServiceAccount},
+		"resource":             {"cluster-b/apps/deployer"},
+		"audience":             {"https://b.example.com"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var resp TokenExchangeResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.AccessToken != "minted-cluster-b" {
+		t.Errorf("access_token = %q, want token minted in cluster-b", resp.AccessToken)
+	}
+
+	if len(clients.minted) != 1 {
+		t.Fatalf("minted %d tokens, want 1", len(clients.minted))
+	}
+	minted := clients.minted[0]
+	if minted.cluster != "cluster-b" || minted.namespace != "apps" || minted.serviceAccount != "deployer" {
+		t.Errorf("minted for %s/%s/%s, want cluster-b/apps/deployer", minted.cluster, minted.namespace, minted.serviceAccount)
+	}
+	if *minted.spec.ExpirationSeconds != int64((30 * time.Minute).Seconds()) {
+		t.Errorf("expirationSeconds = %d, want max_expiration", *minted.spec.ExpirationSeconds)
+	}
+	if len(minted.spec.Audiences) != 1 || minted.spec.Audiences[0] != "https://b.example.com" {
+		t.Errorf("audiences = %v, want requested audience", minted.spec.Audiences)
+	}
+}
+
+func TestTokenExchange_Rejections(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *config.Config
+		form      url.Values
+		wantError string
+	}{
+		{
+			name:      "wrong grant type",
+			cfg:       &config.Config{Issuer: testIssuerSettings()},
+			form:      exchangeForm(url.Values{"grant_type": {"client_credentials"}}),
+			wantError: "unsupported_grant_type",
+		},
+		{
+			name:      "missing subject token type",
+			cfg:       &config.Config{Issuer: testIssuerSettings()},
+			form:      exchangeForm(url.Values{"subject_token_type": {""}}),
+			wantError: "invalid_request",
+		},
+		{
+			name:      "issuer not configured",
+			cfg:       &config.Config{Exchange: testExchangeSettings()},
+			form:      exchangeForm(nil),
+			wantError: "invalid_request",
+		},
+		{
+			name:      "unknown subject token",
+			cfg:       &config.Config{Issuer: testIssuerSettings()},
+			form:      exchangeForm(url.Values{"subject_token": {"foreign-token"}}),
+			wantError: "invalid_grant",
+		},
+		{
+			name:      "audience not allowed",
+			cfg:       &config.Config{Issuer: testIssuerSettings()},
+			form:      exchangeForm(url.Values{"audience": {"other"}}),
+			wantError: "invalid_target",
+		},
+		{
+			name:      "ambiguous mapping",
+			cfg:       &config.Config{Exchange: testExchangeSettings()},
+			form:      exchangeForm(url.Values{"requested_token_type": {TokenTypeServiceAccount}}),
+			wantError: "invalid_target",
+		},
+		{
+			name:      "unmapped target",
+			cfg:       &config.Config{Exchange: testExchangeSettings()},
+			form:      exchangeForm(url.Values{"requested_token_type": {TokenTypeServiceAccount}, "resource": {"cluster-b/kube-system/admin"}}),
+			wantError: "invalid_target",
+		},
+		{
+			name:      "mapped audience not allowed",
+			cfg:       &config.Config{Exchange: testExchangeSettings()},
+			form:      exchangeForm(url.Values{"requested_token_type": {TokenTypeServiceAccount}, "resource": {"cluster-b/apps/reader"}, "audience": {"vault"}}),
+			wantError: "invalid_target",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := &mockClusterClients{review: authenticatedReview("ci", "deployer")}
+			router := newTokenExchangeRouter(t, tt.cfg, clients)
+
+			w := postToken(router, tt.form)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			var resp OAuthErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Error != tt.wantError {
+				t.Errorf("error = %q, want %q (%s)", resp.Error, tt.wantError, resp.ErrorDescription)
+			}
+			if len(clients.minted) != 0 {
+				t.Error("expected no token to be minted")
+			}
+		})
+	}
+}
+
+func TestTokenExchange_Limits(t *testing.T) {
+	cfg := &config.Config{
+		Issuer:     testIssuerSettings(),
+		Limits:     &config.LimitSettings{MaxBodyBytes: 512, MaxTokenBytes: 64},
+		RateLimits: &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01, Burst: 3}},
+	}
+	clients := &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("ci", "deployer"))}
+	router := newTokenExchangeRouter(t, cfg, clients)
+
+	if w := postToken(router, exchangeForm(url.Values{"subject_token": {strings.Repeat("x", 600)}})); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d for an oversized body, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if w := postToken(router, exchangeForm(url.Values{"subject_token": {strings.Repeat("x", 65)}})); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an oversized subject token, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := postToken(router, exchangeForm(nil)); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// Every request counts against the client's address, rejected or not
+	w := postToken(router, exchangeForm(nil))
+	var resp OAuthErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusTooManyRequests || resp.Error != oauthTemporarilyUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, error = %q, want 429 %s with Retry-After", w.Code, resp.Error, oauthTemporarilyUnavailable)
+	}
+}
+
+func TestTokenExchange_SubjectNotBoundToSubjectAudience(t *testing.T) {
+	// A default token, bound to the API server's audience, can't be exchanged
+	review := boundReview("https://kubernetes.default.svc", authenticatedReview("ci", "deployer"))
+	for _, requestedType := range []string{TokenTypeJWT, TokenTypeServiceAccount} {
+		cfg := &config.Config{Issuer: testIssuerSettings(), Exchange: testExchangeSettings()}
+		router := newTokenExchangeRouter(t, cfg, &mockClusterClients{review: review})
+
+		w := postToken(router, exchangeForm(url.Values{"requested_token_type": {requestedType}, "resource": {"cluster-b/apps/deployer"}}))
+		var resp OAuthErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.Error != oauthInvalidGrant {
+			t.Errorf("%s: status = %d, error = %q, want invalid_grant", requestedType, w.Code, resp.Error)
+		}
+	}
+}
+
+func newTokenRequestRouter(t *testing.T, clients ClusterClients, auditLog *audit.Log) http.Handler {
+	t.Helper()
+	return newTokenRequestRouterWithConfig(t, &config.Config{Exchange: testExchangeSettings()}, clients, auditLog)
+}
+
+func newTokenRequestRouterWithConfig(t *testing.T, cfg *config.Config, clients ClusterClients, auditLog *audit.Log) http.Handler {
+	t.Helper()
+	cfg.Clusters = map[string]config.ClusterConfig{
+		"cluster-a": {Issuer: "https://a.example.com", APIServer: "https://a.example.com:6443"},
+		"cluster-b": {Issuer: "https://b.example.com", APIServer: "https://b.example.com:6443"},
+	}
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"caller-token":  {Cluster: "cluster-a"},
+		"default-token": {Cluster: "cluster-a"},
+	}}
+
+	r := chi.NewRouter()
+	r.Post("/serviceaccounts/token", NewTokenRequestHandler(NewTokenReviewHandler(verifier, cfg, clients), cfg, auditLog).ServeHTTP)
+	return r
+}
+
+func postTokenRequest(router http.Handler, callerToken string, body MintRequest) (*httptest.ResponseRecorder, MintResponse) {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/serviceaccounts/token", bytes.NewReader(data))
+	if callerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+callerToken)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp MintResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestTokenRequest_MintsBoundedToken(t *testing.T) {
+	clients := &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("ci", "deployer"))}
+	auditLog := audit.NewLog(10)
+	router := newTokenRequestRouter(t, clients, auditLog)
+
+	w, resp := postTokenRequest(router, "caller-token", MintRequest{
+		Target:            "cluster-b/apps/deployer",
+		Audiences:         []string{"https://b.example.com"},
+		ExpirationSeconds: int64((2 * time.Hour).Seconds()),
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, resp.Error)
+	}
+	if resp.Token != "minted-cluster-b" || resp.Target != "cluster-b/apps/deployer" || resp.ExpiresAt == "" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	if len(clients.minted) != 1 {
+		t.Fatalf("minted %d tokens, want 1", len(clients.minted))
+	}
+	if got := *clients.minted[0].spec.ExpirationSeconds; got != int64((30 * time.Minute).Seconds()) {
+		t.Errorf("expirationSeconds = %d, want capped at max_expiration", got)
+	}
+
+	events := auditLog.List(0)
+	if len(events) != 1 {
+		t.Fatalf("recorded %d audit events, want 1", len(events))
+	}
+	e := events[0]
+	if !e.Allowed || e.Action != audit.ActionTokenMint || e.Caller != "cluster-a/ci/deployer" || e.Target != "cluster-b/apps/deployer" || e.ExpiresAt == nil {
+		t.Errorf("audit event = %+v", e)
+	}
+}
+
+func TestTokenRequest_Rejections(t *testing.T) {
+	tests := []struct {
+		name        string
+		callerToken string
+		body        MintRequest
+		wantCode    int
+		wantAudit   bool
+	}{
+		{"no caller token", "", MintRequest{Target: "cluster-b/apps/deployer"}, http.StatusUnauthorized, false},
+		{"unknown caller token", "foreign-token", MintRequest{Target: "cluster-b/apps/deployer"}, http.StatusUnauthorized, false},
+		{"caller token not bound to subject audience", "default-token", MintRequest{Target: "cluster-b/apps/deployer"}, http.StatusUnauthorized, false},
+		{"expiration below minimum", "caller-token", MintRequest{Target: "cluster-b/apps/deployer", ExpirationSeconds: 60}, http.StatusBadRequest, false},
+		{"ambiguous target", "caller-token", MintRequest{}, http.StatusForbidden, true},
+		{"unmapped target", "caller-token", MintRequest{Target: "cluster-b/kube-system/admin"}, http.StatusForbidden, true},
+		{"audience not allowed", "caller-token", MintRequest{Target: "cluster-b/apps/deployer", Audiences: []string{"vault"}}, http.StatusForbidden, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := &mockClusterClients{review: func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+				if tr.Spec.Token == "default-token" {
+					return boundReview("https://kubernetes.default.svc", authenticatedReview("ci", "deployer"))(cluster, tr)
+				}
+				return authenticatedReview("ci", "deployer")(cluster, tr)
+			}}
+			auditLog := audit.NewLog(10)
+			router := newTokenRequestRouter(t, clients, auditLog)
+
+			w, resp := postTokenRequest(router, tt.callerToken, tt.body)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.wantCode, resp.Error)
+			}
+			if len(clients.minted) != 0 {
+				t.Error("expected no token to be minted")
+			}
+
+			events := auditLog.List(0)
+			if tt.wantAudit && (len(events) != 1 || events[0].Allowed || events[0].Reason == "") {
+				t.Errorf("audit events = %+v, want one denial with reason", events)
+			}
+			if !tt.wantAudit && len(events) != 0 {
+				t.Errorf("audit events = %+v, want none", events)
+			}
+		})
+	}
+}
+
+func TestTokenRequest_Limits(t *testing.T) {
+	cfg := &config.Config{
+		Exchange:   testExchangeSettings(),
+		Limits:     &config.LimitSettings{MaxBodyBytes: 512, MaxTokenBytes: 64},
+		RateLimits: &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01, Burst: 3}},
+	}
+	clients := &mockClusterClients{review: boundReview("kube-federated-auth", authenticatedReview("ci", "deployer"))}
+	router := newTokenRequestRouterWithConfig(t, cfg, clients, nil)
+
+	if w, _ := postTokenRequest(router, "caller-token", MintRequest{Target: strings.Repeat("x", 600)}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d for an oversized body, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if w, _ := postTokenRequest(router, strings.Repeat("x", 65), MintRequest{Target: "cluster-b/apps/deployer"}); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an oversized caller token, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w, resp := postTokenRequest(router, "caller-token", MintRequest{Target: "cluster-b/apps/deployer"}); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, resp.Error)
+	}
+
+	// Every request counts against the client's address, rejected or not
+	w, resp := postTokenRequest(router, "caller-token", MintRequest{Target: "cluster-b/apps/deployer"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(ErrorCodeHeader) != CodeRateLimited || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, headers = %v, want 429 %s with Retry-After", w.Code, w.Header(), CodeRateLimited)
+	}
+	if resp.Token != "" || len(clients.minted) != 1 {
+		t.Errorf("minted %d tokens, want none when rate limited", len(clients.minted)-1)
+	}
+}
+
+func TestAdmin_Audit(t *testing.T) {
+	auditLog := audit.NewLog(10)
+	auditLog.Record(audit.Event{Action: audit.ActionTokenMint, Target: "cluster-b/apps/deployer", Allowed: true})
+	auditLog.Record(audit.Event{Action: audit.ActionTokenMint, Target: "cluster-b/apps/reader"})
+	router := newAdminRouter(NewAdminHandler(adminTestVerifier(), &mockCache{}, adminTestConfig(), nil, nil, auditLog))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit?limit=1", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp AuditResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(resp.Events) != 1 || resp.Events[0].Target != "cluster-b/apps/reader" {
+		t.Errorf("events = %+v, want the single most recent event", resp.Events)
+	}
+}
+
+// explainVerifier verifies tokens like mockVerifier, fails others with a classified
+// error per cluster, and reports which clusters have cached verifiers.
+type explainVerifier struct {
+	*mockVerifier
+	reasons map[string]string // cluster -> failure reason
+	cached  map[string]bool
+}
+
+func (v *explainVerifier) Verify(ctx context.Context, clusterName, rawToken string) (*oidc.Claims, error) {
+	claims, err := v.mockVerifier.Verify(ctx, clusterName, rawToken)
+	if err != nil {
+		return nil, &oidc.VerifyError{Reason: v.reasons[clusterName], Err: err}
+	}
+	return claims, nil
+}
+
+func (v *explainVerifier) HasVerifier(clusterName string) bool {
+	return v.cached[clusterName]
+}
+
+func (v *explainVerifier) KeyIDs(ctx context.Context, clusterName string) ([]string, error) {
+	return []string{"key-" + clusterName}, nil
+}
+
+func postExplainedReview(handler http.Handler, callerToken, token string) (*httptest.ResponseRecorder, *ReviewTrace) {
+	body := `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"` + token + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews?explain=true", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+callerToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var resp struct {
+		Trace *ReviewTrace `json:"trace"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Trace
+}
+
+func TestTokenReview_Explain(t *testing.T) {
+	verifier := adminTestVerifier()
+	verifier.claims["subject-token"] = &oidc.Claims{Cluster: "cluster-b"}
+	ev := &explainVerifier{
+		mockVerifier: verifier,
+		reasons:      map[string]string{"cluster-a": oidc.ReasonIssuerMismatch},
+		cached:       map[string]bool{"cluster-a": true},
+	}
+	clients := &mockClusterClients{review: authenticatedReview("default", "my-app")}
+	handler := NewTokenReviewHandler(ev, adminTestConfig(), clients)
+
+	w, trace := postExplainedReview(handler, "admin-token", "subject-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp authv1.TokenReview
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.Status.Authenticated {
+		t.Errorf("expected the TokenReview status alongside the trace, got %s", w.Body.String())
+	}
+
+	if trace == nil {
+		t.Fatal("expected a trace")
+	}
+	want := []ClusterAttempt{
+		{Cluster: "cluster-a", Result: oidc.ReasonIssuerMismatch, Cache: "hit"},
+		{Cluster: "cluster-b", Result: "verified", Cache: "miss"},
+	}
+	if len(trace.Clusters) != len(want) {
+		t.Fatalf("clusters = %+v, want %+v", trace.Clusters, want)
+	}
+	for i, got := range trace.Clusters {
+		if got.Cluster != want[i].Cluster || got.Result != want[i].Result || got.Cache != want[i].Cache {
+			t.Errorf("clusters[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if trace.DetectedCluster != "cluster-b" || trace.Forward == nil || trace.Forward.Cluster != "cluster-b" || trace.Forward.Error != "" {
+		t.Errorf("unexpected detection and forwarding: %q, %+v", trace.DetectedCluster, trace.Forward)
+	}
+	var steps []string
+	for _, s := range trace.Steps {
+		steps = append(steps, s.Name)
+	}
+	if strings.Join(steps, ",") != "authenticate_caller,detect_cluster,forward_tokenreview,total" {
+		t.Errorf("unexpected steps %v", steps)
+	}
+
+	// Without explain, the response is a plain TokenReview
+	body := `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"subject-token"}}`
+	plain := httptest.NewRecorder()
+	handler.ServeHTTP(plain, httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(body)))
+	if strings.Contains(plain.Body.String(), `"trace"`) {
+		t.Errorf("unexpected trace without explain: %s", plain.Body.String())
+	}
+}
+
+func TestTokenReview_ExplainFailure(t *testing.T) {
+	ev := &explainVerifier{
+		mockVerifier: adminTestVerifier(),
+		reasons: map[string]string{
+			"cluster-a": oidc.ReasonExpired,
+			"cluster-b": oidc.ReasonBadSignature,
+		},
+	}
+	handler := NewTokenReviewHandler(ev, adminTestConfig(), nil)
+
+	// A kid that cluster-b doesn't publish is reported as unknown rather than a bad signature
+	token := "eyJhbGciOiJSUzI1NiIsImtpZCI6Im90aGVyIn0.e30.sig"
+	w, trace := postExplainedReview(handler, "admin-token", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if trace == nil || len(trace.Clusters) != 2 {
+		t.Fatalf("expected a trace of both clusters, got %+v", trace)
+	}
+	if trace.Clusters[0].Result != oidc.ReasonExpired || trace.Clusters[1].Result != oidc.ReasonUnknownKeyID {
+		t.Errorf("unexpected results: %+v", trace.Clusters)
+	}
+	if trace.Clusters[0].Error == "" || trace.DetectedCluster != "" || trace.Forward != nil {
+		t.Errorf("unexpected trace: %+v", trace)
+	}
+}
+
+func TestTokenReview_ExplainRequiresAdmin(t *testing.T) {
+	cfg := adminTestConfig()
+	cfg.AuthorizedClients = []string{"cluster-a/kube-federated-auth/test-client"}
+	handler := NewTokenReviewHandler(adminTestVerifier(), cfg, nil)
+
+	for token, want := range map[string]int{
+		"":            http.StatusUnauthorized,
+		"other-token": http.StatusForbidden,
+	} {
+		w, trace := postExplainedReview(handler, token, "subject-token")
+		if w.Code != want {
+			t.Errorf("token %q: status = %d, want %d", token, w.Code, want)
+		}
+		if trace != nil {
+			t.Errorf("token %q: unexpected trace", token)
+		}
+	}
+
+	// The header works like the query parameter
+	body := `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"subject-token"}}`
+	req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer other-token")
+	req.Header.Set(ExplainHeader, "true")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("explain header: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// failingClients answers every TokenReview with err
+type failingClients struct {
+	err error
+}
+
+func (f failingClients) Clientset(cluster string) (kubernetes.Interface, error) {
+	client := kubefake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, f.err
+	})
+	return client, nil
+}
+
+func TestTokenReview_ErrorsDoNotLeakDetail(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{"subject-token": {Cluster: "cluster-b"}}}
+	tests := []struct {
+		name     string
+		token    string
+		err      error
+		wantCode string
+		wantMsg  string
+	}{
+		{"unknown token", "foreign-token", nil, CodeDetectionFailed, "token not valid for any configured cluster"},
+		{"unreachable", "subject-token", fmt.Errorf("Post \"https://10.0.0.1:6443/apis\": dial tcp 10.0.0.1:6443: connect: connection refused"), CodeRemoteUnavailable, "source cluster is unavailable"},
+		{"forbidden", "subject-token", apierrors.NewForbidden(authv1.Resource("tokenreviews"), "", fmt.Errorf("User \"system:serviceaccount:kube-federated-auth:reviewer\" cannot create resource")), CodeRemoteRejected, "source cluster rejected the review request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.RequestID(NewTokenReviewHandler(verifier, adminTestConfig(), failingClients{err: tt.err}))
+
+			body := `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"` + tt.token + `"}}`
+			req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			var resp authv1.TokenReview
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Status.Authenticated || resp.Status.Error != tt.wantMsg {
+				t.Errorf("status = %+v, want error %q", resp.Status, tt.wantMsg)
+			}
+			if got := w.Header().Get(ErrorCodeHeader); got != tt.wantCode {
+				t.Errorf("error code = %q, want %q", got, tt.wantCode)
+			}
+			if w.Header().Get(RequestIDHeader) == "" {
+				t.Error("expected the request ID to correlate with logs")
+			}
+			for _, detail := range []string{"10.0.0.1", "system:serviceaccount", "cluster-b"} {
+				if strings.Contains(w.Body.String(), detail) {
+					t.Errorf("response leaks %q: %s", detail, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestTokenReview_CallerErrorCodes(t *testing.T) {
+	cfg := adminTestConfig()
+	cfg.AuthorizedClients = []string{"cluster-a/kube-federated-auth/operator"}
+	handler := NewTokenReviewHandler(adminTestVerifier(), cfg, nil)
+
+	for token, want := range map[string]string{
+		"":            CodeUnauthenticatedCaller,
+		"other-token": CodeUnauthorizedCaller,
+	} {
+		body := `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"subject-token"}}`
+		req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if got := w.Header().Get(ErrorCodeHeader); got != want {
+			t.Errorf("token %q: error code = %q, want %q", token, got, want)
+		}
+		// The caller's identity is logged, not returned
+		if strings.Contains(w.Body.String(), "test-client") {
+			t.Errorf("token %q: response leaks the caller identity: %s", token, w.Body.String())
+		}
+	}
+}
+
+// selectingVerifier narrows the clusters to verify against like oidc.VerifierManager
+type selectingVerifier struct {
+	*mockVerifier
+	candidates []string          // for subject-token; other tokens may be from any cluster
+	rejected   *oidc.VerifyError // returned for subject-token instead, if set
+}
+
+func (v *selectingVerifier) Candidates(rawToken string) ([]string, error) {
+	if rawToken != "subject-token" {
+		return []string{"cluster-a", "cluster-b"}, nil
+	}
+	if v.rejected != nil {
+		return nil, v.rejected
+	}
+	return v.candidates, nil
+}
+
+func TestTokenReview_Candidates(t *testing.T) {
+	verifier := adminTestVerifier()
+	verifier.claims["subject-token"] = &oidc.Claims{}
+	clients := &mockClusterClients{review: authenticatedReview("default", "my-app")}
+
+	// Only candidates are verified against; the others appear in the trace as skipped
+	sv := &selectingVerifier{mockVerifier: verifier, candidates: []string{"cluster-b"}}
+	handler := NewTokenReviewHandler(sv, adminTestConfig(), clients)
+	_, trace := postExplainedReview(handler, "admin-token", "subject-token")
+	if trace == nil || trace.DetectedCluster != "cluster-b" || len(trace.Clusters) != 2 {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if a := trace.Clusters[0]; a.Cluster != "cluster-a" || !a.Skipped || a.Result != oidc.ReasonIssuerMismatch {
+		t.Errorf("expected cluster-a to be skipped, got %+v", a)
+	}
+
+	// Tokens rejected by the pre-filter are never verified
+	sv = &selectingVerifier{mockVerifier: verifier, rejected: &oidc.VerifyError{Reason: oidc.ReasonUnsupportedAlgorithm, Err: fmt.Errorf("alg none")}}
+	handler = NewTokenReviewHandler(sv, adminTestConfig(), clients)
+	_, trace = postExplainedReview(handler, "admin-token", "subject-token")
+	if trace == nil || trace.Rejected != oidc.ReasonUnsupportedAlgorithm || len(trace.Clusters) != 0 || trace.Forward != nil {
+		t.Errorf("unexpected trace %+v", trace)
+	}
+}
+
+func TestTokenReview_RequestValidation(t *testing.T) {
+	cfg := &config.Config{
+		Limits:   &config.LimitSettings{MaxBodyBytes: 512, MaxTokenBytes: 64},
+		Clusters: map[string]config.ClusterConfig{"cluster-a": {Issuer: "https://a.example.com"}},
+	}
+	handler := NewTokenReviewHandler(nil, cfg, nil)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+		wantReason  metav1.StatusReason
+	}{
+		{"unknown field", "application/json", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"t","tokne":"t"}}`, http.StatusBadRequest, metav1.StatusReasonBadRequest},
+		{"duplicate field", "application/json", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"t","token":"u"}}`, http.StatusBadRequest, metav1.StatusReasonBadRequest},
+		{"wrong kind", "application/json", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenRequest","spec":{}}`, http.StatusBadRequest, metav1.StatusReasonBadRequest},
+		{"wrong version", "application/json", `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"t"}}`, http.StatusBadRequest, metav1.StatusReasonBadRequest},
+		{"oversized token", "application/json", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"` + strings.Repeat("x", 65) + `"}}`, http.StatusBadRequest, metav1.StatusReasonBadRequest},
+		{"oversized body", "application/json", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"t","audiences":["` + strings.Repeat("x", 512) + `"]}}`, http.StatusRequestEntityTooLarge, metav1.StatusReasonRequestEntityTooLarge},
+		{"unsupported content type", "text/plain", `token`, http.StatusUnsupportedMediaType, metav1.StatusReasonUnsupportedMediaType},
+		// As with kube-apiserver, apiVersion and kind may be omitted
+		{"no type meta", "application/json", `{"spec":{"token":"t"}}`, http.StatusOK, ""},
+		{"content type parameters", "application/json; charset=utf-8", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"t"}}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK {
+				return
+			}
+			var status metav1.Status
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatal(err)
+			}
+			if status.Kind != "Status" || status.Reason != tt.wantReason || status.Code != int32(tt.want) {
+				t.Errorf("unexpected Status %+v", status)
+			}
+		})
+	}
+
+	// Oversized bearer tokens are rejected before verification
+	cfg.AuthorizedClients = []string{"*/*/*"}
+	handler = NewTokenReviewHandler(&mockVerifier{}, cfg, nil)
+	req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(`{"spec":{"token":"t"}}`))
+	req.Header.Set("Authorization", "Bearer "+strings.Repeat("x", 65))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("oversized bearer token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestTokenReview_Protobuf(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{"subject-token": {Cluster: "cluster-b"}}}
+	clients := &mockClusterClients{review: authenticatedReview("default", "my-app")}
+	handler := NewTokenReviewHandler(verifier, adminTestConfig(), clients)
+	info, _ := serializerFor(MediaTypeProtobuf)
+
+	post := func(token string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		tr := &authv1.TokenReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"},
+			Spec:     authv1.TokenReviewSpec{Token: token},
+		}
+		if err := info.Serializer.Encode(tr, &body); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", &body)
+		req.Header.Set("Content-Type", MediaTypeProtobuf)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("subject-token")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != MediaTypeProtobuf {
+		t.Fatalf("status = %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	obj, _, err := info.Serializer.Decode(w.Body.Bytes(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr, ok := obj.(*authv1.TokenReview); !ok || !tr.Status.Authenticated || tr.Status.User.Extra[ExtraKeyClusterName][0] != "cluster-b" {
+		t.Errorf("unexpected response %+v", obj)
+	}
+
+	// Errors are Status objects in protobuf too
+	w = post("")
+	obj, _, err = info.Serializer.Decode(w.Body.Bytes(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, ok := obj.(*metav1.Status); !ok || status.Code != http.StatusBadRequest || status.Message != "token is required" {
+		t.Errorf("unexpected error response %+v", obj)
+	}
+}
+
+func postReview(handler http.Handler, callerToken, token string) *httptest.ResponseRecorder {
+	body := `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"` + token + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/apis/authentication.k8s.io/v1/tokenreviews", strings.NewReader(body))
+	if callerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+callerToken)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// assertRateLimited checks a 429 Status with Retry-After
+func assertRateLimited(t *testing.T, w *httptest.ResponseRecorder, message string) {
+	t.Helper()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body.String())
+	}
+	if w.Header().Get(ErrorCodeHeader) != CodeRateLimited || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected %s with Retry-After, got headers %v", CodeRateLimited, w.Header())
+	}
+	var status metav1.Status
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if status.Reason != metav1.StatusReasonTooManyRequests || status.Message != message {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestTokenReview_CallerRateLimit(t *testing.T) {
+	verifier := adminTestVerifier()
+	verifier.claims["subject-token"] = &oidc.Claims{Cluster: "cluster-b"}
+	cfg := adminTestConfig()
+	cfg.AuthorizedClients = []string{"cluster-a/kube-federated-auth/*"}
+	cfg.RateLimits = &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01}}
+	handler := NewTokenReviewHandler(verifier, cfg, &mockClusterClients{review: authenticatedReview("default", "my-app")})
+
+	if w := postReview(handler, "admin-token", "subject-token"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	w := postReview(handler, "admin-token", "subject-token")
+	assertRateLimited(t, w, msgCallerRateLimited)
+	if w.Header().Get("Retry-After") != "100" {
+		t.Errorf("Retry-After = %q, want the time to the next token", w.Header().Get("Retry-After"))
+	}
+
+	// Each caller has its own bucket
+	if w := postReview(handler, "other-token", "subject-token"); w.Code != http.StatusOK {
+		t.Errorf("status = %d for another caller, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestTokenReview_ClusterRateLimit(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"token-a": {Cluster: "cluster-a"},
+		"token-b": {Cluster: "cluster-b"},
+	}}
+	cfg := adminTestConfig()
+	cfg.RateLimits = &config.RateLimitSettings{Clusters: &config.RateLimit{RequestsPerSecond: 0.01, Burst: 2}}
+	handler := NewTokenReviewHandler(verifier, cfg, &mockClusterClients{review: authenticatedReview("default", "my-app")})
+
+	for range 2 {
+		if w := postReview(handler, "", "token-b"); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d within the burst", w.Code, http.StatusOK)
+		}
+	}
+	assertRateLimited(t, postReview(handler, "", "token-b"), msgClusterRateLimited)
+	if w := postReview(handler, "", "token-a"); w.Code != http.StatusOK {
+		t.Errorf("status = %d for another cluster, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestTokenReview_ClusterConcurrency(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"subject-token": {Cluster: "cluster-b"},
+		"other-token":   {Cluster: "cluster-b"},
+	}}
+	cfg := adminTestConfig()
+	cfg.RateLimits = &config.RateLimitSettings{MaxConcurrentReviews: 1}
+	inFlight, unblock := make(chan struct{}), make(chan struct{})
+	clients := &mockClusterClients{review: func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+		inFlight <- struct{}{}
+		<-unblock
+		return authenticatedReview("default", "my-app")(cluster, tr)
+	}}
+	handler := NewTokenReviewHandler(verifier, cfg, clients)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postReview(handler, "", "subject-token") }()
+	<-inFlight
+
+	// Another token from the same cluster; the same token would share the review in flight
+	assertRateLimited(t, postReview(handler, "", "other-token"), msgClusterBusy)
+
+	close(unblock)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("status = %d for the review in flight, want %d", w.Code, http.StatusOK)
+	}
+	go func() { <-inFlight }()
+	if w := postReview(handler, "", "other-token"); w.Code != http.StatusOK {
+		t.Errorf("status = %d once the slot is released, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestKeyedLimiter_SweepsIdleBuckets(t *testing.T) {
+	now := time.Now()
+	l := newKeyedLimiter(&config.RateLimit{RequestsPerSecond: 1})
+	l.now = func() time.Time { return now }
+
+	if l.reserve("a") != 0 {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if retryAfter := l.reserve("a"); retryAfter != time.Second {
+		t.Errorf("retry after = %v, want 1s", retryAfter)
+	}
+	for i := range limiterSweepSize - 1 {
+		l.reserve(strconv.Itoa(i))
+	}
+
+	// Once refilled, buckets are dropped as more keys are added
+	now = now.Add(2 * time.Second)
+	l.reserve("b")
+	if len(l.limiters) != 1 {
+		t.Errorf("%d limiters kept, want only the new one", len(l.limiters))
+	}
+	if l.reserve("a") != 0 {
+		t.Error("expected a dropped bucket to start full")
+	}
+}
+
+func TestTokenReview_CoalescesIdenticalReviews(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{"subject-token": {Cluster: "cluster-b"}}}
+	var forwards atomic.Int32
+	unblock := make(chan struct{})
+	clients := &mockClusterClients{review: func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+		forwards.Add(1)
+		<-unblock
+		return authenticatedReview("default", "my-app")(cluster, tr)
+	}}
+	handler := NewTokenReviewHandler(verifier, adminTestConfig(), clients)
+	coalesced := testutil.ToFloat64(reviewRequests.WithLabelValues("true"))
+
+	const n = 5
+	responses := make(chan *httptest.ResponseRecorder, n)
+	for range n {
+		go func() { responses <- postReview(handler, "", "subject-token") }()
+	}
+	// Let the requests join the review in flight
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+
+	for range n {
+		w := <-responses
+		var resp authv1.TokenReview
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if !resp.Status.Authenticated || resp.Status.User.Extra[ExtraKeyClusterName][0] != "cluster-b" {
+			t.Errorf("unexpected response %s", w.Body.String())
+		}
+	}
+	got := int(testutil.ToFloat64(reviewRequests.WithLabelValues("true")) - coalesced)
+	if forwards.Load() >= n || int(forwards.Load())+got != n {
+		t.Errorf("%d reviews forwarded and %d coalesced, want %d requests to share fewer reviews", forwards.Load(), got, n)
+	}
+}
+
+func TestCoalescingKey(t *testing.T) {
+	key := func(token string, audiences ...string) string {
+		return coalescingKey(authv1.TokenReviewSpec{Token: token, Audiences: audiences})
+	}
+	if key("t", "a", "b") != key("t", "b", "a") {
+		t.Error("expected audiences in any order to coalesce")
+	}
+	if key("t", "a") == key("t", "b") || key("t") == key("u") || key("t", "a") == key("t") {
+		t.Error("expected different tokens or audiences not to coalesce")
+	}
+	if strings.Contains(key("secret-token"), "secret-token") {
+		t.Error("expected the token to be hashed")
+	}
+}
+
+func postBatch(handler http.HandlerFunc, callerToken, body string) (*httptest.ResponseRecorder, BatchTokenReviewResponse) {
+	req := httptest.NewRequest(http.MethodPost, "/tokenreviews/batch", strings.NewReader(body))
+	if callerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+callerToken)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	var resp BatchTokenReviewResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestTokenReview_Batch(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"token-a": {Cluster: "cluster-a"},
+		"token-b": {Cluster: "cluster-b"},
+	}}
+	var mu sync.Mutex
+	forwarded := map[string]int{}
+	clients := &mockClusterClients{review: func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+		mu.Lock()
+		forwarded[cluster]++
+		mu.Unlock()
+		return authenticatedReview("default", "app-"+cluster)(cluster, tr)
+	}}
+	handler := NewTokenReviewHandler(verifier, adminTestConfig(), clients)
+
+	w, resp := postBatch(handler.Batch, "", `{"specs":[
+		{"token":"token-b"},
+		{"token":"unknown-token"},
+		{"token":"token-a","audiences":["api"]},
+		{"token":"token-b"},
+		{"token":""}
+	]}`)
+	if w.Code != http.StatusOK || len(resp.Statuses) != 5 {
+		t.Fatalf("status = %d, want 5 statuses: %s", w.Code, w.Body.String())
+	}
+
+	for i, cluster := range map[int]string{0: "cluster-b", 2: "cluster-a", 3: "cluster-b"} {
+		status := resp.Statuses[i]
+		if !status.Authenticated || status.User.Username != "system:serviceaccount:default:app-"+cluster || status.User.Extra[ExtraKeyClusterName][0] != cluster {
+			t.Errorf("statuses[%d] = %+v, want authenticated by %s", i, status, cluster)
+		}
+	}
+	for i, code := range map[int]string{1: CodeDetectionFailed, 4: CodeInvalidRequest} {
+		if status := resp.Statuses[i]; status.Authenticated || status.Code != code || status.Error == "" {
+			t.Errorf("statuses[%d] = %+v, want %s", i, status, code)
+		}
+	}
+	// The duplicate token is reviewed once
+	if forwarded["cluster-a"] != 1 || forwarded["cluster-b"] != 1 {
+		t.Errorf("forwarded = %v, want one review per cluster", forwarded)
+	}
+}
+
+func TestTokenReview_BatchRejections(t *testing.T) {
+	cfg := adminTestConfig()
+	cfg.AuthorizedClients = []string{"cluster-a/kube-federated-auth/operator"}
+	cfg.Limits = &config.LimitSettings{MaxBatchSize: 2}
+	cfg.RateLimits = &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01, Burst: 3}}
+	verifier := adminTestVerifier()
+	verifier.claims["subject-token"] = &oidc.Claims{Cluster: "cluster-b"}
+	handler := NewTokenReviewHandler(verifier, cfg, &mockClusterClients{review: authenticatedReview("default", "my-app")})
+
+	two := `{"specs":[{"token":"subject-token"},{"token":"subject-token"}]}`
+	tests := []struct {
+		name        string
+		callerToken string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"no caller token", "", two, http.StatusUnauthorized, CodeUnauthenticatedCaller},
+		{"unauthorized caller", "other-token", two, http.StatusForbidden, CodeUnauthorizedCaller},
+		{"unknown field", "admin-token", `{"specs":[],"extra":1}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"no specs", "admin-token", `{"specs":[]}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"too many specs", "admin-token", `{"specs":[{"token":"a"},{"token":"b"},{"token":"c"}]}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"within limits", "admin-token", two, http.StatusOK, ""},
+		// Each token counts against the caller's rate limit
+		{"rate limited", "admin-token", two, http.StatusTooManyRequests, CodeRateLimited},
+	}
+	for _, tt := range tests {
+		w, _ := postBatch(handler.Batch, tt.callerToken, tt.body)
+		if w.Code != tt.wantStatus || w.Header().Get(ErrorCodeHeader) != tt.wantCode {
+			t.Errorf("%s: status = %d (%q), want %d (%q): %s", tt.name, w.Code, w.Header().Get(ErrorCodeHeader), tt.wantStatus, tt.wantCode, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/tokenreviews/batch", strings.NewReader(two))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	req.Header.Set("Content-Type", MediaTypeProtobuf)
+	w := httptest.NewRecorder()
+	handler.Batch(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d for protobuf, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+// newGRPCClient serves s over an in-memory connection
+func newGRPCClient(t *testing.T, s *GRPCServer) federatedauthv1.TokenReviewServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(RequestIDInterceptor))
+	federatedauthv1.RegisterTokenReviewServiceServer(server, s)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return federatedauthv1.NewTokenReviewServiceClient(conn)
+}
+
+// withCallerToken sets the caller's token in the outgoing metadata
+func withCallerToken(callerToken string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+callerToken)
+}
+
+// assertGRPCError checks the code of a failed call and the reason of its ErrorInfo
+func assertGRPCError(t *testing.T, err error, wantCode codes.Code, wantReason string) *status.Status {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != wantCode {
+		t.Fatalf("code = %v (%v), want %v", st.Code(), err, wantCode)
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == wantReason && info.Domain == ErrorDomain {
+			return st
+		}
+	}
+	t.Errorf("details = %v, want ErrorInfo with reason %q", st.Details(), wantReason)
+	return st
+}
+
+func TestGRPC_Review(t *testing.T) {
+	verifier := adminTestVerifier()
+	verifier.claims["subject-token"] = &oidc.Claims{Cluster: "cluster-b"}
+	cfg := adminTestConfig()
+	cfg.AuthorizedClients = []string{"cluster-a/kube-federated-auth/operator"}
+	cfg.RateLimits = &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01, Burst: 3}}
+	reviewer := NewTokenReviewHandler(verifier, cfg, &mockClusterClients{review: authenticatedReview("default", "my-app")})
+	client := newGRPCClient(t, NewGRPCServer(reviewer, NewClustersHandler(cfg, nil)))
+
+	var header metadata.MD
+	resp, err := client.Review(withCallerToken("admin-token"), &federatedauthv1.ReviewRequest{Token: "subject-token", Audiences: []string{"api"}}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := resp.GetStatus()
+	if !got.GetAuthenticated() || got.GetCluster() != "cluster-b" || got.GetUser().GetUsername() != "system:serviceaccount:default:my-app" || got.GetUser().GetUid() != "sa-uid" {
+		t.Errorf("status = %v, want authenticated by cluster-b", got)
+	}
+	if pod := got.GetUser().GetExtra()["authentication.kubernetes.io/pod-name"]; len(pod.GetValues()) != 1 || pod.GetValues()[0] != "deployer-abc" {
+		t.Errorf("extra = %v, want the pod name", got.GetUser().GetExtra())
+	}
+	if len(header.Get("x-request-id")) != 1 {
+		t.Errorf("header = %v, want a request ID", header)
+	}
+
+	// A token that isn't authenticated is reported in the status
+	resp, err = client.Review(withCallerToken("admin-token"), &federatedauthv1.ReviewRequest{Token: "unknown-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetStatus(); got.GetAuthenticated() || got.GetCode() != CodeDetectionFailed || got.GetError() != msgDetectionFailed {
+		t.Errorf("status = %v, want %s", got, CodeDetectionFailed)
+	}
+
+	// Refused calls fail
+	_, err = client.Review(context.Background(), &federatedauthv1.ReviewRequest{Token: "subject-token"})
+	assertGRPCError(t, err, codes.Unauthenticated, CodeUnauthenticatedCaller)
+	_, err = client.Review(withCallerToken("other-token"), &federatedauthv1.ReviewRequest{Token: "subject-token"})
+	assertGRPCError(t, err, codes.PermissionDenied, CodeUnauthorizedCaller)
+	_, err = client.Review(withCallerToken("admin-token"), &federatedauthv1.ReviewRequest{})
+	assertGRPCError(t, err, codes.InvalidArgument, CodeInvalidRequest)
+
+	_, err = client.Review(withCallerToken("admin-token"), &federatedauthv1.ReviewRequest{Token: "subject-token"})
+	st := assertGRPCError(t, err, codes.ResourceExhausted, CodeRateLimited)
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if retryInfo.GetRetryDelay().AsDuration() <= 0 {
+		t.Errorf("details = %v, want RetryInfo", st.Details())
+	}
+}
+
+func TestGRPC_BatchReview(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"token-a": {Cluster: "cluster-a"},
+		"token-b": {Cluster: "cluster-b"},
+	}}
+	cfg := adminTestConfig()
+	cfg.Limits = &config.LimitSettings{MaxBatchSize: 3}
+	reviewer := NewTokenReviewHandler(verifier, cfg, &mockClusterClients{review: authenticatedReview("default", "my-app")})
+	client := newGRPCClient(t, NewGRPCServer(reviewer, NewClustersHandler(cfg, nil)))
+
+	resp, err := client.BatchReview(context.Background(), &federatedauthv1.BatchReviewRequest{Reviews: []*federatedauthv1.ReviewRequest{
+		{Token: "token-b"},
+		{Token: "unknown-token"},
+		{Token: "token-a"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := resp.GetStatuses()
+	if len(statuses) != 3 {
+		t.Fatalf("statuses = %v, want 3", statuses)
+	}
+	if !statuses[0].GetAuthenticated() || statuses[0].GetCluster() != "cluster-b" || !statuses[2].GetAuthenticated() || statuses[2].GetCluster() != "cluster-a" {
+		t.Errorf("statuses = %v, want tokens 0 and 2 authenticated by their cluster", statuses)
+	}
+	if statuses[1].GetAuthenticated() || statuses[1].GetCode() != CodeDetectionFailed {
+		t.Errorf("statuses[1] = %v, want %s", statuses[1], CodeDetectionFailed)
+	}
+
+	_, err = client.BatchReview(context.Background(), &federatedauthv1.BatchReviewRequest{})
+	assertGRPCError(t, err, codes.InvalidArgument, CodeInvalidRequest)
+	_, err = client.BatchReview(context.Background(), &federatedauthv1.BatchReviewRequest{Reviews: make([]*federatedauthv1.ReviewRequest, 4)})
+	assertGRPCError(t, err, codes.InvalidArgument, CodeRequestTooLarge)
+}
+
+func TestGRPC_ListClusters(t *testing.T) {
+	cfg := adminTestConfig()
+	client := newGRPCClient(t, NewGRPCServer(NewTokenReviewHandler(nil, cfg, nil), NewClustersHandler(cfg, nil)))
+
+	resp, err := client.ListClusters(context.Background(), &federatedauthv1.ListClustersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := resp.GetClusters()
+	if len(clusters) != 2 || clusters[0].GetName() != "cluster-a" || clusters[1].GetName() != "cluster-b" {
+		t.Fatalf("clusters = %v, want cluster-a and cluster-b in order", clusters)
+	}
+	if clusters[1].GetIssuer() != "https://b.example.com" || clusters[1].GetApiServer() != "https://192.168.1.100:6443" {
+		t.Errorf("cluster-b = %v", clusters[1])
+	}
+}
+
+// checkRequest is an ext_authz CheckRequest of a request with the authorization header
+func checkRequest(authorization string) *authv3.CheckRequest {
+	headers := map[string]string{":path": "/api"}
+	if authorization != "" {
+		headers["authorization"] = authorization
+	}
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{Headers: headers}},
+	}}
+}
+
+func newExtAuthzTestHandler(settings *config.ExtAuthzSettings, audiences *[]string) *ExtAuthzHandler {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"subject-token": {Cluster: "cluster-b"},
+	}}
+	clients := &mockClusterClients{review: func(cluster string, tr *authv1.TokenReview) *authv1.TokenReview {
+		*audiences = tr.Spec.Audiences
+		return authenticatedReview("default", "my-app")(cluster, tr)
+	}}
+	return NewExtAuthzHandler(NewTokenReviewHandler(verifier, adminTestConfig(), clients), settings)
+}
+
+func TestExtAuthz_Check(t *testing.T) {
+	var audiences []string
+	h := newExtAuthzTestHandler(&config.ExtAuthzSettings{
+		Audiences:       []string{"edge"},
+		AllowedSubjects: []string{"cluster-b/default/*"},
+	}, &audiences)
+
+	resp, err := h.Check(context.Background(), checkRequest("Bearer subject-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatus().GetCode() != int32(codes.OK) || resp.GetOkResponse() == nil {
+		t.Fatalf("response = %v, want allowed", resp)
+	}
+	headers := map[string]string{}
+	for _, option := range resp.GetOkResponse().GetHeaders() {
+		if option.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+			t.Errorf("header %s is appended to the client's", option.GetHeader().GetKey())
+		}
+		headers[option.GetHeader().GetKey()] = option.GetHeader().GetValue()
+	}
+	if headers[HeaderFederatedCluster] != "cluster-b" || headers[HeaderFederatedUser] != "system:serviceaccount:default:my-app" {
+		t.Errorf("headers = %v", headers)
+	}
+	if len(audiences) != 1 || audiences[0] != "edge" {
+		t.Errorf("reviewed audiences = %v, want [edge]", audiences)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    typev3.StatusCode
+		wantCode      codes.Code
+	}{
+		{"no token", "", typev3.StatusCode_Unauthorized, codes.Unauthenticated},
+		{"not bearer", "Basic dXNlcjpwYXNz", typev3.StatusCode_Unauthorized, codes.Unauthenticated},
+		{"unknown token", "Bearer unknown-token", typev3.StatusCode_Unauthorized, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		resp, err := h.Check(context.Background(), checkRequest(tt.authorization))
+		if err != nil {
+			t.Fatal(err)
+		}
+		denied := resp.GetDeniedResponse()
+		if resp.GetStatus().GetCode() != int32(tt.wantCode) || denied.GetStatus().GetCode() != tt.wantStatus {
+			t.Errorf("%s: response = %v, want %v", tt.name, resp, tt.wantStatus)
+		}
+	}
+
+	// Subjects not allowed are forbidden
+	h.settings = &config.ExtAuthzSettings{Audiences: []string{"edge"}, AllowedSubjects: []string{"cluster-a/*/*"}}
+	resp, err = h.Check(context.Background(), checkRequest("Bearer subject-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatus().GetCode() != int32(codes.PermissionDenied) || resp.GetDeniedResponse().GetStatus().GetCode() != typev3.StatusCode_Forbidden {
+		t.Errorf("response = %v, want forbidden", resp)
+	}
+	var code string
+	for _, option := range resp.GetDeniedResponse().GetHeaders() {
+		if option.GetHeader().GetKey() == ErrorCodeHeader {
+			code = option.GetHeader().GetValue()
+		}
+	}
+	if code != CodeSubjectNotAllowed {
+		t.Errorf("%s = %q, want %q", ErrorCodeHeader, code, CodeSubjectNotAllowed)
+	}
+}
+
+func TestExtAuthz_HTTP(t *testing.T) {
+	var audiences []string
+	h := newExtAuthzTestHandler(&config.ExtAuthzSettings{Audiences: []string{"edge"}}, &audiences)
+
+	req := httptest.NewRequest(http.MethodGet, "/ext_authz/api/items", nil)
+	req.Header.Set("Authorization", "Bearer subject-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get(HeaderFederatedCluster) != "cluster-b" || w.Header().Get(HeaderFederatedUser) != "system:serviceaccount:default:my-app" {
+		t.Errorf("status = %d, headers = %v, want allowed with the identity", w.Code, w.Header())
+	}
+	if len(audiences) != 1 || audiences[0] != "edge" {
+		t.Errorf("reviewed audiences = %v, want [edge]", audiences)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ext_authz/api/items", nil)
+	req.Header.Set("Authorization", "Bearer unknown-token")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get(ErrorCodeHeader) != CodeDetectionFailed || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("status = %d, headers = %v, want 401 %s", w.Code, w.Header(), CodeDetectionFailed)
+	}
+	if w.Header().Get(HeaderFederatedCluster) != "" {
+		t.Error("identity header set on a denied request")
+	}
+}
+
+func TestExtAuthz_RateLimited(t *testing.T) {
+	verifier := &mockVerifier{claims: map[string]*oidc.Claims{
+		"subject-token": {Cluster: "cluster-b"},
+	}}
+	cfg := adminTestConfig()
+	cfg.RateLimits = &config.RateLimitSettings{Callers: &config.RateLimit{RequestsPerSecond: 0.01}}
+	reviewer := NewTokenReviewHandler(verifier, cfg, &mockClusterClients{review: authenticatedReview("default", "my-app")})
+	h := NewExtAuthzHandler(reviewer, &config.ExtAuthzSettings{Audiences: []string{"edge"}})
+
+	// Checks are limited per peer, whether allowed or not
+	envoy := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}})
+	if resp, _ := h.Check(envoy, checkRequest("Bearer unknown-token")); resp.GetStatus().GetCode() != int32(codes.Unauthenticated) {
+		t.Fatalf("response = %v, want unauthenticated", resp)
+	}
+	resp, _ := h.Check(envoy, checkRequest("Bearer subject-token"))
+	if resp.GetStatus().GetCode() != int32(codes.ResourceExhausted) || resp.GetDeniedResponse().GetStatus().GetCode() != typev3.StatusCode_TooManyRequests {
+		t.Errorf("response = %v, want rate limited", resp)
+	}
+
+	other := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4000}})
+	if resp, _ := h.Check(other, checkRequest("Bearer subject-token")); resp.GetOkResponse() == nil {
+		t.Errorf("response = %v for another peer, want allowed", resp)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	req.Header.Set("Authorization", "Bearer subject-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, headers = %v, want 429 with Retry-After", w.Code, w.Header())
+	}
+}
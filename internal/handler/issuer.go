@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/issuer"
+)
+
+// Extra keys set by the API server on TokenReview responses for pod-bound tokens
+const (
+	extraKeyPodName = "authentication.kubernetes.io/pod-name"
+	extraKeyPodUID  = "authentication.kubernetes.io/pod-uid"
+)
+
+type ExchangeRequest struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type ExchangeResponse struct {
+	Token     string `json:"token,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// IssuerHandler serves the OIDC discovery document and JWKS of the federated issuer,
+// and exchanges verified ServiceAccount tokens for tokens signed by it.
+type IssuerHandler struct {
+	reviewer *TokenReviewHandler
+	issuer   *issuer.Issuer
+	settings *config.IssuerSettings
+	tokens   *tokenIssuer
+}
+
+func NewIssuerHandler(reviewer *TokenReviewHandler, iss *issuer.Issuer, settings *config.IssuerSettings) *IssuerHandler {
+	return &IssuerHandler{
+		reviewer: reviewer,
+		issuer:   iss,
+		settings: settings,
+		tokens:   newTokenIssuer(iss, settings),
+	}
+}
+
+// Discovery serves /.well-known/openid-configuration
+func (h *IssuerHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.issuer.Discovery())
+}
+
+// JWKS serves the issuer's public keys
+func (h *IssuerHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	json.NewEncoder(w).Encode(h.issuer.JWKS())
+}
+
+// Exchange validates a ServiceAccount token against its source cluster and returns
+// a token signed by the issuer with subject "cluster:namespace:serviceaccount".
+//
+// Deprecated: POST /token issues the same tokens through the same policy, and should
+// be used instead.
+func (h *IssuerHandler) Exchange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", `</token>; rel="successor-version"`)
+
+	// Callers aren't authenticated, so they are limited by address
+	if err := h.reviewer.limitCaller(clientAddress(r), nil, 1); err != nil {
+		logError(r.Context(), "Token exchange", err)
+		h.writeJSON(w, rejectionStatus(w, r, err, err.Status), ExchangeResponse{Error: err.Message})
+		return
+	}
+
+	var req ExchangeRequest
+	maxBytes := h.reviewer.config.GetMaxBodyBytes()
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes)).Decode(&req); err != nil {
+		bodyErr := bodyError(err, maxBytes)
+		h.writeJSON(w, bodyErr.Status, ExchangeResponse{Error: bodyErr.Message})
+		return
+	}
+	if err := h.reviewer.checkToken(req.Token); err != nil {
+		h.writeJSON(w, err.Status, ExchangeResponse{Error: err.Message})
+		return
+	}
+
+	id, reviewErr := h.reviewer.reviewServiceAccount(r.Context(), req.Token, h.settings.SubjectAudiences)
+	if reviewErr != nil {
+		h.writeJSON(w, rejectionStatus(w, r, reviewErr, http.StatusUnauthorized), ExchangeResponse{Error: reviewErr.Message})
+		return
+	}
+
+	issued, err := h.tokens.issue(id, req.Audiences)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err.denied {
+			code = http.StatusForbidden
+		}
+		h.writeJSON(w, code, ExchangeResponse{Error: err.Error()})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, ExchangeResponse{
+		Token:     issued.token,
+		Subject:   issued.subject,
+		ExpiresAt: issued.expiry.UTC().Format(time.RFC3339),
+	})
+}
+
+// tokenIssuer issues tokens signed by the federated issuer to validated identities, as
+// allowed by the issuer settings. It serves both /exchange and /token.
+type tokenIssuer struct {
+	issuer   *issuer.Issuer
+	settings *config.IssuerSettings
+	now      func() time.Time
+}
+
+func newTokenIssuer(iss *issuer.Issuer, settings *config.IssuerSettings) *tokenIssuer {
+	return &tokenIssuer{issuer: iss, settings: settings, now: time.Now}
+}
+
+// issuedToken is a token signed by the federated issuer
+type issuedToken struct {
+	token    string
+	subject  string
+	issuedAt time.Time
+	expiry   time.Time
+}
+
+// issue signs a token for id, for the configured audiences if none are requested.
+// Issued tokens never outlive the subject token.
+func (t *tokenIssuer) issue(id *serviceAccountIdentity, audiences []string) (*issuedToken, *mintError) {
+	if len(audiences) == 0 {
+		audiences = t.settings.Audiences
+	}
+	for _, aud := range audiences {
+		if !t.settings.IsAllowedAudience(aud) {
+			return nil, &mintError{denied: true, message: "audience " + aud + " is not allowed"}
+		}
+	}
+	if !t.settings.IsAllowedSubject(id.cluster, id.namespace, id.serviceAccount) {
+		log.Printf("Token exchange denied for %s/%s/%s", id.cluster, id.namespace, id.serviceAccount)
+		return nil, &mintError{denied: true, message: "subject is not allowed to exchange tokens"}
+	}
+
+	now := t.now()
+	expiry := now.Add(t.settings.GetTokenDuration())
+	if !id.expiry.IsZero() && id.expiry.Before(expiry) {
+		expiry = id.expiry
+	}
+
+	claims := issuer.Claims{
+		Subject:    issuer.Subject(id.cluster, id.namespace, id.serviceAccount),
+		Audience:   audiences,
+		Expiry:     expiry.Unix(),
+		Cluster:    id.cluster,
+		Kubernetes: kubernetesClaims(id.namespace, id.serviceAccount, id.user),
+	}
+	token, err := t.issuer.Issue(claims)
+	if err != nil {
+		log.Printf("Issuing token for %s failed: %v", claims.Subject, err)
+		return nil, &mintError{message: "failed to issue token"}
+	}
+
+	log.Printf("Issued token for %s (audiences %v, expires %s)", claims.Subject, audiences, expiry.UTC().Format(time.RFC3339))
+	return &issuedToken{token: token, subject: claims.Subject, issuedAt: now, expiry: expiry}, nil
+}
+
+func kubernetesClaims(namespace, serviceAccount string, user authv1.UserInfo) *issuer.KubernetesClaims {
+	claims := &issuer.KubernetesClaims{
+		Namespace:      namespace,
+		ServiceAccount: &issuer.ObjectClaim{Name: serviceAccount, UID: user.UID},
+	}
+	if podName := user.Extra[extraKeyPodName]; len(podName) > 0 {
+		claims.Pod = &issuer.ObjectClaim{Name: podName[0]}
+		if podUID := user.Extra[extraKeyPodUID]; len(podUID) > 0 {
+			claims.Pod.UID = podUID[0]
+		}
+	}
+	return claims
+}
+
+func (h *IssuerHandler) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// reviewRequests counts the reviews requested, by whether they shared the result of
+// an identical review in flight
+var reviewRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kube_federated_auth",
+	Name:      "token_reviews_total",
+	Help:      "Token reviews requested, by whether they were coalesced with an identical review in flight.",
+}, []string{"coalesced"})
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+// Public messages of rate limited requests
+const (
+	msgCallerRateLimited  = "too many requests from caller"
+	msgClusterRateLimited = "too many requests for source cluster"
+	msgClusterBusy        = "too many reviews in flight to source cluster"
+)
+
+// limiterSweepSize is the number of keys above which idle limiters are dropped
+const limiterSweepSize = 1024
+
+// keyedLimiter is a token bucket per key, e.g. per caller. A nil keyedLimiter allows
+// everything.
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	limiters  map[string]*keyedBucket
+	nextSweep int
+}
+
+type keyedBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// newKeyedLimiter returns a limiter enforcing l, or nil if l is unset
+func newKeyedLimiter(l *config.RateLimit) *keyedLimiter {
+	if l == nil {
+		return nil
+	}
+	return &keyedLimiter{
+		limit:     rate.Limit(l.RequestsPerSecond),
+		burst:     l.GetBurst(),
+		now:       time.Now,
+		limiters:  make(map[string]*keyedBucket),
+		nextSweep: limiterSweepSize,
+	}
+}
+
+// reserve takes a token from key's bucket. If the bucket is empty it returns how long
+// until a token is available, and takes nothing.
+func (l *keyedLimiter) reserve(key string) time.Duration {
+	return l.reserveN(key, 1)
+}
+
+// reserveN is reserve for n tokens, or the whole bucket if n is larger
+func (l *keyedLimiter) reserveN(key string, n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	bucket, ok := l.limiters[key]
+	if !ok {
+		l.sweep(now)
+		bucket = &keyedBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = bucket
+	}
+	bucket.lastUsed = now
+
+	r := bucket.limiter.ReserveN(now, min(n, l.burst))
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// sweep drops the limiters that have refilled since last used, which are no different
+// from new ones, once there are many keys
+func (l *keyedLimiter) sweep(now time.Time) {
+	if len(l.limiters) < l.nextSweep {
+		return
+	}
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, bucket := range l.limiters {
+		if now.Sub(bucket.lastUsed) > refill {
+			delete(l.limiters, key)
+		}
+	}
+	l.nextSweep = max(limiterSweepSize, 2*len(l.limiters))
+}
+
+// concurrencyLimiter caps the calls in flight per key. A nil concurrencyLimiter allows
+// everything.
+type concurrencyLimiter struct {
+	max int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// newConcurrencyLimiter returns a limiter allowing max calls per key, or nil if max is 0
+func newConcurrencyLimiter(max int) *concurrencyLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{max: max, inFlight: make(map[string]int)}
+}
+
+// acquire takes a slot for key, returning the function that releases it, or false if
+// all slots are taken
+func (c *concurrencyLimiter) acquire(key string) (release func(), ok bool) {
+	if c == nil {
+		return func() {}, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight[key] >= c.max {
+		return nil, false
+	}
+	c.inFlight[key]++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.inFlight[key]--; c.inFlight[key] == 0 {
+			delete(c.inFlight, key)
+		}
+	}, true
+}
+
+// rateLimited is the error of a request refused by a limiter
+func rateLimited(message string, retryAfter time.Duration, err error) *Error {
+	rateErr := newError(CodeRateLimited, http.StatusTooManyRequests, message, err)
+	rateErr.RetryAfter = retryAfter
+	return rateErr
+}
+
+// retryAfterSeconds formats a Retry-After header, in whole seconds rounded up
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(max(1, (d+time.Second-1)/time.Second)))
+}
+
+// clientAddress identifies callers that aren't authenticated
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
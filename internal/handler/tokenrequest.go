@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	authv1 "k8s.io/api/authentication/v1"
+
+	"github.com/rophy/kube-federated-auth/internal/audit"
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+)
+
+type MintRequest struct {
+	// Target is the mapped ServiceAccount (cluster/namespace/serviceaccount); may be
+	// omitted when exactly one mapping applies to the caller
+	Target            string   `json:"target,omitempty"`
+	Audiences         []string `json:"audiences,omitempty"`
+	ExpirationSeconds int64    `json:"expiration_seconds,omitempty"`
+}
+
+type MintResponse struct {
+	Token     string `json:"token,omitempty"`
+	Target    string `json:"target,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// mintError is returned by tokenMinter.mint and tokenIssuer.issue. Denied errors are
+// policy decisions (403), the rest are failures to mint the token (500).
+type mintError struct {
+	denied  bool
+	message string
+}
+
+func (e *mintError) Error() string {
+	return e.message
+}
+
+// tokenMinter mints TokenRequest tokens for ServiceAccounts mapped in exchange.mappings
+// and records every decision in the audit log.
+type tokenMinter struct {
+	clients  ClusterClients
+	exchange *config.ExchangeSettings
+	audit    *audit.Log
+}
+
+// mint requests a token for the ServiceAccount id is mapped to. target selects the
+// mapping and may be empty when exactly one applies; a zero expiration requests the
+// mapping's max_expiration.
+func (m *tokenMinter) mint(ctx context.Context, id *serviceAccountIdentity, target string, audiences []string, expiration time.Duration) (*authv1.TokenRequest, string, *mintError) {
+	event := audit.Event{
+		RequestID: middleware.GetReqID(ctx),
+		Action:    audit.ActionTokenMint,
+		Caller:    id.cluster + "/" + id.namespace + "/" + id.serviceAccount,
+		Target:    target,
+		Audiences: audiences,
+	}
+	deny := func(message string) (*authv1.TokenRequest, string, *mintError) {
+		event.Reason = message
+		m.audit.Record(event)
+		return nil, "", &mintError{denied: true, message: message}
+	}
+
+	mapping, ok := selectMapping(m.exchange.MappingsFor(id.cluster, id.namespace, id.serviceAccount), target)
+	if !ok {
+		if target == "" {
+			return deny("target is required unless exactly one mapping applies")
+		}
+		return deny("caller is not mapped to " + target)
+	}
+	event.Target = mapping.To
+	for _, aud := range audiences {
+		if !mapping.IsAllowedAudience(aud) {
+			return deny("audience " + aud + " is not allowed")
+		}
+	}
+	expiration, err := mapping.BoundExpiration(expiration)
+	if err != nil {
+		return deny(err.Error())
+	}
+
+	event.Allowed = true
+	fail := func(err error) (*authv1.TokenRequest, string, *mintError) {
+		log.Printf("Minting token for %s failed: %v", mapping.To, err)
+		event.Reason = "minting failed: " + err.Error()
+		m.audit.Record(event)
+		return nil, "", &mintError{message: "failed to mint token"}
+	}
+
+	cluster, namespace, serviceAccount := mapping.Target()
+	client, err := m.clients.Clientset(cluster)
+	if err != nil {
+		return fail(err)
+	}
+	result, err := credentials.CreateToken(ctx, client, namespace, serviceAccount, audiences, expiration)
+	if err != nil {
+		return fail(err)
+	}
+
+	expiresAt := result.Status.ExpirationTimestamp.Time
+	event.ExpiresAt = &expiresAt
+	m.audit.Record(event)
+	return result, mapping.To, nil
+}
+
+// selectMapping picks the mapping whose target is resource, or the only mapping if
+// resource is empty
+func selectMapping(mappings []config.ExchangeMapping, resource string) (config.ExchangeMapping, bool) {
+	if resource == "" {
+		if len(mappings) == 1 {
+			return mappings[0], true
+		}
+		return config.ExchangeMapping{}, false
+	}
+	for _, m := range mappings {
+		if m.To == resource {
+			return m, true
+		}
+	}
+	return config.ExchangeMapping{}, false
+}
+
+// TokenRequestHandler implements POST /serviceaccounts/token: an authenticated workload
+// obtains a short-lived token for a ServiceAccount it is mapped to in another cluster.
+// The caller authenticates with its own ServiceAccount token as Bearer, bound to one of
+// exchange.subject_audiences.
+type TokenRequestHandler struct {
+	reviewer *TokenReviewHandler
+	exchange *config.ExchangeSettings
+	minter   *tokenMinter
+}
+
+func NewTokenRequestHandler(reviewer *TokenReviewHandler, cfg *config.Config, auditLog *audit.Log) *TokenRequestHandler {
+	return &TokenRequestHandler{
+		reviewer: reviewer,
+		exchange: cfg.Exchange,
+		minter:   &tokenMinter{clients: reviewer.clients, exchange: cfg.Exchange, audit: auditLog},
+	}
+}
+
+func (h *TokenRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The caller's token is only reviewed below, so callers are limited by address
+	if err := h.reviewer.limitCaller(clientAddress(r), nil, 1); err != nil {
+		logError(r.Context(), "ServiceAccount token request", err)
+		h.writeJSON(w, rejectionStatus(w, r, err, err.Status), MintResponse{Error: err.Message})
+		return
+	}
+
+	const bearerPrefix = "Bearer "
+	callerToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix)
+	if !ok || callerToken == "" {
+		h.writeJSON(w, http.StatusUnauthorized, MintResponse{Error: "Authorization header with Bearer token required"})
+		return
+	}
+	if err := h.reviewer.checkToken(callerToken); err != nil {
+		h.writeJSON(w, err.Status, MintResponse{Error: err.Message})
+		return
+	}
+
+	var req MintRequest
+	maxBytes := h.reviewer.config.GetMaxBodyBytes()
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes)).Decode(&req); err != nil {
+		bodyErr := bodyError(err, maxBytes)
+		h.writeJSON(w, bodyErr.Status, MintResponse{Error: bodyErr.Message})
+		return
+	}
+	expiration := time.Duration(req.ExpirationSeconds) * time.Second
+	if req.ExpirationSeconds < 0 || (expiration > 0 && expiration < config.MinExchangeExpiration) {
+		h.writeJSON(w, http.StatusBadRequest, MintResponse{Error: "expiration_seconds must be at least " + strconv.Itoa(int(config.MinExchangeExpiration.Seconds()))})
+		return
+	}
+
+	id, reviewErr := h.reviewer.reviewServiceAccount(r.Context(), callerToken, h.exchange.SubjectAudiences)
+	if reviewErr != nil {
+		h.writeJSON(w, rejectionStatus(w, r, reviewErr, http.StatusUnauthorized), MintResponse{Error: reviewErr.Message})
+		return
+	}
+
+	result, target, err := h.minter.mint(r.Context(), id, req.Target, req.Audiences, expiration)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err.denied {
+			code = http.StatusForbidden
+		}
+		h.writeJSON(w, code, MintResponse{Error: err.Error()})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, MintResponse{
+		Token:     result.Status.Token,
+		Target:    target,
+		ExpiresAt: result.Status.ExpirationTimestamp.UTC().Format(time.RFC3339),
+	})
+}
+
+func (h *TokenRequestHandler) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
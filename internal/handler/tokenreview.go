@@ -3,21 +3,25 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/sync/singleflight"
 	authv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"github.com/rophy/kube-federated-auth/internal/config"
-	"github.com/rophy/kube-federated-auth/internal/credentials"
 	"github.com/rophy/kube-federated-auth/internal/oidc"
 )
 
+const serviceAccountUsernamePrefix = "system:serviceaccount:"
+
 // ExtraKeyClusterName is the key used in TokenReview response extra field
 // to indicate which cluster the token was validated against.
 const ExtraKeyClusterName = "authentication.kubernetes.io/cluster-name"
@@ -27,64 +31,193 @@ type TokenVerifier interface {
 	Verify(ctx context.Context, clusterName, rawToken string) (*oidc.Claims, error)
 }
 
+// ClusterClients provides Kubernetes API clients for configured clusters.
+type ClusterClients interface {
+	Clientset(clusterName string) (kubernetes.Interface, error)
+}
+
 type TokenReviewHandler struct {
-	verifier  TokenVerifier
-	config    *config.Config
-	credStore *credentials.Store
+	verifier TokenVerifier
+	config   *config.Config
+	clients  ClusterClients
+
+	// inFlight coalesces identical concurrent reviews
+	inFlight singleflight.Group
+
+	// Limits of rate_limits; nil if not enforced
+	callerLimiter  *keyedLimiter
+	clusterLimiter *keyedLimiter
+	forwardSlots   *concurrencyLimiter
 }
 
-func NewTokenReviewHandler(v TokenVerifier, cfg *config.Config, store *credentials.Store) *TokenReviewHandler {
-	return &TokenReviewHandler{
-		verifier:  v,
-		config:    cfg,
-		credStore: store,
+func NewTokenReviewHandler(v TokenVerifier, cfg *config.Config, clients ClusterClients) *TokenReviewHandler {
+	h := &TokenReviewHandler{
+		verifier: v,
+		config:   cfg,
+		clients:  clients,
+	}
+	if cfg != nil && cfg.RateLimits != nil {
+		h.callerLimiter = newKeyedLimiter(cfg.RateLimits.Callers)
+		h.clusterLimiter = newKeyedLimiter(cfg.RateLimits.Clusters)
+		h.forwardSlots = newConcurrencyLimiter(cfg.RateLimits.MaxConcurrentReviews)
 	}
+	return h
 }
 
 func (h *TokenReviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Step 0: Authenticate the caller via their own SA token
-	if h.config != nil && len(h.config.AuthorizedClients) > 0 {
-		if err := h.authenticateCaller(r); err != nil {
-			h.writeError(w, err.code, err.message)
+	// Errors are returned in the caller's format, so the content type is checked first
+	resp := &reviewResponse{w: w, r: r, mediaType: MediaTypeJSON}
+	requestType, err := requestMediaType(r)
+	if err != nil {
+		resp.fail(err)
+		return
+	}
+	resp.mediaType = responseMediaType(r, requestType)
+
+	// Step 0: Authenticate the caller via their own SA token. A trace reveals the
+	// configured clusters, so explaining is restricted to admin callers.
+	var caller *callerIdentity
+	if wantsExplain(r) {
+		// Traces are only rendered in JSON
+		resp.trace = newReviewTrace()
+		resp.mediaType = MediaTypeJSON
+		if h.config == nil {
+			resp.fail(errNotConfiguredForAuth)
 			return
 		}
+		start := time.Now()
+		if caller, err = authenticateCaller(r, h.verifier, h.config, h.config.IsAdminClient); err != nil {
+			resp.fail(err)
+			return
+		}
+		resp.trace.step("authenticate_caller", start)
+	} else if h.config != nil && h.config.HasAuthorizedClients() {
+		if caller, err = authenticateCaller(r, h.verifier, h.config, h.config.IsAuthorizedClient); err != nil {
+			resp.fail(err)
+			return
+		}
+	}
+
+	if err := h.limitCaller(clientAddress(r), caller, 1); err != nil {
+		logError(r.Context(), "TokenReview", err)
+		resp.fail(err)
+		return
 	}
 
 	// Parse TokenReview request
-	var tr authv1.TokenReview
-	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
-		h.writeError(w, http.StatusBadRequest, "invalid request body")
+	tr, err := decodeTokenReview(w, r, requestType, h.config.GetMaxBodyBytes())
+	if err != nil {
+		resp.fail(err)
 		return
 	}
 
-	if tr.Spec.Token == "" {
-		h.writeError(w, http.StatusBadRequest, "token is required")
+	if err := h.checkToken(tr.Spec.Token); err != nil {
+		resp.fail(err)
 		return
 	}
 
 	if h.verifier == nil || h.config == nil {
-		h.writeUnauthenticated(w, &tr, "server not configured")
+		resp.unauthenticated(newError(CodeNotConfigured, http.StatusOK, "server not configured", nil))
 		return
 	}
 
-	// Step 1: Detect cluster via JWKS (local, no token leakage)
-	cluster, err := h.detectCluster(r.Context(), tr.Spec.Token)
+	result, err := h.review(r.Context(), tr, resp.trace)
 	if err != nil {
-		log.Printf("Cluster detection failed: %v", err)
-		h.writeUnauthenticated(w, &tr, "token not valid for any configured cluster")
+		// Rate limited reviews are refused, so clients back off instead of treating the
+		// token as invalid
+		if err.Code == CodeRateLimited {
+			resp.fail(err)
+			return
+		}
+		resp.unauthenticated(err)
 		return
 	}
 
+	// Return the response from the remote cluster
+	resp.review(result.status)
+}
+
+// checkToken rejects empty and oversized tokens
+func (h *TokenReviewHandler) checkToken(token string) *Error {
+	if token == "" {
+		return newError(CodeInvalidRequest, http.StatusBadRequest, "token is required", nil)
+	}
+	if maxBytes := h.config.GetMaxTokenBytes(); len(token) > maxBytes {
+		return newError(CodeInvalidRequest, http.StatusBadRequest, fmt.Sprintf("token exceeds %d bytes", maxBytes), nil)
+	}
+	return nil
+}
+
+// limitCaller applies the caller rate limit to a request for n reviews. Callers are
+// limited by identity, or by address if they aren't authenticated (caller is nil).
+func (h *TokenReviewHandler) limitCaller(address string, caller *callerIdentity, n int) *Error {
+	key := address
+	if caller != nil {
+		key = caller.String()
+	}
+	if retryAfter := h.callerLimiter.reserveN(key, n); retryAfter > 0 {
+		return rateLimited(msgCallerRateLimited, retryAfter, fmt.Errorf("caller %s", key))
+	}
+	return nil
+}
+
+// reviewResult is the outcome of validating a token against its source cluster
+type reviewResult struct {
+	cluster string
+	claims  *oidc.Claims        // claims verified locally via JWKS
+	status  *authv1.TokenReview // response from the source cluster
+}
+
+// reviewToken detects the token's source cluster via JWKS and forwards the TokenReview to it,
+// recording each step in trace if it's not nil. The detail of errors is logged; their
+// message is safe to report to the client.
+func (h *TokenReviewHandler) reviewToken(ctx context.Context, tr *authv1.TokenReview, trace *ReviewTrace) (*reviewResult, *Error) {
+	cluster, claims, err := h.detect(ctx, tr.Spec.Token, trace)
+	if err != nil {
+		return nil, err
+	}
+	return h.forward(ctx, cluster, claims, tr, trace)
+}
+
+// detect is step 1 of a review: detecting the token's cluster via JWKS (local, no token
+// leakage)
+func (h *TokenReviewHandler) detect(ctx context.Context, token string, trace *ReviewTrace) (string, *oidc.Claims, *Error) {
+	start := time.Now()
+	cluster, claims, err := h.detectCluster(ctx, token, trace)
+	trace.step("detect_cluster", start)
+	if err != nil {
+		reviewErr := newError(CodeDetectionFailed, http.StatusUnauthorized, msgDetectionFailed, err)
+		logError(ctx, "Cluster detection", reviewErr)
+		return "", nil, reviewErr
+	}
+
 	log.Printf("Detected cluster: %s", cluster)
+	return cluster, claims, nil
+}
 
-	// Step 2: Forward TokenReview to detected cluster
-	result, err := h.forwardTokenReview(r.Context(), cluster, &tr)
+// forward is step 2 of a review: forwarding the TokenReview to the detected cluster,
+// within its rate and concurrency limits
+func (h *TokenReviewHandler) forward(ctx context.Context, cluster string, claims *oidc.Claims, tr *authv1.TokenReview, trace *ReviewTrace) (*reviewResult, *Error) {
+	if retryAfter := h.clusterLimiter.reserve(cluster); retryAfter > 0 {
+		reviewErr := rateLimited(msgClusterRateLimited, retryAfter, fmt.Errorf("cluster %s", cluster))
+		logError(ctx, "TokenReview forwarding", reviewErr)
+		return nil, reviewErr
+	}
+	release, ok := h.forwardSlots.acquire(cluster)
+	if !ok {
+		reviewErr := rateLimited(msgClusterBusy, time.Second, fmt.Errorf("cluster %s", cluster))
+		logError(ctx, "TokenReview forwarding", reviewErr)
+		return nil, reviewErr
+	}
+	start := time.Now()
+	result, err := h.forwardTokenReview(ctx, cluster, tr)
+	release()
+	trace.forward(h.clients, cluster, start, err)
+	trace.step("forward_tokenreview", start)
 	if err != nil {
-		log.Printf("TokenReview forwarding failed for cluster %s: %v", cluster, err)
-		h.writeUnauthenticated(w, &tr, fmt.Sprintf("failed to validate token: %v", err))
-		return
+		reviewErr := forwardError(fmt.Errorf("cluster %s: %w", cluster, err))
+		logError(ctx, "TokenReview forwarding", reviewErr)
+		return nil, reviewErr
 	}
 
 	// Add cluster name to extra field for client awareness
@@ -95,46 +228,64 @@ func (h *TokenReviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		result.Status.User.Extra[ExtraKeyClusterName] = authv1.ExtraValue{cluster}
 	}
 
-	// Return the response from the remote cluster
-	json.NewEncoder(w).Encode(result)
+	return &reviewResult{cluster: cluster, claims: claims, status: result}, nil
+}
+
+// authorizeFunc decides whether a verified caller identity may proceed.
+type authorizeFunc func(cluster, namespace, serviceAccount string) bool
+
+// callerIdentity is a caller authenticated by its own ServiceAccount token
+type callerIdentity struct {
+	cluster        string
+	namespace      string
+	serviceAccount string
 }
 
-type authError struct {
-	code    int
-	message string
+func (c *callerIdentity) String() string {
+	return c.cluster + "/" + c.namespace + "/" + c.serviceAccount
 }
 
-func (e *authError) Error() string {
-	return e.message
+// authenticateCaller verifies the caller's own ServiceAccount token from the Authorization header
+// and checks the resulting identity with authorize.
+// Returns the caller if authorized, or an Error with appropriate HTTP status.
+func authenticateCaller(r *http.Request, verifier TokenVerifier, cfg *config.Config, authorize authorizeFunc) (*callerIdentity, *Error) {
+	return authenticateBearer(r.Context(), r.Header.Get("Authorization"), verifier, cfg, authorize)
 }
 
-// authenticateCaller verifies the caller's own ServiceAccount token from the Authorization header.
-// Returns nil if the caller is authorized, or an authError with appropriate HTTP status.
-func (h *TokenReviewHandler) authenticateCaller(r *http.Request) *authError {
-	authHeader := r.Header.Get("Authorization")
+// authenticateBearer is authenticateCaller for the value of an Authorization header
+// received by other means
+func authenticateBearer(ctx context.Context, authHeader string, verifier TokenVerifier, cfg *config.Config, authorize authorizeFunc) (*callerIdentity, *Error) {
+	unauthenticated := func(message string) (*callerIdentity, *Error) {
+		return nil, newError(CodeUnauthenticatedCaller, http.StatusUnauthorized, message, nil)
+	}
+
 	if authHeader == "" {
-		return &authError{http.StatusUnauthorized, "Authorization header required"}
+		return unauthenticated("Authorization header required")
 	}
 
 	const bearerPrefix = "Bearer "
 	if !strings.HasPrefix(authHeader, bearerPrefix) {
-		return &authError{http.StatusUnauthorized, "Authorization header must use Bearer scheme"}
+		return unauthenticated("Authorization header must use Bearer scheme")
 	}
 
 	callerToken := strings.TrimPrefix(authHeader, bearerPrefix)
 	if callerToken == "" {
-		return &authError{http.StatusUnauthorized, "bearer token is empty"}
+		return unauthenticated("bearer token is empty")
+	}
+	if len(callerToken) > cfg.GetMaxTokenBytes() {
+		return unauthenticated("bearer token is too large")
 	}
 
-	if h.verifier == nil {
-		return &authError{http.StatusInternalServerError, "server not configured for authentication"}
+	if verifier == nil {
+		return nil, errNotConfiguredForAuth
 	}
 
 	// Verify caller's token via JWKS to find the source cluster
 	var callerCluster string
 	var callerClaims *oidc.Claims
-	for clusterName := range h.config.Clusters {
-		claims, err := h.verifier.Verify(r.Context(), clusterName, callerToken)
+	candidates, _ := candidateClusters(verifier, cfg, callerToken)
+	for _, clusterName := range candidates {
+		claims, err := verifier.Verify(ctx, clusterName, callerToken)
 		if err == nil {
 			callerCluster = clusterName
 			callerClaims = claims
@@ -143,23 +294,77 @@ func (h *TokenReviewHandler) authenticateCaller(r *http.Request) *authError {
 	}
 
 	if callerClaims == nil {
-		return &authError{http.StatusUnauthorized, "caller token not valid for any configured cluster"}
+		return unauthenticated("caller token not valid for any configured cluster")
 	}
 
 	// Extract namespace and service account from claims
 	namespace, saName := extractIdentity(callerClaims)
 	if namespace == "" || saName == "" {
-		return &authError{http.StatusUnauthorized, "caller token missing identity claims"}
+		return unauthenticated("caller token missing identity claims")
 	}
 
-	// Check against authorized_clients whitelist
-	if !h.config.IsAuthorizedClient(callerCluster, namespace, saName) {
-		log.Printf("Unauthorized caller: %s/%s/%s", callerCluster, namespace, saName)
-		return &authError{http.StatusForbidden, fmt.Sprintf("caller %s/%s/%s is not authorized", callerCluster, namespace, saName)}
+	// Check against the whitelist for this endpoint
+	if !authorize(callerCluster, namespace, saName) {
+		log.Printf("Unauthorized caller (request %s): %s/%s/%s", middleware.GetReqID(ctx), callerCluster, namespace, saName)
+		return nil, newError(CodeUnauthorizedCaller, http.StatusForbidden, "caller is not authorized", nil)
 	}
 
 	log.Printf("Authorized caller: %s/%s/%s", callerCluster, namespace, saName)
-	return nil
+	return &callerIdentity{cluster: callerCluster, namespace: namespace, serviceAccount: saName}, nil
+}
+
+// serviceAccountIdentity is a ServiceAccount whose token was validated by its source cluster
+type serviceAccountIdentity struct {
+	cluster        string
+	namespace      string
+	serviceAccount string
+	user           authv1.UserInfo
+	expiry         time.Time // subject token expiry, zero if unknown
+}
+
+// reviewServiceAccount validates a ServiceAccount token with its source cluster, not just
+// its signature, so tokens of deleted pods and ServiceAccounts are rejected. The token is
+// reviewed for audiences, or the API server's if empty.
+// The returned error's message is safe to report to the client.
+func (h *TokenReviewHandler) reviewServiceAccount(ctx context.Context, token string, audiences []string) (*serviceAccountIdentity, *Error) {
+	result, err := h.review(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: audiences},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !result.status.Status.Authenticated {
+		return nil, newError(CodeTokenRejected, http.StatusUnauthorized, msgTokenRejected, errors.New(result.status.Status.Error))
+	}
+
+	namespace, serviceAccount, ok := parseServiceAccountUsername(result.status.Status.User.Username)
+	if !ok {
+		return nil, newError(CodeNotServiceAccount, http.StatusUnauthorized, msgNotServiceAccount, fmt.Errorf("username %q", result.status.Status.User.Username))
+	}
+
+	id := &serviceAccountIdentity{
+		cluster:        result.cluster,
+		namespace:      namespace,
+		serviceAccount: serviceAccount,
+		user:           result.status.Status.User,
+	}
+	if result.claims != nil && result.claims.Expiry > 0 {
+		id.expiry = time.Unix(result.claims.Expiry, 0)
+	}
+	return id, nil
+}
+
+// parseServiceAccountUsername splits "system:serviceaccount:<namespace>:<name>"
+func parseServiceAccountUsername(username string) (namespace, name string, ok bool) {
+	rest, found := strings.CutPrefix(username, serviceAccountUsernamePrefix)
+	if !found {
+		return "", "", false
+	}
+	namespace, name, found = strings.Cut(rest, ":")
+	if !found || namespace == "" || name == "" || strings.Contains(name, ":") {
+		return "", "", false
+	}
+	return namespace, name, true
 }
 
 // extractIdentity extracts namespace and service account name from OIDC claims.
@@ -183,34 +388,47 @@ func extractIdentity(claims *oidc.Claims) (namespace, serviceAccount string) {
 
 // detectCluster tries to verify the token against all configured clusters using JWKS.
 // This is done locally without sending the token anywhere.
-// Returns the cluster name that successfully verified the token signature.
-func (h *TokenReviewHandler) detectCluster(ctx context.Context, token string) (string, error) {
-	for clusterName := range h.config.Clusters {
-		_, err := h.verifier.Verify(ctx, clusterName, token)
+// Returns the cluster name that successfully verified the token signature and the verified claims.
+func (h *TokenReviewHandler) detectCluster(ctx context.Context, token string, trace *ReviewTrace) (string, *oidc.Claims, error) {
+	candidates, err := candidateClusters(h.verifier, h.config, token)
+	if err != nil {
+		trace.rejected(err)
+		return "", nil, fmt.Errorf("rejecting token: %w", err)
+	}
+	trace.skipped(h.config.ClusterNames(), candidates)
+
+	for _, clusterName := range candidates {
+		cache := trace.cacheState(h.verifier, clusterName)
+		start := time.Now()
+		claims, err := h.verifier.Verify(ctx, clusterName, token)
+		trace.verification(ctx, h.verifier, clusterName, token, cache, start, err)
 		if err == nil {
-			return clusterName, nil
+			return clusterName, claims, nil
 		}
 		// Signature didn't match - try next cluster
 		log.Printf("Token not valid for cluster %s: %v", clusterName, err)
 	}
-	return "", fmt.Errorf("token signature does not match any configured cluster")
+	return "", nil, fmt.Errorf("token signature does not match any configured cluster")
 }
 
-// forwardTokenReview sends the TokenReview request to the detected cluster's API server.
-func (h *TokenReviewHandler) forwardTokenReview(ctx context.Context, clusterName string, tr *authv1.TokenReview) (*authv1.TokenReview, error) {
-	clusterCfg, ok := h.config.Clusters[clusterName]
-	if !ok {
-		return nil, fmt.Errorf("cluster not found: %s", clusterName)
-	}
+// candidateSelector is implemented by verifiers that narrow the clusters a token may
+// come from without verifying it
+type candidateSelector interface {
+	Candidates(rawToken string) ([]string, error)
+}
 
-	// Build REST config for the target cluster
-	restConfig, err := h.buildRESTConfig(clusterName, clusterCfg)
-	if err != nil {
-		return nil, fmt.Errorf("building REST config: %w", err)
+// candidateClusters returns the clusters to verify a token against, in order
+func candidateClusters(verifier TokenVerifier, cfg *config.Config, token string) ([]string, error) {
+	if selector, ok := verifier.(candidateSelector); ok {
+		return selector.Candidates(token)
 	}
+	return cfg.ClusterNames(), nil
+}
 
-	// Create Kubernetes client
-	client, err := kubernetes.NewForConfig(restConfig)
+// forwardTokenReview sends the TokenReview request to the detected cluster's API server.
+func (h *TokenReviewHandler) forwardTokenReview(ctx context.Context, clusterName string, tr *authv1.TokenReview) (*authv1.TokenReview, error) {
+	// Kubernetes client for the target cluster, shared across requests
+	client, err := h.clients.Clientset(clusterName)
 	if err != nil {
 		return nil, fmt.Errorf("creating kubernetes client: %w", err)
 	}
@@ -228,67 +446,42 @@ func (h *TokenReviewHandler) forwardTokenReview(ctx context.Context, clusterName
 	return result, nil
 }
 
-// buildRESTConfig creates a REST config for the target cluster
-func (h *TokenReviewHandler) buildRESTConfig(clusterName string, clusterCfg config.ClusterConfig) (*rest.Config, error) {
-	// For clusters with api_server, use remote credentials
-	if clusterCfg.APIServer != "" {
-		var bearerToken string
-		var caCert []byte
-
-		if h.credStore != nil {
-			if creds, ok := h.credStore.Get(clusterName); ok {
-				bearerToken = creds.Token
-				caCert = creds.CACert
-			}
-		}
-
-		return &rest.Config{
-			Host:        clusterCfg.APIServer,
-			BearerToken: bearerToken,
-			TLSClientConfig: rest.TLSClientConfig{
-				CAData: caCert,
-			},
-		}, nil
-	}
+// reviewResponse writes the response to a TokenReview request in the negotiated media
+// type, with the trace if explaining
+type reviewResponse struct {
+	w         http.ResponseWriter
+	r         *http.Request
+	mediaType string
+	trace     *ReviewTrace
+}
 
-	// For local clusters, try in-cluster config first
-	inClusterConfig, err := rest.InClusterConfig()
-	if err == nil {
-		return inClusterConfig, nil
+// review writes a TokenReview
+func (resp *reviewResponse) review(tr *authv1.TokenReview) {
+	if resp.trace != nil {
+		resp.w.Header().Set("Content-Type", MediaTypeJSON)
+		json.NewEncoder(resp.w).Encode(explainedTokenReview{TokenReview: tr, Trace: resp.trace.finish()})
+		return
 	}
-
-	// Fallback: use issuer as host (for testing)
-	return &rest.Config{
-		Host: clusterCfg.Issuer,
-	}, nil
+	encodeObject(resp.w, resp.mediaType, http.StatusOK, tr)
 }
 
-func (h *TokenReviewHandler) writeUnauthenticated(w http.ResponseWriter, req *authv1.TokenReview, errMsg string) {
-	resp := &authv1.TokenReview{
+// unauthenticated answers a TokenReview the server couldn't resolve
+func (resp *reviewResponse) unauthenticated(err *Error) {
+	setErrorHeaders(resp.w, resp.r, err)
+	resp.review(&authv1.TokenReview{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "authentication.k8s.io/v1",
 			Kind:       "TokenReview",
 		},
 		Status: authv1.TokenReviewStatus{
 			Authenticated: false,
-			Error:         errMsg,
+			Error:         err.Message,
 		},
-	}
-
-	json.NewEncoder(w).Encode(resp)
+	})
 }
 
-func (h *TokenReviewHandler) writeError(w http.ResponseWriter, code int, msg string) {
-	w.WriteHeader(code)
-	resp := &authv1.TokenReview{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "authentication.k8s.io/v1",
-			Kind:       "TokenReview",
-		},
-		Status: authv1.TokenReviewStatus{
-			Authenticated: false,
-			Error:         msg,
-		},
-	}
-	json.NewEncoder(w).Encode(resp)
+// fail rejects a request that wasn't reviewed with a Status, like kube-apiserver
+func (resp *reviewResponse) fail(err *Error) {
+	setErrorHeaders(resp.w, resp.r, err)
+	encodeObject(resp.w, resp.mediaType, err.Status, statusFor(err))
 }
@@ -0,0 +1,212 @@
+// Package issuer signs federated tokens with kube-federated-auth's own key and
+// publishes the OIDC discovery document and JWKS needed to verify them.
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// JWKSPath is where the issuer's public keys are served, relative to the issuer URL
+const JWKSPath = "/openid/v1/jwks"
+
+// minRSAKeyBits is the smallest RSA signing key accepted
+const minRSAKeyBits = 2048
+
+// KubernetesClaims mirrors the "kubernetes.io" claim of ServiceAccount tokens so
+// consumers can read issued tokens the same way as cluster-issued ones.
+type KubernetesClaims struct {
+	Namespace      string       `json:"namespace"`
+	ServiceAccount *ObjectClaim `json:"serviceaccount,omitempty"`
+	Pod            *ObjectClaim `json:"pod,omitempty"`
+}
+
+// ObjectClaim identifies a Kubernetes object by name and UID
+type ObjectClaim struct {
+	Name string `json:"name"`
+	UID  string `json:"uid,omitempty"`
+}
+
+// Claims are the claims of an issued token
+type Claims struct {
+	Issuer     string            `json:"iss"`
+	Subject    string            `json:"sub"`
+	Audience   []string          `json:"aud"`
+	Expiry     int64             `json:"exp"`
+	IssuedAt   int64             `json:"iat"`
+	NotBefore  int64             `json:"nbf"`
+	ID         string            `json:"jti"`
+	Cluster    string            `json:"cluster"`
+	Kubernetes *KubernetesClaims `json:"kubernetes.io,omitempty"`
+}
+
+// Discovery is the OIDC discovery document served at /.well-known/openid-configuration
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Issuer signs tokens with a single private key
+type Issuer struct {
+	url       string
+	algorithm jose.SignatureAlgorithm
+	signer    jose.Signer
+	publicKey jose.JSONWebKey
+	now       func() time.Time
+}
+
+// Subject returns the normalized subject for a ServiceAccount in a cluster
+func Subject(cluster, namespace, serviceAccount string) string {
+	return cluster + ":" + namespace + ":" + serviceAccount
+}
+
+// New creates an issuer for the given URL, loading the PEM-encoded private key
+// (RSA, or ECDSA P-256/P-384) from keyPath
+func New(issuerURL, keyPath string) (*Issuer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key %s: %w", keyPath, err)
+	}
+	return NewFromKey(issuerURL, key)
+}
+
+// NewFromKey creates an issuer for the given URL and private key
+func NewFromKey(issuerURL string, key crypto.Signer) (*Issuer, error) {
+	algorithm, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := jose.JSONWebKey{Key: key.Public(), Algorithm: string(algorithm), Use: "sig"}
+	thumbprint, err := publicKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("computing key ID: %w", err)
+	}
+	publicKey.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: jose.JSONWebKey{Key: key, KeyID: publicKey.KeyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating signer: %w", err)
+	}
+
+	return &Issuer{
+		url:       strings.TrimSuffix(issuerURL, "/"),
+		algorithm: algorithm,
+		signer:    signer,
+		publicKey: publicKey,
+		now:       time.Now,
+	}, nil
+}
+
+// URL returns the issuer URL used as the "iss" claim
+func (i *Issuer) URL() string {
+	return i.url
+}
+
+// Issue signs a token with the given claims. Issuer, issued-at, not-before and token ID
+// are filled in; Subject, Audience and Expiry must be set by the caller.
+func (i *Issuer) Issue(claims Claims) (string, error) {
+	if claims.Subject == "" || len(claims.Audience) == 0 || claims.Expiry == 0 {
+		return "", fmt.Errorf("subject, audience and expiry are required")
+	}
+
+	now := i.now()
+	claims.Issuer = i.url
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	if claims.Expiry <= claims.IssuedAt {
+		return "", fmt.Errorf("expiry must be in the future")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("generating token ID: %w", err)
+	}
+	claims.ID = hex.EncodeToString(id)
+
+	return jwt.Signed(i.signer).Claims(claims).Serialize()
+}
+
+// Discovery returns the OIDC discovery document
+func (i *Issuer) Discovery() Discovery {
+	return Discovery{
+		Issuer:                           i.url,
+		JWKSURI:                          i.url + JWKSPath,
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{string(i.algorithm)},
+	}
+}
+
+// JWKS returns the public key set for verifying issued tokens
+func (i *Issuer) JWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{i.publicKey}}
+}
+
+func signatureAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return "", fmt.Errorf("RSA signing key must be at least %d bits, got %d", minRSAKeyBits, k.N.BitLen())
+		}
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		}
+		return "", fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+	}
+	return "", fmt.Errorf("unsupported signing key type %T", key)
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
@@ -0,0 +1,167 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+const testIssuerURL = "https://federated.example.com"
+
+func newTestIssuer(t *testing.T) *Issuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss, err := NewFromKey(testIssuerURL+"/", key)
+	if err != nil {
+		t.Fatalf("NewFromKey: %v", err)
+	}
+	return iss
+}
+
+func TestIssue_VerifiesWithPublishedJWKS(t *testing.T) {
+	iss := newTestIssuer(t)
+
+	token, err := iss.Issue(Claims{
+		Subject:  Subject("cluster-b", "apps", "deployer"),
+		Audience: []string{"sts.amazonaws.com"},
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+		Cluster:  "cluster-b",
+		Kubernetes: &KubernetesClaims{
+			Namespace:      "apps",
+			ServiceAccount: &ObjectClaim{Name: "deployer", UID: "uid-1"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	jwks := iss.JWKS()
+	keySet := &oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{jwks.Keys[0].Key}}
+	verifier := oidc.NewVerifier(testIssuerURL, keySet, &oidc.Config{ClientID: "sts.amazonaws.com", SupportedSigningAlgs: []string{"ES256"}})
+
+	idToken, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if idToken.Subject != "cluster-b:apps:deployer" {
+		t.Errorf("sub = %q, want %q", idToken.Subject, "cluster-b:apps:deployer")
+	}
+
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Cluster != "cluster-b" || claims.Kubernetes == nil || claims.Kubernetes.ServiceAccount.UID != "uid-1" {
+		t.Errorf("claims = %+v, want cluster and kubernetes.io claims", claims)
+	}
+	if claims.ID == "" || claims.IssuedAt == 0 || claims.NotBefore == 0 {
+		t.Errorf("expected jti, iat and nbf to be set, got %+v", claims)
+	}
+}
+
+func TestIssue_RequiresClaims(t *testing.T) {
+	iss := newTestIssuer(t)
+
+	tests := map[string]Claims{
+		"missing subject":  {Audience: []string{"aud"}, Expiry: time.Now().Add(time.Hour).Unix()},
+		"missing audience": {Subject: "c:ns:sa", Expiry: time.Now().Add(time.Hour).Unix()},
+		"missing expiry":   {Subject: "c:ns:sa", Audience: []string{"aud"}},
+		"expired":          {Subject: "c:ns:sa", Audience: []string{"aud"}, Expiry: time.Now().Add(-time.Minute).Unix()},
+	}
+	for name, claims := range tests {
+		if _, err := iss.Issue(claims); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	iss := newTestIssuer(t)
+
+	d := iss.Discovery()
+	if d.Issuer != testIssuerURL {
+		t.Errorf("issuer = %q, want %q", d.Issuer, testIssuerURL)
+	}
+	if d.JWKSURI != testIssuerURL+JWKSPath {
+		t.Errorf("jwks_uri = %q, want %q", d.JWKSURI, testIssuerURL+JWKSPath)
+	}
+	if len(d.IDTokenSigningAlgValuesSupported) != 1 || d.IDTokenSigningAlgValuesSupported[0] != "ES256" {
+		t.Errorf("algs = %v, want [ES256]", d.IDTokenSigningAlgValuesSupported)
+	}
+
+	jwks := iss.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID == "" || !jwks.Keys[0].IsPublic() {
+		t.Errorf("jwks = %+v, want one public key with a kid", jwks)
+	}
+}
+
+func TestNew_KeyFormats(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	pkcs8DER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		wantAlg string
+	}{
+		{"PKCS1 RSA", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, "RS256"},
+		{"PKCS8 RSA", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}, "RS256"},
+		{"EC P-384", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, "ES384"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key.pem")
+			os.WriteFile(path, pem.EncodeToMemory(tt.block), 0600)
+
+			iss, err := New(testIssuerURL, path)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if got := iss.Discovery().IDTokenSigningAlgValuesSupported[0]; got != tt.wantAlg {
+				t.Errorf("alg = %q, want %q", got, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestNew_RejectsWeakOrInvalidKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFromKey(testIssuerURL, weak); err == nil {
+		t.Error("expected error for 1024-bit RSA key")
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(path, []byte("not a key"), 0600)
+	if _, err := New(testIssuerURL, path); err == nil {
+		t.Error("expected error for invalid PEM")
+	}
+
+	if _, err := New(testIssuerURL, "/nonexistent/key.pem"); err == nil {
+		t.Error("expected error for missing key file")
+	}
+}
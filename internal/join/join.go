@@ -0,0 +1,297 @@
+// Package join onboards a remote cluster: it creates the ServiceAccount and RBAC the
+// server needs there, requests a bootstrap token and renders the config to add.
+package join
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+	"github.com/rophy/kube-federated-auth/internal/credentials"
+	"github.com/rophy/kube-federated-auth/internal/oidc"
+)
+
+// Names of the RBAC objects created in the remote cluster
+const (
+	TokenReviewRole  = "kube-federated-auth-tokenreview"
+	TokenCreatorRole = "kube-federated-auth-token-creator"
+)
+
+// Defaults for Options
+const (
+	DefaultNamespace      = "kube-federated-auth"
+	DefaultServiceAccount = "kube-federated-auth"
+	DefaultMountPath      = "/etc/kube-federated-auth/clusters"
+)
+
+// Keys of the bootstrap Secret, as read by FederatedCluster secret references
+const (
+	TokenKey = "token"
+	CAKey    = "ca.crt"
+)
+
+// Options configures the objects created in the remote cluster
+type Options struct {
+	// Namespace and ServiceAccount in the remote cluster the server authenticates as
+	Namespace      string
+	ServiceAccount string
+	// TokenDuration is the requested lifetime of the bootstrap token
+	TokenDuration time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+	if o.ServiceAccount == "" {
+		o.ServiceAccount = DefaultServiceAccount
+	}
+	if o.TokenDuration == 0 {
+		o.TokenDuration = config.DefaultRenewalTokenDuration
+	}
+	return o
+}
+
+// Result is what the server needs to federate the remote cluster
+type Result struct {
+	Issuer    string
+	APIServer string
+	Token     string
+	CACert    []byte
+	// Applied describes each object created or updated, e.g. "ServiceAccount kube-federated-auth/kube-federated-auth created"
+	Applied []string
+}
+
+// Join prepares the cluster of restConfig for federation
+func Join(ctx context.Context, restConfig *rest.Config, opts Options) (*Result, error) {
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP client: %w", err)
+	}
+	kube, err := kubernetes.NewForConfigAndClient(restConfig, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+
+	caCert := restConfig.CAData
+	if len(caCert) == 0 && restConfig.CAFile != "" {
+		if caCert, err = os.ReadFile(restConfig.CAFile); err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+	}
+	return join(ctx, kube, httpClient, restConfig.Host, caCert, opts)
+}
+
+func join(ctx context.Context, kube kubernetes.Interface, httpClient *http.Client, host string, caCert []byte, opts Options) (*Result, error) {
+	opts = opts.withDefaults()
+
+	issuer, err := oidc.DiscoverIssuer(ctx, httpClient, host)
+	if err != nil {
+		return nil, fmt.Errorf("discovering issuer: %w", err)
+	}
+
+	// Without a CA in the kubeconfig the API server certificate is signed by the
+	// cluster CA, which every namespace publishes
+	if len(caCert) == 0 {
+		cm, err := kube.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(ctx, "kube-root-ca.crt", metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("reading cluster CA: %w", err)
+		}
+		caCert = []byte(cm.Data[CAKey])
+		if len(caCert) == 0 {
+			return nil, fmt.Errorf("configmap kube-system/kube-root-ca.crt has no %s", CAKey)
+		}
+	}
+
+	applied, err := EnsureRBAC(ctx, kube, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRequest, err := credentials.CreateToken(ctx, kube, opts.Namespace, opts.ServiceAccount, nil, opts.TokenDuration)
+	if err != nil {
+		return nil, fmt.Errorf("requesting bootstrap token: %w", err)
+	}
+
+	return &Result{
+		Issuer:    issuer,
+		APIServer: host,
+		Token:     tokenRequest.Status.Token,
+		CACert:    caCert,
+		Applied:   applied,
+	}, nil
+}
+
+// EnsureRBAC creates or updates the ServiceAccount and its permissions: TokenReview
+// for forwarding, and TokenRequest for itself for credential renewal
+func EnsureRBAC(ctx context.Context, kube kubernetes.Interface, opts Options) ([]string, error) {
+	opts = opts.withDefaults()
+	subject := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: opts.Namespace, Name: opts.ServiceAccount}
+
+	steps := []func() (string, error){
+		func() (string, error) {
+			return apply(ctx, "Namespace", kube.CoreV1().Namespaces(),
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: opts.Namespace}}, nil)
+		},
+		func() (string, error) {
+			return apply(ctx, "ServiceAccount", kube.CoreV1().ServiceAccounts(opts.Namespace),
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: opts.ServiceAccount, Namespace: opts.Namespace}}, nil)
+		},
+		func() (string, error) {
+			rules := []rbacv1.PolicyRule{{
+				APIGroups: []string{"authentication.k8s.io"},
+				Resources: []string{"tokenreviews"},
+				Verbs:     []string{"create"},
+			}}
+			return apply(ctx, "ClusterRole", kube.RbacV1().ClusterRoles(),
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: TokenReviewRole}, Rules: rules},
+				func(existing *rbacv1.ClusterRole) bool { return setRules(&existing.Rules, rules) })
+		},
+		func() (string, error) {
+			roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: TokenReviewRole}
+			return apply(ctx, "ClusterRoleBinding", kube.RbacV1().ClusterRoleBindings(),
+				&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: TokenReviewRole}, Subjects: []rbacv1.Subject{subject}, RoleRef: roleRef},
+				func(existing *rbacv1.ClusterRoleBinding) bool { return addSubject(&existing.Subjects, subject) })
+		},
+		func() (string, error) {
+			rules := []rbacv1.PolicyRule{{
+				APIGroups:     []string{""},
+				Resources:     []string{"serviceaccounts/token"},
+				ResourceNames: []string{opts.ServiceAccount},
+				Verbs:         []string{"create"},
+			}}
+			return apply(ctx, "Role", kube.RbacV1().Roles(opts.Namespace),
+				&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: TokenCreatorRole, Namespace: opts.Namespace}, Rules: rules},
+				func(existing *rbacv1.Role) bool { return setRules(&existing.Rules, rules) })
+		},
+		func() (string, error) {
+			roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: TokenCreatorRole}
+			return apply(ctx, "RoleBinding", kube.RbacV1().RoleBindings(opts.Namespace),
+				&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: TokenCreatorRole, Namespace: opts.Namespace}, Subjects: []rbacv1.Subject{subject}, RoleRef: roleRef},
+				func(existing *rbacv1.RoleBinding) bool { return addSubject(&existing.Subjects, subject) })
+		},
+	}
+
+	var applied []string
+	for _, step := range steps {
+		result, err := step()
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, result)
+	}
+	return applied, nil
+}
+
+// objectClient is the subset of a typed client used by apply
+type objectClient[T metav1.Object] interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (T, error)
+	Create(ctx context.Context, obj T, opts metav1.CreateOptions) (T, error)
+	Update(ctx context.Context, obj T, opts metav1.UpdateOptions) (T, error)
+}
+
+// apply creates desired, or updates the existing object if reconcile reports a change.
+// A nil reconcile leaves existing objects alone.
+func apply[T metav1.Object](ctx context.Context, kind string, client objectClient[T], desired T, reconcile func(existing T) bool) (string, error) {
+	name := desired.GetName()
+	if desired.GetNamespace() != "" {
+		name = desired.GetNamespace() + "/" + name
+	}
+
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if _, err := client.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			return "", fmt.Errorf("creating %s %s: %w", kind, name, err)
+		}
+		return fmt.Sprintf("%s %s created", kind, name), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting %s %s: %w", kind, name, err)
+	}
+
+	if reconcile == nil || !reconcile(existing) {
+		return fmt.Sprintf("%s %s unchanged", kind, name), nil
+	}
+	if _, err := client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("updating %s %s: %w", kind, name, err)
+	}
+	return fmt.Sprintf("%s %s configured", kind, name), nil
+}
+
+func setRules(rules *[]rbacv1.PolicyRule, desired []rbacv1.PolicyRule) bool {
+	if slices.EqualFunc(*rules, desired, func(a, b rbacv1.PolicyRule) bool {
+		return slices.Equal(a.APIGroups, b.APIGroups) && slices.Equal(a.Resources, b.Resources) &&
+			slices.Equal(a.ResourceNames, b.ResourceNames) && slices.Equal(a.Verbs, b.Verbs)
+	}) {
+		return false
+	}
+	*rules = desired
+	return true
+}
+
+// addSubject adds subject to a binding shared with other ServiceAccounts
+func addSubject(subjects *[]rbacv1.Subject, subject rbacv1.Subject) bool {
+	if slices.Contains(*subjects, subject) {
+		return false
+	}
+	*subjects = append(*subjects, subject)
+	return true
+}
+
+// ClusterConfig returns the config entry for a joined cluster whose bootstrap Secret
+// is mounted at mountPath
+func (r *Result) ClusterConfig(mountPath string) config.ClusterConfig {
+	return config.ClusterConfig{
+		Issuer:    r.Issuer,
+		APIServer: r.APIServer,
+		CACert:    mountPath + "/" + CAKey,
+		TokenPath: mountPath + "/" + TokenKey,
+	}
+}
+
+// Secret returns the bootstrap Secret to create in the server's namespace
+func (r *Result) Secret(name, namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			TokenKey: r.Token,
+			CAKey:    string(r.CACert),
+		},
+	}
+}
+
+// WriteClusterConfig writes a clusters stanza for the config file
+func WriteClusterConfig(w io.Writer, name string, cluster config.ClusterConfig) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]map[string]config.ClusterConfig{"clusters": {name: cluster}}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// WriteSecret writes a Secret manifest
+func WriteSecret(w io.Writer, secret *corev1.Secret) error {
+	data, err := sigsyaml.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
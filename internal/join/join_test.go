@@ -0,0 +1,186 @@
+package join
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	authv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakeClient(objects ...runtime.Object) *kubefake.Clientset {
+	kube := kubefake.NewSimpleClientset(objects...)
+	kube.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(k8stesting.CreateActionImpl)
+		if !ok || action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tr := create.GetObject().(*authv1.TokenRequest)
+		tr.Status.Token = fmt.Sprintf("token-for-%s/%s-%ds", action.GetNamespace(), create.Name, *tr.Spec.ExpirationSeconds)
+		return true, tr, nil
+	})
+	return kube
+}
+
+func newDiscoveryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"issuer":"https://oidc.cluster-b.example.com","jwks_uri":"https://oidc.cluster-b.example.com/openid/v1/jwks"}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJoin(t *testing.T) {
+	srv := newDiscoveryServer(t)
+	kube := newFakeClient()
+	ctx := context.Background()
+
+	result, err := join(ctx, kube, srv.Client(), srv.URL, []byte("ca-pem"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Issuer != "https://oidc.cluster-b.example.com" || result.APIServer != srv.URL {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.Token != "token-for-kube-federated-auth/kube-federated-auth-604800s" {
+		t.Errorf("unexpected token %q", result.Token)
+	}
+	if string(result.CACert) != "ca-pem" {
+		t.Errorf("unexpected CA %q", result.CACert)
+	}
+	for _, applied := range result.Applied {
+		if !strings.HasSuffix(applied, " created") {
+			t.Errorf("expected all objects to be created, got %q", applied)
+		}
+	}
+
+	role, err := kube.RbacV1().Roles(DefaultNamespace).Get(ctx, TokenCreatorRole, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := role.Rules[0]; !slices.Equal(rule.Resources, []string{"serviceaccounts/token"}) || !slices.Equal(rule.ResourceNames, []string{DefaultServiceAccount}) {
+		t.Errorf("unexpected token creator rule: %+v", rule)
+	}
+	binding, err := kube.RbacV1().ClusterRoleBindings().Get(ctx, TokenReviewRole, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.RoleRef.Name != TokenReviewRole || len(binding.Subjects) != 1 || binding.Subjects[0].Name != DefaultServiceAccount {
+		t.Errorf("unexpected ClusterRoleBinding: %+v", binding)
+	}
+
+	// Joining again is a no-op apart from the new token
+	result, err = join(ctx, kube, srv.Client(), srv.URL, []byte("ca-pem"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, applied := range result.Applied {
+		if !strings.HasSuffix(applied, " unchanged") {
+			t.Errorf("expected all objects to be unchanged, got %q", applied)
+		}
+	}
+}
+
+func TestJoin_SharedClusterRoleBinding(t *testing.T) {
+	srv := newDiscoveryServer(t)
+	other := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: "federation-staging", Name: "kube-federated-auth"}
+	kube := newFakeClient(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: TokenReviewRole},
+		Subjects:   []rbacv1.Subject{other},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: TokenReviewRole},
+	})
+
+	result, err := join(context.Background(), kube, srv.Client(), srv.URL, []byte("ca-pem"), Options{Namespace: "federation", ServiceAccount: "reviewer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(result.Applied, "ClusterRoleBinding "+TokenReviewRole+" configured") {
+		t.Errorf("expected ClusterRoleBinding to be updated, got %v", result.Applied)
+	}
+
+	binding, _ := kube.RbacV1().ClusterRoleBindings().Get(context.Background(), TokenReviewRole, metav1.GetOptions{})
+	want := []rbacv1.Subject{other, {Kind: rbacv1.ServiceAccountKind, Namespace: "federation", Name: "reviewer"}}
+	if !slices.Equal(binding.Subjects, want) {
+		t.Errorf("subjects = %v, want %v", binding.Subjects, want)
+	}
+}
+
+func TestJoin_ClusterCAFallback(t *testing.T) {
+	srv := newDiscoveryServer(t)
+	kube := newFakeClient(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: metav1.NamespaceSystem},
+		Data:       map[string]string{CAKey: "cluster-ca-pem"},
+	})
+
+	result, err := join(context.Background(), kube, srv.Client(), srv.URL, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.CACert) != "cluster-ca-pem" {
+		t.Errorf("unexpected CA %q", result.CACert)
+	}
+}
+
+func TestJoin_DiscoveryFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	kube := newFakeClient()
+
+	if _, err := join(context.Background(), kube, srv.Client(), srv.URL, []byte("ca-pem"), Options{}); err == nil {
+		t.Fatal("expected error when issuer discovery fails")
+	}
+	// Nothing is created in a cluster that can't be federated
+	if len(kube.Actions()) != 0 {
+		t.Errorf("expected no API calls, got %v", kube.Actions())
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	result := &Result{
+		Issuer:    "https://oidc.cluster-b.example.com",
+		APIServer: "https://cluster-b.example.com:6443",
+		Token:     "bootstrap-token",
+		CACert:    []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+	}
+
+	var stanza bytes.Buffer
+	if err := WriteClusterConfig(&stanza, "cluster-b", result.ClusterConfig(DefaultMountPath+"/cluster-b")); err != nil {
+		t.Fatal(err)
+	}
+	want := `clusters:
+  cluster-b:
+    issuer: https://oidc.cluster-b.example.com
+    api_server: https://cluster-b.example.com:6443
+    ca_cert: /etc/kube-federated-auth/clusters/cluster-b/ca.crt
+    token_path: /etc/kube-federated-auth/clusters/cluster-b/token
+`
+	if stanza.String() != want {
+		t.Errorf("cluster config:\n%s\nwant:\n%s", stanza.String(), want)
+	}
+
+	var manifest bytes.Buffer
+	if err := WriteSecret(&manifest, result.Secret("cluster-b-bootstrap", "kube-federated-auth")); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"kind: Secret", "  name: cluster-b-bootstrap", "  namespace: kube-federated-auth", "  token: bootstrap-token", "  ca.crt: |"} {
+		if !strings.Contains(manifest.String(), line+"\n") {
+			t.Errorf("manifest is missing %q:\n%s", line, manifest.String())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package oidc
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Reasons a token fails verification against a cluster
+const (
+	ReasonMalformed            = "malformed"
+	ReasonUnsupportedAlgorithm = "unsupported_alg"
+	ReasonIssuerMismatch       = "issuer_mismatch"
+	ReasonUnknownKeyID         = "unknown_kid"
+	ReasonBadSignature         = "bad_signature"
+	ReasonExpired              = "expired"
+	ReasonInvalidClaims        = "invalid_claims"
+	ReasonJWKSUnavailable      = "jwks_unavailable"
+)
+
+// VerifyError is a token that failed verification against a cluster
+type VerifyError struct {
+	// Reason is one of the Reason* constants
+	Reason string
+	Err    error
+}
+
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// FailureReason returns the reason of a verification error, or "" if it isn't a *VerifyError
+func FailureReason(err error) string {
+	var verifyErr *VerifyError
+	if errors.As(err, &verifyErr) {
+		return verifyErr.Reason
+	}
+	return ""
+}
+
+// verifyFailureReason classifies an error from go-oidc's IDTokenVerifier.Verify
+func verifyFailureReason(err error) string {
+	var expired *oidc.TokenExpiredError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &expired):
+		return ReasonExpired
+	case strings.Contains(msg, "fetching keys"):
+		return ReasonJWKSUnavailable
+	case strings.Contains(msg, "failed to verify signature"):
+		return ReasonBadSignature
+	case strings.Contains(msg, "malformed jwt"), strings.Contains(msg, "not signed"), strings.Contains(msg, "multiple signatures"):
+		return ReasonMalformed
+	}
+	return ReasonInvalidClaims
+}
@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// TokenInfo is the content of a JWT, decoded without verifying its signature
+type TokenInfo struct {
+	Algorithm string
+	KeyID     string
+	Issuer    string
+	Subject   string
+	Audience  []string
+	// Expiry, IssuedAt and NotBefore are zero if the claim is absent
+	Expiry    time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time
+
+	Header map[string]any
+	Claims map[string]any
+}
+
+// ParseUnverified decodes a JWT's header and claims. Nothing about the token is
+// trusted: use it only for diagnostics or to select clusters to verify against.
+func ParseUnverified(rawToken string) (*TokenInfo, error) {
+	header, payload, _, err := splitToken(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TokenInfo{}
+	if err := decodeSegment(header, &info.Header); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	if err := decodeSegment(payload, &info.Claims); err != nil {
+		return nil, fmt.Errorf("decoding claims: %w", err)
+	}
+
+	info.Algorithm, _ = info.Header["alg"].(string)
+	info.KeyID, _ = info.Header["kid"].(string)
+	info.Issuer, _ = info.Claims["iss"].(string)
+	info.Subject, _ = info.Claims["sub"].(string)
+	switch aud := info.Claims["aud"].(type) {
+	case string:
+		info.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				info.Audience = append(info.Audience, s)
+			}
+		}
+	}
+	info.Expiry = numericDate(info.Claims["exp"])
+	info.IssuedAt = numericDate(info.Claims["iat"])
+	info.NotBefore = numericDate(info.Claims["nbf"])
+	return info, nil
+}
+
+func numericDate(v any) time.Time {
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
+
+// ClusterCheck is the result of checking a token against one cluster
+type ClusterCheck struct {
+	Cluster string
+	Issuer  string
+	// IssuerMatch is true if the token's iss is the cluster's issuer
+	IssuerMatch bool
+	// KeyIDs are the kids published in the cluster's JWKS; KeyIDMatch is true if the
+	// token's kid is one of them
+	KeyIDs     []string
+	KeyIDMatch bool
+	JWKSError  error
+	// VerifyError is nil if the token verified against the cluster
+	VerifyError error
+	Claims      *Claims
+}
+
+// Inspect checks a token against every configured cluster: whether the issuer and kid
+// match, and whether it verifies against the cluster's JWKS. The token itself is never
+// sent anywhere; only discovery documents and JWKS are fetched.
+func (m *VerifierManager) Inspect(ctx context.Context, rawToken string) (*TokenInfo, []ClusterCheck, error) {
+	info, err := ParseUnverified(rawToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := m.config.ClusterNames()
+	checks := make([]ClusterCheck, 0, len(names))
+	for _, name := range names {
+		cfg, ok := m.config.Cluster(name)
+		if !ok {
+			continue
+		}
+		check := ClusterCheck{
+			Cluster:     name,
+			Issuer:      cfg.Issuer,
+			IssuerMatch: cfg.Issuer == info.Issuer,
+		}
+		check.KeyIDs, check.JWKSError = m.KeyIDs(ctx, name)
+		check.KeyIDMatch = info.KeyID != "" && slices.Contains(check.KeyIDs, info.KeyID)
+		if check.JWKSError != nil {
+			check.VerifyError = fmt.Errorf("JWKS unavailable")
+		} else {
+			check.Claims, check.VerifyError = m.Verify(ctx, name, rawToken)
+		}
+		checks = append(checks, check)
+	}
+	return info, checks, nil
+}
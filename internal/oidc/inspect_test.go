@@ -0,0 +1,184 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+type staticClients struct {
+	client *http.Client
+}
+
+func (s staticClients) DiscoveryClient(string) (*http.Client, error) {
+	return s.client, nil
+}
+
+// testCluster serves OIDC discovery and a JWKS with one key, and signs tokens with it
+type testCluster struct {
+	server *httptest.Server
+	signer jose.Signer
+}
+
+func newTestCluster(t *testing.T, keyID string) *testCluster {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: keyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCluster{signer: signer}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": c.server.URL, "jwks_uri": c.server.URL + "/openid/v1/jwks"})
+	})
+	mux.HandleFunc("/openid/v1/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: keyID, Algorithm: "RS256", Use: "sig"}}})
+	})
+	c.server = httptest.NewServer(mux)
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+func (c *testCluster) token(t *testing.T, audience []string, expiry time.Time) string {
+	t.Helper()
+	return c.tokenWithIssuer(t, c.server.URL, audience, expiry)
+}
+
+func (c *testCluster) tokenWithIssuer(t *testing.T, issuer string, audience []string, expiry time.Time) string {
+	t.Helper()
+	token, err := jwt.Signed(c.signer).Claims(jwt.Claims{
+		Issuer:   issuer,
+		Subject:  "system:serviceaccount:default:app",
+		Audience: audience,
+		Expiry:   jwt.NewNumericDate(expiry),
+		IssuedAt: jwt.NewNumericDate(expiry.Add(-time.Hour)),
+	}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseUnverified(t *testing.T) {
+	c := newTestCluster(t, "key-1")
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	info, err := ParseUnverified(c.token(t, []string{"vault", "https://kubernetes.default.svc"}, expiry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Algorithm != "RS256" || info.KeyID != "key-1" {
+		t.Errorf("unexpected header: alg %q, kid %q", info.Algorithm, info.KeyID)
+	}
+	if info.Issuer != c.server.URL || info.Subject != "system:serviceaccount:default:app" {
+		t.Errorf("unexpected claims: iss %q, sub %q", info.Issuer, info.Subject)
+	}
+	if !slices.Equal(info.Audience, []string{"vault", "https://kubernetes.default.svc"}) {
+		t.Errorf("unexpected audience %v", info.Audience)
+	}
+	if !info.Expiry.Equal(expiry) || !info.IssuedAt.Equal(expiry.Add(-time.Hour)) || !info.NotBefore.IsZero() {
+		t.Errorf("unexpected times: exp %v, iat %v, nbf %v", info.Expiry, info.IssuedAt, info.NotBefore)
+	}
+
+	for _, malformed := range []string{"", "abc", "a.b", "!!.e30.sig", "e30.!!.sig", "bm90IGpzb24.e30.sig"} {
+		if _, err := ParseUnverified(malformed); err == nil {
+			t.Errorf("ParseUnverified(%q): expected error", malformed)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	a := newTestCluster(t, "key-a")
+	b := newTestCluster(t, "key-b")
+	cfg := &config.Config{Clusters: map[string]config.ClusterConfig{
+		"cluster-a": {Issuer: a.server.URL},
+		"cluster-b": {Issuer: b.server.URL},
+		// Same issuer as cluster-a, but its discovery is served by cluster-b
+		"cluster-c": {Issuer: a.server.URL, APIServer: b.server.URL},
+	}}
+	m := NewVerifierManager(cfg, staticClients{client: http.DefaultClient})
+
+	info, checks, err := m.Inspect(context.Background(), a.token(t, []string{"vault"}, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.KeyID != "key-a" {
+		t.Errorf("unexpected kid %q", info.KeyID)
+	}
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 checks, got %d", len(checks))
+	}
+
+	ca, cb, cc := checks[0], checks[1], checks[2]
+	if ca.Cluster != "cluster-a" || !ca.IssuerMatch || !ca.KeyIDMatch || ca.JWKSError != nil || ca.VerifyError != nil {
+		t.Errorf("cluster-a: expected verified token, got %+v", ca)
+	}
+	if ca.Claims == nil || ca.Claims.Subject != "system:serviceaccount:default:app" {
+		t.Errorf("cluster-a: unexpected claims %+v", ca.Claims)
+	}
+	if cb.IssuerMatch || cb.KeyIDMatch || cb.JWKSError != nil || cb.VerifyError == nil {
+		t.Errorf("cluster-b: expected issuer mismatch, got %+v", cb)
+	}
+	if !cc.IssuerMatch || cc.JWKSError == nil || cc.VerifyError == nil {
+		t.Errorf("cluster-c: expected discovery error, got %+v", cc)
+	}
+
+	// Expired tokens are reported by verification
+	_, checks, _ = m.Inspect(context.Background(), a.token(t, []string{"vault"}, time.Now().Add(-time.Minute)))
+	if checks[0].VerifyError == nil || !checks[0].KeyIDMatch {
+		t.Errorf("cluster-a: expected expiry error with matching kid, got %+v", checks[0])
+	}
+}
+
+func TestVerify_FailureReasons(t *testing.T) {
+	a := newTestCluster(t, "key-a")
+	b := newTestCluster(t, "key-b")
+	cfg := &config.Config{Clusters: map[string]config.ClusterConfig{
+		"cluster-a": {Issuer: a.server.URL},
+		"down":      {Issuer: a.server.URL, APIServer: "http://127.0.0.1:1"},
+	}}
+	m := NewVerifierManager(cfg, staticClients{client: http.DefaultClient})
+	valid := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		cluster string
+		token   string
+		reason  string
+	}{
+		{"other issuer", "cluster-a", b.token(t, nil, valid), ReasonIssuerMismatch},
+		{"forged signature", "cluster-a", b.tokenWithIssuer(t, a.server.URL, nil, valid), ReasonBadSignature},
+		{"expired", "cluster-a", a.token(t, nil, time.Now().Add(-time.Minute)), ReasonExpired},
+		{"malformed", "cluster-a", "not-a-jwt", ReasonMalformed},
+		{"unreachable", "down", a.token(t, nil, valid), ReasonJWKSUnavailable},
+	}
+	for _, tt := range tests {
+		_, err := m.Verify(context.Background(), tt.cluster, tt.token)
+		if got := FailureReason(err); got != tt.reason {
+			t.Errorf("%s: reason = %q, want %q (%v)", tt.name, got, tt.reason, err)
+		}
+	}
+
+	if !m.HasVerifier("cluster-a") || m.HasVerifier("down") {
+		t.Error("expected only cluster-a's verifier to be cached")
+	}
+}
@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+// MaxTokenSize is the largest token accepted, in bytes. ServiceAccount tokens are
+// around 1KB; the limit only bounds the work spent on garbage. The API enforces the
+// lower limits.max_token_bytes before tokens get here.
+const MaxTokenSize = config.MaxTokenBytes
+
+// SigningAlgorithms are the JWS algorithms tokens may be signed with
+var SigningAlgorithms = []string{oidc.RS256}
+
+// UnverifiedToken is what selects the clusters a token may come from. Nothing in it is
+// trusted until the token verifies against one of them.
+type UnverifiedToken struct {
+	Algorithm string
+	KeyID     string
+	Issuer    string
+}
+
+// PreParse decodes just enough of a token to select candidate clusters, rejecting
+// tokens that can't verify anywhere: oversized, malformed, unsigned or signed with an
+// unsupported algorithm. Failures are *VerifyError.
+func PreParse(rawToken string) (*UnverifiedToken, error) {
+	malformed := func(format string, args ...any) (*UnverifiedToken, error) {
+		return nil, &VerifyError{Reason: ReasonMalformed, Err: fmt.Errorf(format, args...)}
+	}
+
+	if len(rawToken) > MaxTokenSize {
+		return malformed("token is %d bytes, larger than %d", len(rawToken), MaxTokenSize)
+	}
+	header, payload, signature, err := splitToken(rawToken)
+	if err != nil {
+		return malformed("%v", err)
+	}
+	if header == "" || payload == "" || signature == "" {
+		return malformed("token has an empty part")
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(signature); err != nil {
+		return malformed("decoding signature: %v", err)
+	}
+
+	var h struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(header, &h); err != nil {
+		return malformed("decoding header: %v", err)
+	}
+	if !slices.Contains(SigningAlgorithms, h.Algorithm) {
+		return nil, &VerifyError{Reason: ReasonUnsupportedAlgorithm, Err: fmt.Errorf("unsupported signing algorithm %q", h.Algorithm)}
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := decodeSegment(payload, &claims); err != nil {
+		return malformed("decoding claims: %v", err)
+	}
+	if claims.Issuer == "" {
+		return malformed("token has no issuer")
+	}
+
+	return &UnverifiedToken{Algorithm: h.Algorithm, KeyID: h.KeyID, Issuer: claims.Issuer}, nil
+}
+
+// splitToken splits a compact JWS into its header, payload and signature segments
+func splitToken(rawToken string) (header, payload, signature string, err error) {
+	header, rest, ok := strings.Cut(rawToken, ".")
+	if !ok {
+		return "", "", "", fmt.Errorf("token must have 3 parts")
+	}
+	payload, signature, ok = strings.Cut(rest, ".")
+	if !ok || strings.Contains(signature, ".") {
+		return "", "", "", fmt.Errorf("token must have 3 parts")
+	}
+	return header, payload, signature, nil
+}
+
+// decodeSegment decodes an unpadded base64url JSON object
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Candidates returns the clusters a token may verify against: those whose issuer is
+// the token's, by name, except that clusters that verified a token with the same kid
+// come first.
+// Several clusters often share the default issuer, so the kid usually picks one.
+// Failures are *VerifyError.
+func (m *VerifierManager) Candidates(rawToken string) ([]string, error) {
+	token, err := PreParse(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for _, name := range m.config.ClusterNames() {
+		if cfg, ok := m.config.Cluster(name); ok && cfg.Issuer == token.Issuer {
+			candidates = append(candidates, name)
+		}
+	}
+	slices.Sort(candidates)
+	if token.KeyID != "" {
+		m.mu.RLock()
+		slices.SortStableFunc(candidates, func(a, b string) int {
+			return boolOrder(m.keyIDs[b][token.KeyID]) - boolOrder(m.keyIDs[a][token.KeyID])
+		})
+		m.mu.RUnlock()
+	}
+	return candidates, nil
+}
+
+// rememberKeyID records that a cluster verified a token signed with keyID
+func (m *VerifierManager) rememberKeyID(clusterName, keyID string) {
+	if keyID == "" {
+		return
+	}
+
+	m.mu.RLock()
+	known := m.keyIDs[clusterName][keyID]
+	m.mu.RUnlock()
+	if known {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keyIDs[clusterName] == nil {
+		m.keyIDs[clusterName] = make(map[string]bool)
+	}
+	m.keyIDs[clusterName][keyID] = true
+}
+
+func boolOrder(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rophy/kube-federated-auth/internal/config"
+)
+
+func segment(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func TestPreParse(t *testing.T) {
+	c := newTestCluster(t, "key-1")
+	token, err := PreParse(c.token(t, nil, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *token != (UnverifiedToken{Algorithm: "RS256", KeyID: "key-1", Issuer: c.server.URL}) {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	header, claims := segment(`{"alg":"RS256","kid":"k"}`), segment(`{"iss":"https://a"}`)
+	tests := []struct {
+		name   string
+		token  string
+		reason string
+	}{
+		{"empty", "", ReasonMalformed},
+		{"two parts", header + "." + claims, ReasonMalformed},
+		{"four parts", header + "." + claims + ".sig.sig", ReasonMalformed},
+		{"unsigned", header + "." + claims + ".", ReasonMalformed},
+		{"bad base64", header + ".!!." + "sig", ReasonMalformed},
+		{"padded", header + "." + claims + "==.sig", ReasonMalformed},
+		{"header not json", segment("RS256") + "." + claims + ".sig", ReasonMalformed},
+		{"no issuer", header + "." + segment(`{"sub":"x"}`) + ".sig", ReasonMalformed},
+		{"issuer not a string", header + "." + segment(`{"iss":1}`) + ".sig", ReasonMalformed},
+		{"oversized", header + "." + claims + "." + strings.Repeat("A", MaxTokenSize), ReasonMalformed},
+		{"alg none", segment(`{"alg":"none"}`) + "." + claims + ".sig", ReasonUnsupportedAlgorithm},
+		{"alg HS256", segment(`{"alg":"HS256"}`) + "." + claims + ".sig", ReasonUnsupportedAlgorithm},
+		{"no alg", segment(`{"kid":"k"}`) + "." + claims + ".sig", ReasonUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		_, err := PreParse(tt.token)
+		if got := FailureReason(err); got != tt.reason {
+			t.Errorf("%s: reason = %q, want %q (%v)", tt.name, got, tt.reason, err)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	a := newTestCluster(t, "key-a")
+	b := newTestCluster(t, "key-b")
+	// Clusters sharing an issuer, as with the default kubernetes.default.svc issuer
+	cfg := &config.Config{Clusters: map[string]config.ClusterConfig{
+		"cluster-1": {Issuer: a.server.URL, APIServer: b.server.URL},
+		"cluster-2": {Issuer: a.server.URL},
+		"cluster-3": {Issuer: b.server.URL},
+	}}
+	m := NewVerifierManager(cfg, staticClients{client: http.DefaultClient})
+	token := a.token(t, nil, time.Now().Add(time.Hour))
+
+	candidates, err := m.Candidates(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(candidates, []string{"cluster-1", "cluster-2"}) {
+		t.Errorf("candidates = %v, want clusters with the token's issuer", candidates)
+	}
+
+	// Once cluster-2 verified a token with key-a, it is tried first
+	if _, err := m.Verify(context.Background(), "cluster-2", token); err != nil {
+		t.Fatal(err)
+	}
+	candidates, _ = m.Candidates(token)
+	if !slices.Equal(candidates, []string{"cluster-2", "cluster-1"}) {
+		t.Errorf("candidates = %v, want cluster-2 first", candidates)
+	}
+	m.InvalidateVerifier("cluster-2")
+	if candidates, _ = m.Candidates(token); candidates[0] != "cluster-1" {
+		t.Errorf("candidates = %v, want the kid forgotten on invalidation", candidates)
+	}
+
+	if _, err := m.Candidates("not-a-jwt"); FailureReason(err) != ReasonMalformed {
+		t.Errorf("expected malformed token to be rejected, got %v", err)
+	}
+}
+
+func FuzzPreParse(f *testing.F) {
+	f.Add(segment(`{"alg":"RS256","kid":"k"}`) + "." + segment(`{"iss":"https://a"}`) + ".c2ln")
+	f.Add(segment(`{"alg":"none"}`) + "." + segment(`{"iss":"https://a"}`) + ".")
+	f.Add("a.b.c")
+	f.Add("..")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, raw string) {
+		token, err := PreParse(raw)
+		if err != nil {
+			if FailureReason(err) == "" {
+				t.Errorf("error is not a *VerifyError: %v", err)
+			}
+			return
+		}
+		if len(raw) > MaxTokenSize || strings.Count(raw, ".") != 2 {
+			t.Errorf("accepted a token that is oversized or doesn't have 3 parts: %q", raw)
+		}
+		if !slices.Contains(SigningAlgorithms, token.Algorithm) || token.Issuer == "" {
+			t.Errorf("accepted %+v", token)
+		}
+		// Anything PreParse accepts, the diagnostic parser can decode
+		if _, err := ParseUnverified(raw); err != nil {
+			t.Errorf("ParseUnverified rejected a pre-parsed token: %v", err)
+		}
+	})
+}
+
+func FuzzParseUnverified(f *testing.F) {
+	f.Add(segment(`{"alg":"RS256"}`) + "." + segment(`{"iss":"https://a","aud":["x"],"exp":1}`) + ".sig")
+	f.Add(segment(`{}`) + "." + segment(`{"aud":"x","exp":"soon"}`) + ".")
+	f.Add("a.b")
+	f.Fuzz(func(t *testing.T, raw string) {
+		// Must not panic on any input
+		ParseUnverified(raw)
+	})
+}
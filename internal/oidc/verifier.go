@@ -2,19 +2,15 @@ package oidc
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/rophy/kube-federated-auth/internal/config"
-	"github.com/rophy/kube-federated-auth/internal/credentials"
 )
 
 type Claims struct {
@@ -28,18 +24,26 @@ type Claims struct {
 	Kubernetes map[string]any `json:"kubernetes.io,omitempty"`
 }
 
+// HTTPClientProvider provides the HTTP client for a cluster's OIDC discovery and JWKS requests
+type HTTPClientProvider interface {
+	DiscoveryClient(clusterName string) (*http.Client, error)
+}
+
 type VerifierManager struct {
 	mu        sync.RWMutex
 	verifiers map[string]*oidc.IDTokenVerifier
-	config    *config.Config
-	credStore *credentials.Store
+	// keyIDs are the kids of tokens each cluster verified, to order candidates
+	keyIDs  map[string]map[string]bool
+	config  *config.Config
+	clients HTTPClientProvider
 }
 
-func NewVerifierManager(cfg *config.Config, credStore *credentials.Store) *VerifierManager {
+func NewVerifierManager(cfg *config.Config, clients HTTPClientProvider) *VerifierManager {
 	return &VerifierManager{
 		verifiers: make(map[string]*oidc.IDTokenVerifier),
+		keyIDs:    make(map[string]map[string]bool),
 		config:    cfg,
-		credStore: credStore,
+		clients:   clients,
 	}
 }
 
@@ -48,22 +52,50 @@ func (m *VerifierManager) InvalidateVerifier(clusterName string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	delete(m.verifiers, clusterName)
+	delete(m.keyIDs, clusterName)
+}
+
+// InvalidateAll removes all cached verifiers, forcing JWKS to be re-fetched for every cluster
+func (m *VerifierManager) InvalidateAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verifiers = make(map[string]*oidc.IDTokenVerifier)
+	m.keyIDs = make(map[string]map[string]bool)
 }
 
+// HasVerifier returns true if a verifier for the cluster is cached, so verifying
+// doesn't fetch discovery and JWKS unless the token's key is unknown
+func (m *VerifierManager) HasVerifier(clusterName string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.verifiers[clusterName]
+	return ok
+}
+
+// Verify verifies a token against a cluster's JWKS. Tokens that can't be the cluster's
+// are rejected before fetching anything. Failures are *VerifyError.
 func (m *VerifierManager) Verify(ctx context.Context, clusterName, rawToken string) (*Claims, error) {
-	clusterCfg, ok := m.config.Clusters[clusterName]
+	clusterCfg, ok := m.config.Cluster(clusterName)
 	if !ok {
 		return nil, fmt.Errorf("cluster not found: %s", clusterName)
 	}
 
+	unverified, err := PreParse(rawToken)
+	if err != nil {
+		return nil, err
+	}
+	if unverified.Issuer != clusterCfg.Issuer {
+		return nil, &VerifyError{Reason: ReasonIssuerMismatch, Err: fmt.Errorf("token issuer %q is not %q", unverified.Issuer, clusterCfg.Issuer)}
+	}
+
 	verifier, err := m.getOrCreateVerifier(ctx, clusterName, clusterCfg)
 	if err != nil {
-		return nil, fmt.Errorf("creating verifier: %w", err)
+		return nil, &VerifyError{Reason: ReasonJWKSUnavailable, Err: fmt.Errorf("creating verifier: %w", err)}
 	}
 
 	token, err := verifier.Verify(ctx, rawToken)
 	if err != nil {
-		return nil, fmt.Errorf("verifying token: %w", err)
+		return nil, &VerifyError{Reason: verifyFailureReason(err), Err: fmt.Errorf("verifying token: %w", err)}
 	}
 
 	var rawClaims struct {
@@ -77,6 +109,7 @@ func (m *VerifierManager) Verify(ctx context.Context, clusterName, rawToken stri
 	if err := token.Claims(&rawClaims); err != nil {
 		return nil, fmt.Errorf("parsing claims: %w", err)
 	}
+	m.rememberKeyID(clusterName, unverified.KeyID)
 
 	return &Claims{
 		Cluster:    clusterName,
@@ -112,7 +145,7 @@ func (m *VerifierManager) getOrCreateVerifier(ctx context.Context, name string,
 		return v, nil
 	}
 
-	httpClient, err := m.createHTTPClient(name, cfg)
+	httpClient, err := m.clients.DiscoveryClient(name)
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +155,7 @@ func (m *VerifierManager) getOrCreateVerifier(ctx context.Context, name string,
 	discoveryURL := cfg.DiscoveryURL()
 
 	// Fetch OIDC discovery document from the discovery URL
-	discovery, err := m.fetchDiscovery(ctx, httpClient, discoveryURL)
+	discovery, err := fetchDiscovery(ctx, httpClient, discoveryURL)
 	if err != nil {
 		return nil, fmt.Errorf("fetching OIDC discovery from %s: %w", discoveryURL, err)
 	}
@@ -140,15 +173,95 @@ func (m *VerifierManager) getOrCreateVerifier(ctx context.Context, name string,
 
 	// Create verifier with the actual issuer from the token (not the discovery URL)
 	verifier := oidc.NewVerifier(cfg.Issuer, keySet, &oidc.Config{
-		SkipClientIDCheck: true,
+		SkipClientIDCheck:    true,
+		SupportedSigningAlgs: SigningAlgorithms,
 	})
 
 	m.verifiers[name] = verifier
 	return verifier, nil
 }
 
+// CheckJWKS fetches a cluster's discovery document and JWKS, bypassing the cached
+// verifier, and returns the number of published keys
+func (m *VerifierManager) CheckJWKS(ctx context.Context, clusterName string) (int, error) {
+	keyIDs, err := m.KeyIDs(ctx, clusterName)
+	if err != nil {
+		return 0, err
+	}
+	return len(keyIDs), nil
+}
+
+// KeyIDs fetches a cluster's discovery document and JWKS, bypassing the cached
+// verifier, and returns the kid of each published key. The discovery document must
+// report the configured issuer, since tokens are verified against it.
+func (m *VerifierManager) KeyIDs(ctx context.Context, clusterName string) ([]string, error) {
+	cfg, ok := m.config.Cluster(clusterName)
+	if !ok {
+		return nil, fmt.Errorf("cluster not found: %s", clusterName)
+	}
+
+	httpClient, err := m.clients.DiscoveryClient(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := fetchDiscovery(ctx, httpClient, cfg.DiscoveryURL())
+	if err != nil {
+		return nil, fmt.Errorf("fetching OIDC discovery from %s: %w", cfg.DiscoveryURL(), err)
+	}
+	if discovery.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery reports issuer %q, configured issuer is %q", discovery.Issuer, cfg.Issuer)
+	}
+	jwksURL := discovery.JWKSURL
+	if cfg.APIServer != "" {
+		jwksURL = rewriteJWKSURL(discovery.JWKSURL, cfg.APIServer)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", jwksURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS returned status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no keys")
+	}
+	keyIDs := make([]string, len(jwks.Keys))
+	for i, key := range jwks.Keys {
+		keyIDs[i] = key.KeyID
+	}
+	return keyIDs, nil
+}
+
+// DiscoverIssuer returns the issuer of the OIDC discovery document served at baseURL,
+// e.g. a cluster's API server
+func DiscoverIssuer(ctx context.Context, client *http.Client, baseURL string) (string, error) {
+	discovery, err := fetchDiscovery(ctx, client, baseURL)
+	if err != nil {
+		return "", err
+	}
+	if discovery.Issuer == "" {
+		return "", fmt.Errorf("discovery has no issuer")
+	}
+	return discovery.Issuer, nil
+}
+
 // fetchDiscovery fetches the OIDC discovery document from the given URL
-func (m *VerifierManager) fetchDiscovery(ctx context.Context, client *http.Client, baseURL string) (*oidcDiscovery, error) {
+func fetchDiscovery(ctx context.Context, client *http.Client, baseURL string) (*oidcDiscovery, error) {
 	wellKnownURL := strings.TrimSuffix(baseURL, "/") + "/.well-known/openid-configuration"
 
 	req, err := http.NewRequestWithContext(ctx, "GET", wellKnownURL, nil)
@@ -191,83 +304,3 @@ func rewriteJWKSURL(jwksURL, apiServer string) string {
 	// Fallback: just use the original URL
 	return jwksURL
 }
-
-func (m *VerifierManager) createHTTPClient(clusterName string, cfg config.ClusterConfig) (*http.Client, error) {
-	var transport http.RoundTripper = http.DefaultTransport
-
-	// Check for dynamic credentials first
-	var caCert []byte
-	var token string
-
-	if m.credStore != nil {
-		if creds, ok := m.credStore.Get(clusterName); ok {
-			caCert = creds.CACert
-			token = creds.Token
-		}
-	}
-
-	// Fall back to file-based credentials if no dynamic credentials
-	if caCert == nil && cfg.CACert != "" {
-		var err error
-		caCert, err = os.ReadFile(cfg.CACert)
-		if err != nil {
-			return nil, fmt.Errorf("reading CA cert: %w", err)
-		}
-	}
-
-	if caCert != nil {
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to parse CA cert")
-		}
-
-		transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		}
-	}
-
-	// Use dynamic token if available, otherwise use token file
-	if token != "" {
-		transport = &staticTokenRoundTripper{
-			transport: transport,
-			token:     token,
-		}
-	} else if cfg.TokenPath != "" {
-		transport = &tokenRoundTripper{
-			transport: transport,
-			tokenPath: cfg.TokenPath,
-		}
-	}
-
-	return &http.Client{Transport: transport}, nil
-}
-
-type tokenRoundTripper struct {
-	transport http.RoundTripper
-	tokenPath string
-}
-
-func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	token, err := os.ReadFile(t.tokenPath)
-	if err != nil {
-		return nil, fmt.Errorf("reading token: %w", err)
-	}
-
-	req = req.Clone(req.Context())
-	req.Header.Set("Authorization", "Bearer "+string(token))
-
-	return t.transport.RoundTrip(req)
-}
-
-type staticTokenRoundTripper struct {
-	transport http.RoundTripper
-	token     string
-}
-
-func (t *staticTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	req = req.Clone(req.Context())
-	req.Header.Set("Authorization", "Bearer "+t.token)
-	return t.transport.RoundTrip(req)
-}
@@ -0,0 +1,51 @@
+// Package ringbuffer keeps the most recent values of an in-memory history, such as
+// audit events and renewal attempts.
+package ringbuffer
+
+import "sync"
+
+// Buffer is a fixed-size ring buffer, safe for concurrent use. Once full, each value
+// added replaces the oldest.
+type Buffer[T any] struct {
+	mu     sync.Mutex
+	values []T
+	next   int
+	full   bool
+}
+
+// New creates a buffer retaining up to size values
+func New[T any](size int) *Buffer[T] {
+	return &Buffer[T]{values: make([]T, size)}
+}
+
+// Add stores a value, replacing the oldest if the buffer is full
+func (b *Buffer[T]) Add(v T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.values[b.next] = v
+	b.next = (b.next + 1) % len(b.values)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// List returns up to limit values, newest first. A limit <= 0 returns all.
+func (b *Buffer[T]) List(limit int) []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.next
+	if b.full {
+		n = len(b.values)
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	result := make([]T, 0, limit)
+	for i := 1; i <= limit; i++ {
+		idx := (b.next - i + len(b.values)) % len(b.values)
+		result = append(result, b.values[idx])
+	}
+	return result
+}
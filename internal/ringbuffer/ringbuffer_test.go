@@ -0,0 +1,31 @@
+package ringbuffer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuffer_KeepsMostRecent(t *testing.T) {
+	b := New[string](3)
+	if got := b.List(0); len(got) != 0 {
+		t.Errorf("empty buffer = %v, want []", got)
+	}
+
+	b.Add("a")
+	b.Add("b")
+	if got := b.List(0); !slices.Equal(got, []string{"b", "a"}) {
+		t.Errorf("partial buffer = %v, want [b a]", got)
+	}
+
+	b.Add("c")
+	b.Add("d")
+	if got := b.List(0); !slices.Equal(got, []string{"d", "c", "b"}) {
+		t.Errorf("full buffer = %v, want [d c b]", got)
+	}
+	if got := b.List(2); !slices.Equal(got, []string{"d", "c"}) {
+		t.Errorf("List(2) = %v, want [d c]", got)
+	}
+	if got := b.List(10); len(got) != 3 {
+		t.Errorf("List(10) returned %d values, want 3", len(got))
+	}
+}
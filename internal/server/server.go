@@ -1,37 +1,149 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	federatedauthv1 "github.com/rophy/kube-federated-auth/api/federatedauth/v1"
+	"github.com/rophy/kube-federated-auth/internal/audit"
+	"github.com/rophy/kube-federated-auth/internal/cluster"
 	"github.com/rophy/kube-federated-auth/internal/config"
 	"github.com/rophy/kube-federated-auth/internal/credentials"
 	"github.com/rophy/kube-federated-auth/internal/handler"
+	"github.com/rophy/kube-federated-auth/internal/issuer"
 	"github.com/rophy/kube-federated-auth/internal/oidc"
 )
 
-// Server holds the HTTP handler and verifier manager
+// Server holds the HTTP handler, gRPC server, metrics and ext_authz handlers, cluster
+// connections, verifier manager and credential renewer. The ext_authz check API isn't
+// subject to authorized_clients, so it is served on its own ports.
 type Server struct {
-	Handler  http.Handler
-	Verifier *oidc.VerifierManager
+	Handler      http.Handler
+	GRPC         *grpc.Server // TokenReview API over gRPC, served on its own port
+	Metrics      http.Handler // Prometheus metrics, unauthenticated, so served on their own port
+	ExtAuthz     *grpc.Server // Envoy ext_authz check API over gRPC; nil unless configured
+	ExtAuthzHTTP http.Handler // Envoy ext_authz check API over HTTP; nil unless configured
+	Clusters     *cluster.Manager
+	Verifier     *oidc.VerifierManager
+	Renewer      *credentials.Renewer // nil if there is no credential store
+}
+
+// Options configures optional server behavior
+type Options struct {
+	// DynamicConfig is set when clusters and access policy may change at runtime
+	// (see internal/controller), so the admin API is exposed even if admin_clients
+	// is empty at startup
+	DynamicConfig bool
 }
 
-func New(cfg *config.Config, credStore *credentials.Store, version string) *Server {
+func New(cfg *config.Config, credStore *credentials.Store, version string, opts Options) (*Server, error) {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 
-	verifier := oidc.NewVerifierManager(cfg, credStore)
+	clusters := cluster.NewManager(cfg, credStore)
+	verifier := oidc.NewVerifierManager(cfg, clusters)
+
+	var renewer *credentials.Renewer
+	var credRenewer handler.CredentialRenewer
+	if credStore != nil {
+		renewer = credentials.NewRenewer(cfg, credStore, verifier, clusters)
+		credRenewer = renewer
+	}
 
 	r.Get("/health", handler.NewHealthHandler(version).ServeHTTP)
-	r.Get("/clusters", handler.NewClustersHandler(cfg, credStore).ServeHTTP)
-	r.Post("/apis/authentication.k8s.io/v1/tokenreviews", handler.NewTokenReviewHandler(verifier, cfg, credStore).ServeHTTP)
+	clustersHandler := handler.NewClustersHandler(cfg, credStore)
+	r.Get("/clusters", clustersHandler.ServeHTTP)
+	tokenReview := handler.NewTokenReviewHandler(verifier, cfg, clusters)
+	r.Post("/apis/authentication.k8s.io/v1/tokenreviews", tokenReview.ServeHTTP)
+	r.Post("/tokenreviews/batch", tokenReview.Batch)
+
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(handler.RequestIDInterceptor))
+	federatedauthv1.RegisterTokenReviewServiceServer(grpcServer, handler.NewGRPCServer(tokenReview, clustersHandler))
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+
+	// Envoy ext_authz check API is only exposed when ext_authz is configured, and never
+	// with the TokenReview API
+	var extAuthzGRPC *grpc.Server
+	var extAuthzHTTP http.Handler
+	if cfg.ExtAuthz != nil {
+		extAuthz := handler.NewExtAuthzHandler(tokenReview, cfg.ExtAuthz)
+		extAuthzGRPC = grpc.NewServer(grpc.UnaryInterceptor(handler.RequestIDInterceptor))
+		authv3.RegisterAuthorizationServer(extAuthzGRPC, extAuthz)
+		healthpb.RegisterHealthServer(extAuthzGRPC, health.NewServer())
+
+		extAuthzRouter := chi.NewRouter()
+		extAuthzRouter.Use(middleware.Recoverer)
+		extAuthzRouter.Use(middleware.RequestID)
+		extAuthzRouter.Handle("/*", extAuthz)
+		extAuthzHTTP = extAuthzRouter
+	}
+
+	// OIDC issuer endpoints are only exposed when issuer is configured
+	var iss *issuer.Issuer
+	if cfg.Issuer != nil {
+		var err error
+		iss, err = issuer.New(cfg.Issuer.URL, cfg.Issuer.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("creating issuer: %w", err)
+		}
+		issuerHandler := handler.NewIssuerHandler(tokenReview, iss, cfg.Issuer)
+		r.Get("/.well-known/openid-configuration", issuerHandler.Discovery)
+		r.Get(issuer.JWKSPath, issuerHandler.JWKS)
+		r.Post("/exchange", issuerHandler.Exchange)
+	}
+
+	// Token exchange (RFC 8693) serves issuer tokens and/or mapped ServiceAccount tokens
+	auditLog := audit.NewLog(audit.DefaultSize)
+	if cfg.Issuer != nil || cfg.Exchange != nil {
+		r.Post("/token", handler.NewTokenExchangeHandler(tokenReview, iss, cfg, auditLog).ServeHTTP)
+	}
+	if cfg.Exchange != nil {
+		r.Post("/serviceaccounts/token", handler.NewTokenRequestHandler(tokenReview, cfg, auditLog).ServeHTTP)
+	}
+
+	// Admin API is only exposed when admin_clients is configured
+	if len(cfg.AdminClients) > 0 || opts.DynamicConfig {
+		admin := handler.NewAdminHandler(verifier, verifier, cfg, credStore, credRenewer, auditLog)
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(admin.Authenticate)
+			r.Post("/clusters/{cluster}/renew", admin.Renew)
+			r.Post("/clusters/{cluster}/reload", admin.Reload)
+			r.Post("/clusters/{cluster}/invalidate", admin.Invalidate)
+			r.Post("/cache/purge", admin.PurgeCache)
+			r.Get("/renewals", admin.Renewals)
+			r.Get("/audit", admin.Audit)
+		})
+	}
 
 	return &Server{
-		Handler:  r,
-		Verifier: verifier,
+		Handler:      r,
+		GRPC:         grpcServer,
+		Metrics:      promhttp.Handler(),
+		ExtAuthz:     extAuthzGRPC,
+		ExtAuthzHTTP: extAuthzHTTP,
+		Clusters:     clusters,
+		Verifier:     verifier,
+		Renewer:      renewer,
+	}, nil
+}
+
+// ClusterChanged drops cached verifiers and clients for a cluster added, changed or
+// removed at runtime, and starts or stops its credential renewal
+func (s *Server) ClusterChanged(cluster string) {
+	s.Verifier.InvalidateVerifier(cluster)
+	s.Clusters.Invalidate(cluster)
+	if s.Renewer != nil {
+		s.Renewer.Sync()
 	}
 }
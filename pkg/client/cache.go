@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// identityCache holds the identities of recently authenticated tokens. A nil
+// identityCache caches nothing.
+type identityCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	identity *FederatedIdentity
+	expires  time.Time
+}
+
+func newIdentityCache(size int) *identityCache {
+	return &identityCache{size: size, entries: make(map[string]cacheEntry)}
+}
+
+// get returns a copy of the identity cached under key, if it hasn't expired
+func (c *identityCache) get(key string, now time.Time) (*FederatedIdentity, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	identity := *entry.identity
+	identity.Groups = slices.Clone(identity.Groups)
+	return &identity, true
+}
+
+// put caches identity under key until expires. When the cache is full, expired entries
+// are dropped, then arbitrary ones.
+func (c *identityCache) put(key string, identity *FederatedIdentity, now, expires time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	stored := *identity
+	stored.Groups = slices.Clone(identity.Groups)
+	c.entries[key] = cacheEntry{identity: &stored, expires: expires}
+}
+
+// cacheKey identifies a review by a hash of the token, so tokens aren't kept in memory,
+// and its audiences in any order
+func cacheKey(token string, audiences []string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:]) + "/" + strings.Join(slices.Sorted(slices.Values(audiences)), "\x00")
+}
@@ -0,0 +1,372 @@
+// Package client reviews tokens with kube-federated-auth, for services that
+// authenticate callers from any of its clusters.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultTokenPath is where Kubernetes projects the pod's ServiceAccount token
+const DefaultTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Keys of the TokenReview user's extra set by kube-federated-auth and the API server
+const (
+	ExtraKeyClusterName = "authentication.kubernetes.io/cluster-name"
+	ExtraKeyPodName     = "authentication.kubernetes.io/pod-name"
+)
+
+const (
+	tokenReviewPath              = "/apis/authentication.k8s.io/v1/tokenreviews"
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+	errorCodeHeader              = "X-Error-Code"
+	requestIDHeader              = "X-Request-Id"
+)
+
+// Defaults of Options
+const (
+	DefaultMaxRetries   = 2
+	DefaultRetryBackoff = 200 * time.Millisecond
+	DefaultMaxRetryWait = 5 * time.Second
+	DefaultCacheTTL     = time.Minute
+	DefaultCacheSize    = 10000
+)
+
+// ErrNotAuthenticated matches the Error of a token that was reviewed and isn't
+// authenticated, as opposed to a review that failed
+var ErrNotAuthenticated = errors.New("token not authenticated")
+
+// FederatedIdentity is the ServiceAccount an authenticated token belongs to
+type FederatedIdentity struct {
+	Cluster        string
+	Namespace      string
+	ServiceAccount string
+	PodName        string // empty unless the token is bound to a pod
+	UID            string
+	Groups         []string
+}
+
+// String returns the identity as "cluster/namespace/serviceaccount", the form of
+// authorized_clients
+func (id *FederatedIdentity) String() string {
+	return id.Cluster + "/" + id.Namespace + "/" + id.ServiceAccount
+}
+
+// Error is a token the server didn't authenticate, or a request it refused. Code is
+// one of the server's error codes, e.g. "detection_failed" or "rate_limited".
+type Error struct {
+	StatusCode int // HTTP status; 200 if the token was reviewed
+	Code       string
+	Message    string
+	RequestID  string // under which the server logged the detail
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Is makes a reviewed token that isn't authenticated match ErrNotAuthenticated. A
+// token the server couldn't review, e.g. because its source cluster is down, doesn't
+// match even though the server answers 200.
+func (e *Error) Is(target error) bool {
+	return target == ErrNotAuthenticated && e.StatusCode == http.StatusOK && !e.unavailable()
+}
+
+// unavailableCodes are the error codes of tokens the server couldn't review. The server
+// answers them as unauthenticated TokenReviews, with status 200.
+var unavailableCodes = map[string]bool{
+	"remote_unavailable": true,
+	"not_configured":     true,
+}
+
+// unavailable reports whether the server, or the source cluster of the token, failed
+// or rate limited the request, whatever the status
+func (e *Error) unavailable() bool {
+	return unavailableCodes[e.Code] || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Options configures a Client. The zero value uses the defaults.
+type Options struct {
+	// HTTPClient sends the requests; http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	// TokenPath is the caller's ServiceAccount token, sent to authenticate to the
+	// server. It's read for each request, as the kubelet rotates it; DefaultTokenPath
+	// if empty.
+	TokenPath string
+	// NoCallerToken sends requests without a token, for servers without
+	// authorized_clients
+	NoCallerToken bool
+
+	// MaxRetries is the number of retries of a request that failed on the server side
+	// or was rate limited; DefaultMaxRetries if 0, none if negative
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for each retry
+	RetryBackoff time.Duration
+	// MaxRetryWait is the longest wait before a retry, e.g. asked for by Retry-After;
+	// requests that would wait longer fail instead
+	MaxRetryWait time.Duration
+
+	// CacheTTL is how long the identity of a token is cached, never beyond the token's
+	// expiry; DefaultCacheTTL if 0, no caching if negative. Tokens that aren't
+	// authenticated aren't cached.
+	CacheTTL time.Duration
+	// CacheSize is the most tokens cached; DefaultCacheSize if 0
+	CacheSize int
+}
+
+// Client reviews tokens with a kube-federated-auth server
+type Client struct {
+	url   string
+	opts  Options
+	cache *identityCache
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// New returns a client of the server at baseURL, e.g. "http://kube-federated-auth"
+func New(baseURL string, opts Options) *Client {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.TokenPath == "" {
+		opts.TokenPath = DefaultTokenPath
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	if opts.MaxRetryWait <= 0 {
+		opts.MaxRetryWait = DefaultMaxRetryWait
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = DefaultCacheSize
+	}
+
+	c := &Client{
+		url:   strings.TrimSuffix(baseURL, "/"),
+		opts:  opts,
+		now:   time.Now,
+		sleep: sleep,
+	}
+	if opts.CacheTTL > 0 {
+		c.cache = newIdentityCache(opts.CacheSize)
+	}
+	return c
+}
+
+// Review authenticates token, returning the identity it belongs to. The error matches
+// ErrNotAuthenticated if the token isn't authenticated, and is an *Error if the server
+// answered.
+func (c *Client) Review(ctx context.Context, token string, audiences []string) (*FederatedIdentity, error) {
+	if token == "" {
+		return nil, &Error{StatusCode: http.StatusOK, Code: "invalid_request", Message: "token is required"}
+	}
+	key := cacheKey(token, audiences)
+	if id, ok := c.cache.get(key, c.now()); ok {
+		return id, nil
+	}
+
+	status, err := c.reviewWithRetries(ctx, token, audiences)
+	if err != nil {
+		return nil, err
+	}
+	id, err := identityFromStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	now := c.now()
+	expires := now.Add(c.opts.CacheTTL)
+	if exp, ok := tokenExpiry(token); ok && exp.Before(expires) {
+		expires = exp
+	}
+	c.cache.put(key, id, now, expires)
+	return id, nil
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it, which the server
+// just did. The identity of a token is never cached beyond it.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Expiry *int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Expiry == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(*claims.Expiry, 0), true
+}
+
+// reviewWithRetries sends the review, retrying outages and rate limiting
+func (c *Client) reviewWithRetries(ctx context.Context, token string, audiences []string) (*authv1.TokenReviewStatus, error) {
+	backoff := c.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		status, err := c.review(ctx, token, audiences)
+		if err == nil || attempt >= c.opts.MaxRetries || !retryable(err) {
+			return status, err
+		}
+
+		wait := backoff
+		var reviewErr *Error
+		if errors.As(err, &reviewErr) {
+			wait = max(wait, reviewErr.RetryAfter)
+		}
+		if wait > c.opts.MaxRetryWait {
+			return nil, err
+		}
+		if sleepErr := c.sleep(ctx, wait); sleepErr != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// review sends one TokenReview to the server
+func (c *Client) review(ctx context.Context, token string, audiences []string) (*authv1.TokenReviewStatus, error) {
+	body, err := json.Marshal(&authv1.TokenReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"},
+		Spec:     authv1.TokenReviewSpec{Token: token, Audiences: audiences},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+tokenReviewPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if !c.opts.NoCallerToken {
+		callerToken, err := os.ReadFile(c.opts.TokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading caller token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(callerToken)))
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		reviewErr := responseError(resp)
+		var status metav1.Status
+		if json.Unmarshal(respBody, &status) == nil && status.Message != "" {
+			reviewErr.Message = status.Message
+		}
+		return nil, reviewErr
+	}
+
+	var tr authv1.TokenReview
+	if err := json.Unmarshal(respBody, &tr); err != nil {
+		return nil, fmt.Errorf("decoding TokenReview: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		reviewErr := responseError(resp)
+		reviewErr.Message = tr.Status.Error
+		if reviewErr.Message == "" {
+			reviewErr.Message = ErrNotAuthenticated.Error()
+		}
+		return nil, reviewErr
+	}
+	return &tr.Status, nil
+}
+
+// responseError is the Error of a response, without its message
+func responseError(resp *http.Response) *Error {
+	reviewErr := &Error{
+		StatusCode: resp.StatusCode,
+		Code:       resp.Header.Get(errorCodeHeader),
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get(requestIDHeader),
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		reviewErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return reviewErr
+}
+
+// retryable reports whether a request may succeed if sent again: it failed to reach
+// the server, the server or source cluster failed, or it was rate limited
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var reviewErr *Error
+	if !errors.As(err, &reviewErr) {
+		var pathErr *os.PathError
+		return !errors.As(err, &pathErr)
+	}
+	return reviewErr.unavailable()
+}
+
+// identityFromStatus reads the identity of an authenticated ServiceAccount token
+func identityFromStatus(status *authv1.TokenReviewStatus) (*FederatedIdentity, error) {
+	name, ok := strings.CutPrefix(status.User.Username, serviceAccountUsernamePrefix)
+	namespace, serviceAccount, found := strings.Cut(name, ":")
+	if !ok || !found || namespace == "" || serviceAccount == "" {
+		return nil, &Error{StatusCode: http.StatusOK, Code: "not_service_account", Message: "token is not a ServiceAccount token"}
+	}
+	return &FederatedIdentity{
+		Cluster:        firstExtra(status.User.Extra, ExtraKeyClusterName),
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+		PodName:        firstExtra(status.User.Extra, ExtraKeyPodName),
+		UID:            status.User.UID,
+		Groups:         status.User.Groups,
+	}, nil
+}
+
+func firstExtra(extra map[string]authv1.ExtraValue, key string) string {
+	if values := extra[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
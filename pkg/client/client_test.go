@@ -0,0 +1,323 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeServer answers TokenReviews with respond, counting the requests
+type fakeServer struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newFakeServer(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview)) *fakeServer {
+	t.Helper()
+	s := &fakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if r.URL.Path != tokenReviewPath {
+			t.Errorf("path = %q, want %q", r.URL.Path, tokenReviewPath)
+		}
+		var tr authv1.TokenReview
+		json.NewDecoder(r.Body).Decode(&tr)
+		respond(w, r, &tr)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// authenticated answers a TokenReview of a pod's token from cluster-b
+func authenticated(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+	tr.Status = authv1.TokenReviewStatus{
+		Authenticated: true,
+		User: authv1.UserInfo{
+			Username: "system:serviceaccount:default:my-app",
+			UID:      "sa-uid",
+			Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:default"},
+			Extra: map[string]authv1.ExtraValue{
+				ExtraKeyClusterName: {"cluster-b"},
+				ExtraKeyPodName:     {"my-app-abc"},
+			},
+		},
+		Audiences: tr.Spec.Audiences,
+	}
+	json.NewEncoder(w).Encode(tr)
+}
+
+// failWith answers with a Status, like the server refusing a request
+func failWith(status int, code string, retryAfter string) func(http.ResponseWriter, *http.Request, *authv1.TokenReview) {
+	return func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+		w.Header().Set(errorCodeHeader, code)
+		w.Header().Set(requestIDHeader, "req-1")
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Message: "refused: " + code, Code: int32(status)})
+	}
+}
+
+// unreviewed answers like the server when it couldn't review a token, e.g. because its
+// source cluster is down: an unauthenticated TokenReview with the error code
+func unreviewed(code string) func(http.ResponseWriter, *http.Request, *authv1.TokenReview) {
+	return func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+		w.Header().Set(errorCodeHeader, code)
+		w.Header().Set(requestIDHeader, "req-1")
+		tr.Status = authv1.TokenReviewStatus{Error: "unreviewed: " + code}
+		json.NewEncoder(w).Encode(tr)
+	}
+}
+
+// newTestClient returns a client of s whose caller token is "caller-token", and which
+// doesn't wait between retries
+func newTestClient(t *testing.T, s *fakeServer, opts Options) (*Client, *[]time.Duration) {
+	t.Helper()
+	opts.TokenPath = filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(opts.TokenPath, []byte("caller-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := New(s.URL+"/", opts)
+	var waits []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return c, &waits
+}
+
+func TestReview(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+		if got := r.Header.Get("Authorization"); got != "Bearer caller-token" {
+			t.Errorf("Authorization = %q, want the caller token", got)
+		}
+		if tr.Spec.Token != "subject-token" || len(tr.Spec.Audiences) != 1 || tr.Spec.Audiences[0] != "api" {
+			t.Errorf("spec = %+v", tr.Spec)
+		}
+		authenticated(w, r, tr)
+	})
+	c, _ := newTestClient(t, s, Options{})
+
+	id, err := c.Review(context.Background(), "subject-token", []string{"api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FederatedIdentity{Cluster: "cluster-b", Namespace: "default", ServiceAccount: "my-app", PodName: "my-app-abc", UID: "sa-uid"}
+	if id.Cluster != want.Cluster || id.Namespace != want.Namespace || id.ServiceAccount != want.ServiceAccount || id.PodName != want.PodName || id.UID != want.UID || len(id.Groups) != 2 {
+		t.Errorf("identity = %+v, want %+v", id, want)
+	}
+	if id.String() != "cluster-b/default/my-app" {
+		t.Errorf("String() = %q", id.String())
+	}
+}
+
+func TestReview_NotAuthenticated(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+		w.Header().Set(errorCodeHeader, "detection_failed")
+		tr.Status = authv1.TokenReviewStatus{Error: "token not valid for any configured cluster"}
+		json.NewEncoder(w).Encode(tr)
+	})
+	c, _ := newTestClient(t, s, Options{})
+
+	for range 2 {
+		_, err := c.Review(context.Background(), "unknown-token", nil)
+		var reviewErr *Error
+		if !errors.Is(err, ErrNotAuthenticated) || !errors.As(err, &reviewErr) || reviewErr.Code != "detection_failed" || reviewErr.Message != "token not valid for any configured cluster" {
+			t.Fatalf("err = %v, want detection_failed", err)
+		}
+	}
+	// Neither retried nor cached
+	if s.requests.Load() != 2 {
+		t.Errorf("requests = %d, want 2", s.requests.Load())
+	}
+}
+
+func TestReview_Retries(t *testing.T) {
+	var calls atomic.Int32
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+		switch calls.Add(1) {
+		case 1:
+			unreviewed("remote_unavailable")(w, r, tr)
+		case 2:
+			failWith(http.StatusTooManyRequests, "rate_limited", "2")(w, r, tr)
+		default:
+			authenticated(w, r, tr)
+		}
+	})
+	c, waits := newTestClient(t, s, Options{})
+
+	if _, err := c.Review(context.Background(), "subject-token", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Backoff, then Retry-After as it's longer than the doubled backoff
+	if len(*waits) != 2 || (*waits)[0] != DefaultRetryBackoff || (*waits)[1] != 2*time.Second {
+		t.Errorf("waits = %v", *waits)
+	}
+}
+
+func TestReview_Errors(t *testing.T) {
+	tests := []struct {
+		name         string
+		respond      func(http.ResponseWriter, *http.Request, *authv1.TokenReview)
+		opts         Options
+		wantCode     string
+		wantMessage  string
+		wantRequests int32
+	}{
+		{"caller refused", failWith(http.StatusForbidden, "unauthorized_caller", ""), Options{}, "unauthorized_caller", "refused: unauthorized_caller", 1},
+		{"outage", unreviewed("remote_unavailable"), Options{}, "remote_unavailable", "unreviewed: remote_unavailable", 1 + DefaultMaxRetries},
+		{"not configured", unreviewed("not_configured"), Options{}, "not_configured", "unreviewed: not_configured", 1 + DefaultMaxRetries},
+		{"no retries", unreviewed("remote_unavailable"), Options{MaxRetries: -1}, "remote_unavailable", "unreviewed: remote_unavailable", 1},
+		{"server error", failWith(http.StatusInternalServerError, "not_configured", ""), Options{}, "not_configured", "refused: not_configured", 1 + DefaultMaxRetries},
+		{"retry after too long", failWith(http.StatusTooManyRequests, "rate_limited", "100"), Options{}, "rate_limited", "refused: rate_limited", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, tt.respond)
+			c, _ := newTestClient(t, s, tt.opts)
+
+			_, err := c.Review(context.Background(), "subject-token", nil)
+			var reviewErr *Error
+			if !errors.As(err, &reviewErr) || reviewErr.Code != tt.wantCode || reviewErr.Message != tt.wantMessage || reviewErr.RequestID != "req-1" {
+				t.Fatalf("err = %#v, want %s", err, tt.wantCode)
+			}
+			if errors.Is(err, ErrNotAuthenticated) {
+				t.Error("a refused or unreviewed request matches ErrNotAuthenticated")
+			}
+			if s.requests.Load() != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", s.requests.Load(), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestReview_Cache(t *testing.T) {
+	s := newFakeServer(t, authenticated)
+	c, _ := newTestClient(t, s, Options{CacheTTL: time.Minute})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	id, err := c.Review(context.Background(), "subject-token", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id.Groups[0] = "changed"
+	cached, err := c.Review(context.Background(), "subject-token", []string{"b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.requests.Load() != 1 || cached.Groups[0] != "system:serviceaccounts" {
+		t.Errorf("requests = %d, groups = %v, want the cached identity", s.requests.Load(), cached.Groups)
+	}
+
+	// Other audiences are another review
+	c.Review(context.Background(), "subject-token", nil)
+	if s.requests.Load() != 2 {
+		t.Errorf("requests = %d for other audiences, want 2", s.requests.Load())
+	}
+
+	now = now.Add(time.Minute)
+	c.Review(context.Background(), "subject-token", []string{"a", "b"})
+	if s.requests.Load() != 3 {
+		t.Errorf("requests = %d once expired, want 3", s.requests.Load())
+	}
+}
+
+func TestReview_CacheCappedAtTokenExpiry(t *testing.T) {
+	s := newFakeServer(t, authenticated)
+	c, _ := newTestClient(t, s, Options{CacheTTL: time.Hour})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	claims := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, `{"exp":%d}`, now.Add(time.Minute).Unix()))
+	token := "eyJhbGciOiJSUzI1NiJ9." + claims + ".c2ln"
+
+	c.Review(context.Background(), token, nil)
+	c.Review(context.Background(), token, nil)
+	if s.requests.Load() != 1 {
+		t.Errorf("requests = %d before expiry, want 1", s.requests.Load())
+	}
+
+	now = now.Add(time.Minute)
+	c.Review(context.Background(), token, nil)
+	if s.requests.Load() != 2 {
+		t.Errorf("requests = %d once the token expired, want 2", s.requests.Load())
+	}
+}
+
+func TestIdentityCache_Size(t *testing.T) {
+	cache := newIdentityCache(2)
+	now := time.Now()
+	id := &FederatedIdentity{Cluster: "cluster-b"}
+	cache.put("expired", id, now, now)
+	cache.put("a", id, now, now.Add(time.Minute))
+	cache.put("b", id, now, now.Add(time.Minute))
+	if _, ok := cache.get("a", now); !ok {
+		t.Error("expired entry dropped before a live one")
+	}
+	cache.put("c", id, now, now.Add(time.Minute))
+	if len(cache.entries) != 2 {
+		t.Errorf("entries = %d, want 2", len(cache.entries))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	s := newFakeServer(t, func(w http.ResponseWriter, r *http.Request, tr *authv1.TokenReview) {
+		switch tr.Spec.Token {
+		case "subject-token":
+			authenticated(w, r, tr)
+		case "outage-token":
+			unreviewed("remote_unavailable")(w, r, tr)
+		default:
+			tr.Status = authv1.TokenReviewStatus{Error: "token not authenticated"}
+			json.NewEncoder(w).Encode(tr)
+		}
+	})
+	c, _ := newTestClient(t, s, Options{MaxRetries: -1})
+	handler := c.Middleware([]string{"api"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFrom(r.Context())
+		if !ok {
+			t.Error("no identity in context")
+			return
+		}
+		w.Write([]byte(id.String()))
+	}))
+
+	tests := []struct {
+		authorization string
+		wantStatus    int
+		wantBody      string
+	}{
+		{"Bearer subject-token", http.StatusOK, "cluster-b/default/my-app"},
+		{"", http.StatusUnauthorized, ""},
+		{"Basic dXNlcjpwYXNz", http.StatusUnauthorized, ""},
+		{"Bearer other-token", http.StatusUnauthorized, ""},
+		{"Bearer outage-token", http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+			t.Errorf("%q: status = %d (%q), want %d", tt.authorization, w.Code, w.Body.String(), tt.wantStatus)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: no WWW-Authenticate", tt.authorization)
+		}
+	}
+}
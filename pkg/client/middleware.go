@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type identityKey struct{}
+
+// IdentityFrom returns the identity of the caller authenticated by Middleware
+func IdentityFrom(ctx context.Context) (*FederatedIdentity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*FederatedIdentity)
+	return id, ok
+}
+
+// WithIdentity returns a context carrying id, as Middleware sets it
+func WithIdentity(ctx context.Context, id *FederatedIdentity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// Middleware authenticates requests by their bearer token, reviewed for audiences, and
+// serves those authenticated by next with the caller's identity in the context (see
+// IdentityFrom). Others get 401, or 503 if the token couldn't be reviewed.
+func (c *Client) Middleware(audiences []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "bearer token required", http.StatusUnauthorized)
+				return
+			}
+
+			id, err := c.Review(r.Context(), token, audiences)
+			if err != nil {
+				if errors.Is(err, ErrNotAuthenticated) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, "token not authenticated", http.StatusUnauthorized)
+					return
+				}
+				log.Printf("Token review failed: %v", err)
+				http.Error(w, "authentication unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}